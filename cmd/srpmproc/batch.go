@@ -0,0 +1,138 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	"github.com/rocky-linux/srpmproc/pkg/srpmproc"
+)
+
+// runBatch imports every package of the package list with up to workers
+// imports in parallel. The responses of imported packages are written to
+// stdout as they would be for a single package, failures are listed once
+// all packages are done and make srpmproc exit non-zero.
+func runBatch() {
+	packages, err := readPackageList(packageList)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the state database can only be opened once
+	db := openStateDB()
+	if db != nil {
+		defer db.Close()
+	}
+
+	// packages whose options are invalid fail right away,
+	// the others are imported together
+	results := make([]srpmproc.PackageResult, len(packages))
+	var pds []*data.ProcessData
+	var indexes []int
+	for i, pkg := range packages {
+		pd, err := srpmproc.NewProcessData(newRequest(pkg))
+		if err != nil {
+			results[i] = srpmproc.PackageResult{Package: pkg, Err: err, Error: err.Error()}
+			continue
+		}
+		if progress {
+			pd.ProgressReporter = newLogProgress(pd.Log)
+		}
+		if db != nil {
+			pd.ImportState = db
+		}
+		pds = append(pds, pd)
+		indexes = append(indexes, i)
+	}
+	for j, result := range srpmproc.RunPackages(context.Background(), pds, workers) {
+		result.Package = packages[indexes[j]]
+		results[indexes[j]] = result
+	}
+
+	var failed []srpmproc.PackageResult
+	encoder := json.NewEncoder(os.Stdout)
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result)
+			continue
+		}
+		err := encoder.Encode(result.Result.Response)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if resultFile != "" {
+		err = writeResult(resultFile, results)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(failed) > 0 {
+		log.Printf("%d of %d packages failed to import:", len(failed), len(results))
+		for _, result := range failed {
+			log.Printf("  %s: %v", result.Package, result.Err)
+		}
+		os.Exit(1)
+	}
+}
+
+// readPackageList reads the package names or locations listed in the
+// file at path, or stdin if path is -. Blank lines and lines starting
+// with # are skipped.
+func readPackageList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open package list: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var packages []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		packages = append(packages, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read package list: %v", err)
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("package list %s is empty", path)
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+var configFile string
+
+func init() {
+	root.PersistentFlags().StringVar(&configFile, "config", "", "Config file (YAML, TOML or JSON) providing defaults for any flag (default $HOME/.srpmproc.yaml)")
+
+	cobra.OnInitialize(loadConfig)
+}
+
+// loadConfig reads the config file and uses its values for every flag
+// not given on the command line. Keys are named like the flags, the
+// s3-* settings of the S3 blob storage can be set there as well.
+func loadConfig() {
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return
+		}
+		viper.AddConfigPath(home)
+		viper.SetConfigName(".srpmproc")
+	}
+
+	err := viper.ReadInConfig()
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok && configFile == "" {
+			return
+		}
+		log.Fatalf("could not read config file: %v", err)
+	}
+
+	for _, cmd := range append([]*cobra.Command{root}, root.Commands()...) {
+		err := applyConfig(cmd.Flags())
+		if err != nil {
+			log.Fatalf("invalid config file %s: %v", viper.ConfigFileUsed(), err)
+		}
+	}
+}
+
+// applyConfig sets the flags of fs that were not given on the
+// command line to their value in the config file, if any
+func applyConfig(fs *pflag.FlagSet) error {
+	var errs []string
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || flag.Name == "config" || !viper.IsSet(flag.Name) {
+			return
+		}
+
+		var values []string
+		switch flag.Value.Type() {
+		case "stringSlice":
+			values = viper.GetStringSlice(flag.Name)
+		case "stringToString":
+			for key, value := range viper.GetStringMapString(flag.Name) {
+				values = append(values, key+"="+value)
+			}
+		default:
+			values = []string{viper.GetString(flag.Name)}
+		}
+
+		for _, value := range values {
+			err := fs.Set(flag.Name, value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", flag.Name, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
@@ -21,12 +21,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/rocky-linux/srpmproc/pkg/srpmproc"
 	"log"
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -58,6 +61,74 @@ var (
 	packageRelease       string
 	taglessMode          bool
 	altLookAside         bool
+	overwriteExisting    bool
+	blobCacheMaxBytes    int64
+	sourcesManifestPath  string
+	tlsCaFile            string
+	tlsCertFile          string
+	tlsKeyFile           string
+	snapshotPath         string
+	snapshotExportPath   string
+	lookasideETags       bool
+	pushTargetUrl        string
+	pushTargetForce      bool
+	importCommit         string
+	requestsPerSecond    float64
+	checkoutStrategy     string
+	preserveFileModes    bool
+	sourceMtime          int64
+	previousMetadataPath string
+	lookasideUsername    string
+	lookasidePassword    string
+	lookasideToken       string
+	maxRedirects         int
+	sameHostRedirects    bool
+	sourceCacheUrls      []string
+	sourceSubdir         string
+	latestVersion        bool
+	includeSources       []string
+	excludeSources       []string
+	preflightHead        bool
+	fetchRefSpecs        []string
+	maxBlobSize          int64
+	allowDuplicates      bool
+	hashOverrides        map[string]string
+	commitTemplate       string
+	generateSourcesFile  bool
+	sourcesFileFormat    string
+	lookasideHostTokens  map[string]string
+	strictTagResolution  bool
+	decompressZstdBlobs  bool
+	namespacedBlobs      bool
+	localSourceDir       string
+	sourceKeyring        string
+	signatureWarnOnly    bool
+	slowDownloadBps      int64
+	slowDownloadSeconds  int
+	keepLookaside        bool
+	gitProtocolV2        bool
+	maxRunSeconds        int
+	metadataPattern      string
+	extraRemotes         map[string]string
+	remotePrecedence     []string
+	verifyBeforeStrip    bool
+	bareTarget           string
+	userAgent            string
+	upstreamCacheDir     string
+	compressBlobCache    bool
+	provenancePath       string
+	lookasideBranches    map[string]string
+	importMode           string
+	logLevel             string
+	logFormat            string
+	dryRun               bool
+	resultFile           string
+	progress             bool
+	packageList          string
+	workers              int
+	checkpointDir        string
+	atomicImport         bool
+	hooks                map[string]string
 )
 
 var root = &cobra.Command{
@@ -66,10 +137,53 @@ var root = &cobra.Command{
 }
 
 func mn(_ *cobra.Command, _ []string) {
-	pd, err := srpmproc.NewProcessData(&srpmproc.ProcessDataRequest{
+	if packageList != "" {
+		runBatch()
+		return
+	}
+	if sourceRpm == "" {
+		log.Fatal("either --source-rpm or --package-list is required")
+	}
+
+	pd, err := srpmproc.NewProcessData(newRequest(sourceRpm))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if db := openStateDB(); db != nil {
+		defer db.Close()
+		pd.ImportState = db
+	}
+
+	if progress {
+		pd.ProgressReporter = newLogProgress(pd.Log)
+	}
+
+	result, err := srpmproc.RunProcessData(context.Background(), pd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = json.NewEncoder(os.Stdout).Encode(result.Response)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if resultFile != "" {
+		err = writeResult(resultFile, result)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// newRequest returns the request to import pkg with the options
+// given on the command line
+func newRequest(pkg string) *srpmproc.ProcessDataRequest {
+	return &srpmproc.ProcessDataRequest{
 		Version:              version,
 		StorageAddr:          storageAddr,
-		Package:              sourceRpm,
+		Package:              pkg,
 		ModuleMode:           moduleMode,
 		TmpFsMode:            tmpFsMode,
 		ModulePrefix:         modulePrefix,
@@ -96,61 +210,205 @@ func mn(_ *cobra.Command, _ []string) {
 		PackageRelease:       packageRelease,
 		TaglessMode:          taglessMode,
 		AltLookAside:         altLookAside,
-	})
+		OverwriteExisting:    overwriteExisting,
+		BlobCacheMaxBytes:    blobCacheMaxBytes,
+		SourcesManifestPath:  sourcesManifestPath,
+		TlsCaFile:            tlsCaFile,
+		TlsCertFile:          tlsCertFile,
+		TlsKeyFile:           tlsKeyFile,
+		SnapshotPath:         snapshotPath,
+		SnapshotExportPath:   snapshotExportPath,
+		LookasideETags:       lookasideETags,
+		PushTargetUrl:        pushTargetUrl,
+		PushTargetForce:      pushTargetForce,
+		ImportCommit:         importCommit,
+		RequestsPerSecond:    requestsPerSecond,
+		CheckoutStrategy:     checkoutStrategy,
+		PreserveFileModes:    preserveFileModes,
+		SourceMtime:          sourceMtime,
+		PreviousMetadataPath: previousMetadataPath,
+		LookasideUsername:    lookasideUsername,
+		LookasidePassword:    lookasidePassword,
+		LookasideToken:       lookasideToken,
+		LookasideHostTokens:  lookasideHostTokens,
+		MaxRedirects:         maxRedirects,
+		SameHostRedirects:    sameHostRedirects,
+		SourceCacheURLs:      sourceCacheUrls,
+		SourceSubdir:         sourceSubdir,
+		LatestVersion:        latestVersion,
+		IncludeSources:       includeSources,
+		ExcludeSources:       excludeSources,
+		PreflightHead:        preflightHead,
+		FetchRefSpecs:        fetchRefSpecs,
+		MaxBlobSize:          maxBlobSize,
+		AllowDuplicates:      allowDuplicates,
+		HashOverrides:        hashOverrides,
+		GenerateSourcesFile:  generateSourcesFile,
+		SourcesFileFormat:    sourcesFileFormat,
+		StrictTagResolution:  strictTagResolution,
+		DecompressZstdBlobs:  decompressZstdBlobs,
+		NamespacedBlobs:      namespacedBlobs,
+		LocalSourceDir:       localSourceDir,
+		SourceKeyringPath:    sourceKeyring,
+		SignatureWarnOnly:    signatureWarnOnly,
+		GitProtocolV2:        gitProtocolV2,
+		MaxRunSeconds:        maxRunSeconds,
+		MetadataFilePattern:  metadataPattern,
+		ExtraRemotes:         extraRemotes,
+		RemotePrecedence:     remotePrecedence,
+		VerifyBeforeStrip:    verifyBeforeStrip,
+		BareTargetPath:       bareTarget,
+		UserAgent:            userAgent,
+		UpstreamCacheDir:     upstreamCacheDir,
+		CompressBlobCache:    compressBlobCache,
+		ProvenancePath:       provenancePath,
+		LookasideBranches:    lookasideBranches,
+		ImportMode:           importMode,
+		LogLevel:             logLevel,
+		LogFormat:            logFormat,
+		DryRun:               dryRun,
+		CheckpointDir:        checkpointDir,
+		AtomicImport:         atomicImport,
+		Hooks:                hooks,
 
-	if err != nil {
-		log.Fatal(err)
+		CommitMessageTemplate: commitTemplate,
+		SlowDownloadThreshold: slowDownloadBps,
+		SlowDownloadSeconds:   slowDownloadSeconds,
+		KeepLookasideSources:  keepLookaside,
 	}
+}
 
-	res, err := srpmproc.ProcessRPM(pd)
-	if err != nil {
-		log.Fatal(err)
+// writeResult writes result as JSON to path, or to stdout if path is -
+func writeResult(path string, result interface{}) error {
+	out := os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("could not create result file: %v", err)
+		}
+		defer f.Close()
+		out = f
 	}
 
-	err = json.NewEncoder(os.Stdout).Encode(res)
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(result)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not write result: %v", err)
 	}
 
+	return nil
+
 }
 
 func main() {
-	root.Flags().StringVar(&sourceRpm, "source-rpm", "", "Location of RPM to process")
-	_ = root.MarkFlagRequired("source-rpm")
-	root.Flags().StringVar(&upstreamPrefix, "upstream-prefix", "", "Upstream git repository prefix")
+	root.Flags().StringVar(&sourceRpm, "source-rpm", "", "Location of RPM to process (required unless package-list is set)")
+	root.Flags().StringVar(&resultFile, "result-file", "", "Write a JSON document describing the imported branches, commits, tags, uploaded blobs and applied directives to this path (- for stdout)")
+	root.Flags().StringVar(&packageList, "package-list", "", "File listing package names or locations to import instead of source-rpm, one per line (- for stdin)")
+	root.Flags().IntVar(&workers, "workers", 4, "Number of packages of package-list imported in parallel")
+	addImportFlags(root.Flags())
 	_ = root.MarkFlagRequired("upstream-prefix")
-	root.Flags().IntVar(&version, "version", 0, "Upstream version")
 	_ = root.MarkFlagRequired("version")
-	root.Flags().StringVar(&storageAddr, "storage-addr", "", "Bucket to use as blob storage")
 	_ = root.MarkFlagRequired("storage-addr")
 
-	root.Flags().StringVar(&sshKeyLocation, "ssh-key-location", "", "Location of the SSH key to use to authenticate against upstream")
-	root.Flags().StringVar(&sshUser, "ssh-user", "git", "SSH User")
-	root.Flags().StringVar(&gitCommitterName, "git-committer-name", "rockyautomation", "Name of committer")
-	root.Flags().StringVar(&gitCommitterEmail, "git-committer-email", "rockyautomation@rockylinux.org", "Email of committer")
-	root.Flags().StringVar(&modulePrefix, "module-prefix", "https://git.centos.org/modules", "Where to retrieve modules if exists. Only used when source-rpm is a git repo")
-	root.Flags().StringVar(&rpmPrefix, "rpm-prefix", "https://git.centos.org/rpms", "Where to retrieve SRPM content. Only used when source-rpm is not a local file")
-	root.Flags().StringVar(&importBranchPrefix, "import-branch-prefix", "c", "Import branch prefix")
-	root.Flags().StringVar(&branchPrefix, "branch-prefix", "r", "Branch prefix (replaces import-branch-prefix)")
-	root.Flags().StringVar(&cdnUrl, "cdn-url", "https://git.centos.org/sources", "CDN URL to download blobs from")
-	root.Flags().StringVar(&singleTag, "single-tag", "", "If set, only this tag is imported")
-	root.Flags().BoolVar(&noDupMode, "no-dup-mode", false, "If enabled, skips already imported tags")
-	root.Flags().BoolVar(&moduleMode, "module-mode", false, "If enabled, imports a module instead of a package")
-	root.Flags().StringVar(&tmpFsMode, "tmpfs-mode", "", "If set, packages are imported to path and patched but not pushed")
-	root.Flags().BoolVar(&noStorageDownload, "no-storage-download", false, "If enabled, blobs are always downloaded from upstream")
-	root.Flags().BoolVar(&noStorageUpload, "no-storage-upload", false, "If enabled, blobs are not uploaded to blob storage")
-	root.Flags().StringVar(&manualCommits, "manual-commits", "", "Comma separated branch and commit list for packages with broken release tags (Format: BRANCH:HASH)")
-	root.Flags().StringVar(&moduleFallbackStream, "module-fallback-stream", "", "Override fallback stream. Some module packages are published as collections and mostly use the same stream name, some of them deviate from the main stream")
-	root.Flags().StringVar(&branchSuffix, "branch-suffix", "", "Branch suffix to use for imported branches")
-	root.Flags().BoolVar(&strictBranchMode, "strict-branch-mode", false, "If enabled, only branches with the calculated name are imported and not prefix only")
-	root.Flags().StringVar(&basicUsername, "basic-username", "", "Basic auth username")
-	root.Flags().StringVar(&basicPassword, "basic-password", "", "Basic auth password")
-	root.Flags().StringVar(&packageVersion, "package-version", "", "Package version to fetch")
-	root.Flags().StringVar(&packageRelease, "package-release", "", "Package release to fetch")
-	root.Flags().BoolVar(&taglessMode, "taglessmode", false, "Tagless mode:  If set, pull the latest commit from a branch, and determine version info from spec file (aka upstream versions aren't tagged)")
-	root.Flags().BoolVar(&altLookAside, "altlookaside", false, "If set, uses the new CentOS Stream lookaside pattern (https://<SITE_PREFIX>/<RPM_NAME>/<FILE_NAME>/<SHA_VERSION>/<SHA_SUM>/<FILE_NAME>)")
-
 	if err := root.Execute(); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// addImportFlags adds the flags configuring imports to fs
+func addImportFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&upstreamPrefix, "upstream-prefix", "", "Upstream git repository prefix")
+	fs.IntVar(&version, "version", 0, "Upstream version")
+	fs.StringVar(&storageAddr, "storage-addr", "", "Bucket to use as blob storage")
+	fs.StringVar(&sshKeyLocation, "ssh-key-location", "", "Location of the SSH key to use to authenticate against upstream")
+	fs.StringVar(&sshUser, "ssh-user", "git", "SSH User")
+	fs.StringVar(&gitCommitterName, "git-committer-name", "rockyautomation", "Name of committer")
+	fs.StringVar(&gitCommitterEmail, "git-committer-email", "rockyautomation@rockylinux.org", "Email of committer")
+	fs.StringVar(&modulePrefix, "module-prefix", "https://git.centos.org/modules", "Where to retrieve modules if exists. Only used when source-rpm is a git repo")
+	fs.StringVar(&rpmPrefix, "rpm-prefix", "https://git.centos.org/rpms", "Where to retrieve SRPM content. Only used when source-rpm is not a local file")
+	fs.StringVar(&importBranchPrefix, "import-branch-prefix", "c", "Import branch prefix")
+	fs.StringVar(&branchPrefix, "branch-prefix", "r", "Branch prefix (replaces import-branch-prefix)")
+	fs.StringVar(&cdnUrl, "cdn-url", "https://git.centos.org/sources", "CDN URL to download blobs from")
+	fs.StringVar(&singleTag, "single-tag", "", "If set, only this tag is imported")
+	fs.BoolVar(&noDupMode, "no-dup-mode", false, "If enabled, skips already imported tags, and with state-db upstream tags whose tree is unchanged since the latest import of their branch")
+	fs.BoolVar(&moduleMode, "module-mode", false, "If enabled, imports a module instead of a package")
+	fs.StringVar(&tmpFsMode, "tmpfs-mode", "", "If set, packages are imported to path and patched but not pushed")
+	fs.BoolVar(&noStorageDownload, "no-storage-download", false, "If enabled, blobs are always downloaded from upstream")
+	fs.BoolVar(&noStorageUpload, "no-storage-upload", false, "If enabled, blobs are not uploaded to blob storage")
+	fs.StringVar(&manualCommits, "manual-commits", "", "Comma separated branch and commit list for packages with broken release tags (Format: BRANCH:HASH)")
+	fs.StringVar(&moduleFallbackStream, "module-fallback-stream", "", "Override fallback stream. Some module packages are published as collections and mostly use the same stream name, some of them deviate from the main stream")
+	fs.StringVar(&branchSuffix, "branch-suffix", "", "Branch suffix to use for imported branches")
+	fs.BoolVar(&strictBranchMode, "strict-branch-mode", false, "If enabled, only branches with the calculated name are imported and not prefix only")
+	fs.StringVar(&basicUsername, "basic-username", "", "Basic auth username")
+	fs.StringVar(&basicPassword, "basic-password", "", "Basic auth password")
+	fs.StringVar(&packageVersion, "package-version", "", "Package version to fetch")
+	fs.StringVar(&packageRelease, "package-release", "", "Package release to fetch")
+	fs.BoolVar(&taglessMode, "taglessmode", false, "Tagless mode:  If set, pull the latest commit from a branch, and determine version info from spec file (aka upstream versions aren't tagged)")
+	fs.BoolVar(&altLookAside, "altlookaside", false, "If set, uses the new CentOS Stream lookaside pattern (https://<SITE_PREFIX>/<RPM_NAME>/<FILE_NAME>/<SHA_VERSION>/<SHA_SUM>/<FILE_NAME>)")
+	fs.BoolVar(&overwriteExisting, "overwrite-existing", true, "Rewrite sources already in the worktree, if disabled sources with a matching checksum are not downloaded again")
+	fs.Int64Var(&blobCacheMaxBytes, "blob-cache-max-bytes", 0, "Maximum total size of blobs kept in memory, least recently used blobs are evicted first (0 means unlimited)")
+	fs.StringVar(&sourcesManifestPath, "sources-manifest", "", "If set, a manifest of all externalized sources is committed to this path")
+	fs.StringVar(&tlsCaFile, "tls-ca-file", "", "PEM encoded CA bundle to trust for lookaside downloads")
+	fs.StringVar(&tlsCertFile, "tls-cert-file", "", "PEM encoded client certificate to present to the lookaside")
+	fs.StringVar(&tlsKeyFile, "tls-key-file", "", "PEM encoded key for the client certificate (defaults to tls-cert-file)")
+	fs.StringVar(&snapshotPath, "snapshot", "", "Import offline from a snapshot created with --export-snapshot instead of the upstream repository and lookaside")
+	fs.StringVar(&snapshotExportPath, "export-snapshot", "", "Write a snapshot of the upstream repository and downloaded blobs to this path for later offline imports")
+	fs.BoolVar(&lookasideETags, "lookaside-etags", false, "If enabled, ETags of downloaded blobs are kept in blob storage and blobs are only downloaded again when they changed (useful with no-storage-download)")
+	fs.StringVar(&pushTargetUrl, "push-target", "", "Additional remote URL every imported branch and its tags are pushed to")
+	fs.BoolVar(&pushTargetForce, "push-target-force", false, "Force push to the push target instead of only allowing fast-forward updates")
+	fs.StringVar(&importCommit, "import-commit", "", "Import this upstream commit SHA instead of the latest tags")
+	fs.Float64Var(&requestsPerSecond, "requests-per-second", 0, "Limit git and lookaside requests to each upstream host (0 means unlimited)")
+	fs.StringVar(&checkoutStrategy, "checkout-strategy", "force", "What to do with uncommitted worktree changes when checking out upstream (force, keep or error-on-dirty)")
+	fs.BoolVar(&preserveFileModes, "preserve-file-modes", false, "Keep upstream file modes (e.g. executable bits) on written sources")
+	fs.Int64Var(&sourceMtime, "source-mtime", 0, "Set the modification time of written sources to this unix timestamp")
+	fs.StringVar(&previousMetadataPath, "previous-metadata", "", "Metadata file of the previous import, unchanged sources are taken from disk or blob storage instead of being downloaded")
+	fs.StringVar(&lookasideUsername, "lookaside-username", "", "Username for basic auth on the lookaside cache")
+	fs.StringVar(&lookasidePassword, "lookaside-password", "", "Password for basic auth on the lookaside cache")
+	fs.StringVar(&lookasideToken, "lookaside-token", "", "Bearer token for the lookaside cache (takes precedence over basic auth)")
+	fs.IntVar(&maxRedirects, "max-redirects", 0, "Maximum number of redirects followed by lookaside downloads (0 means 10, negative disables redirects)")
+	fs.BoolVar(&sameHostRedirects, "same-host-redirects", false, "Refuse lookaside redirects to a different host")
+	fs.StringSliceVar(&sourceCacheUrls, "source-cache-url", nil, "Fallback lookaside URL tried when cdn-url does not serve a blob (can be repeated, tried in order)")
+	fs.StringVar(&sourceSubdir, "source-subdir", "", "Write lookaside sources below this directory (e.g. SOURCES) instead of the metadata paths")
+	fs.BoolVar(&latestVersion, "latest-version", false, "Import the newest tags of any upstream version (version is ignored)")
+	fs.StringSliceVar(&includeSources, "include-source", nil, "Only import lookaside sources matching this glob (can be repeated)")
+	fs.StringSliceVar(&excludeSources, "exclude-source", nil, "Do not import lookaside sources matching this glob (can be repeated)")
+	fs.BoolVar(&preflightHead, "preflight-head", false, "Check that lookaside blobs exist with a HEAD request before downloading them")
+	fs.StringSliceVar(&fetchRefSpecs, "fetch-refspec", nil, "Refspec to fetch from upstream instead of every branch (can be repeated)")
+	fs.Int64Var(&maxBlobSize, "max-blob-size", 0, "Refuse to download lookaside blobs larger than this many bytes or decompressing to more (0 means unlimited)")
+	fs.BoolVar(&allowDuplicates, "allow-duplicate-sources", false, "Warn instead of failing when a metadata file lists a path twice with different hashes")
+	fs.StringToStringVar(&hashOverrides, "hash-override", nil, "Replace the metadata hash of a source, as <path or file name>=<hash> (can be repeated)")
+	fs.StringVar(&commitTemplate, "commit-message-template", "", "Go template for import commit messages (fields: Name, ImportName, Version, Branch, UpstreamRef, UpstreamCommit, Tagger, Message)")
+	fs.BoolVar(&generateSourcesFile, "generate-sources-file", false, "Write an rpkg/centpkg compatible sources file listing the externalized sources")
+	fs.StringVar(&sourcesFileFormat, "sources-file-format", "bsd", "Format of the generated sources file (bsd or legacy)")
+	fs.StringToStringVar(&lookasideHostTokens, "lookaside-host-token", nil, "Bearer token for another lookaside host, as <host>=<token> (can be repeated)")
+	fs.BoolVar(&strictTagResolution, "strict-tag-resolution", false, "Fail instead of skipping upstream refs whose commit can't be resolved")
+	fs.BoolVar(&decompressZstdBlobs, "decompress-zstd-blobs", false, "Transparently decompress zstd lookaside blobs whose hash is of the decompressed content")
+	fs.Int64Var(&slowDownloadBps, "slow-download-threshold", 0, "Warn about blob downloads slower than this many bytes per second")
+	fs.IntVar(&slowDownloadSeconds, "slow-download-seconds", 0, "Warn about blob downloads taking longer than this many seconds")
+	fs.BoolVar(&namespacedBlobs, "namespaced-blobs", false, "Store blobs under their hash algorithm (sha256/<hash>) and look them up there first")
+	fs.StringVar(&localSourceDir, "local-source-dir", "", "Directory of pre-fetched blobs named by hash, used before blob storage and the lookaside cache")
+	fs.StringVar(&sourceKeyring, "source-keyring", "", "OpenPGP keyring to verify sources that have a detached signature among the sources")
+	fs.BoolVar(&signatureWarnOnly, "signature-warn-only", false, "Only warn instead of failing on bad source signatures")
+	fs.BoolVar(&keepLookaside, "keep-lookaside-sources", false, "Keep externalized sources in the worktree instead of stripping them")
+	fs.BoolVar(&gitProtocolV2, "git-protocol-v2", false, "Fetch upstream anonymously with the git binary over protocol v2, falling back to go-git with credentials")
+	fs.IntVar(&maxRunSeconds, "max-run-seconds", 0, "Abort the import once it runs longer than this many seconds")
+	fs.StringVar(&metadataPattern, "metadata-file-pattern", "", "Name of the upstream metadata file, %s is replaced with the package name (default .%s.metadata)")
+	fs.StringToStringVar(&extraRemotes, "extra-remote", nil, "Extra upstream repository to merge import tags from, as <name>=<url> (can be repeated)")
+	fs.StringSliceVar(&remotePrecedence, "remote-precedence", nil, "Remote names in order of precedence when a branch exists in several remotes (upstream is the default remote)")
+	fs.BoolVar(&verifyBeforeStrip, "verify-before-strip", false, "Refuse to strip externalized sources modified since they were externalized")
+	fs.StringVar(&bareTarget, "bare-target", "", "Commit imports straight into the bare repository at this path instead of pushing them")
+	fs.StringVar(&userAgent, "user-agent", "", "User-Agent of lookaside requests (default srpmproc/<version>)")
+	fs.StringVar(&upstreamCacheDir, "upstream-cache-dir", "", "Keep fetched upstream repositories in this directory and reuse them on the next run")
+	fs.BoolVar(&compressBlobCache, "compress-blob-cache", false, "Keep cached blobs gzip compressed in memory, trading CPU for memory")
+	fs.StringVar(&provenancePath, "provenance", "", "If set, a JSON record of where the upstream commit and every source came from is committed to this path")
+	fs.StringToStringVar(&lookasideBranches, "lookaside-branch", nil, "Lookaside branch name to use for an upstream git branch, as <git branch>=<lookaside branch> (can be repeated)")
+	fs.StringVar(&importMode, "import-mode", "git", "Import mode retrieving upstream sources")
+	fs.StringVar(&logLevel, "log-level", "info", "Minimum level of logged records (debug, info, warn or error)")
+	fs.StringVar(&logFormat, "log-format", "text", "Format of log records (text or json)")
+	fs.BoolVar(&progress, "progress", false, "Periodically log the progress of upstream fetches, blob downloads and uploads")
+	fs.StringToStringVar(&hooks, "hook", nil, "Executable run with a JSON description of the import on stdin at an import phase, as <phase>=<path> with phase pre-fetch, post-directives, pre-push or post-push (can be repeated)")
+	fs.BoolVar(&atomicImport, "atomic", false, "Only push the imported branches once every branch was imported, rolling back branches pushed already if a later push fails")
+	fs.StringVar(&checkpointDir, "checkpoint-dir", "", "Keep completed branches, downloaded blobs and fetched upstream repositories in this directory so an interrupted import resumes where it stopped")
+	fs.BoolVar(&dryRun, "dry-run", false, "Import in memory and only log the commits, tags and blobs instead of pushing or uploading them")
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// progressInterval is how often progress of an operation is logged
+const progressInterval = 5 * time.Second
+
+// logProgress logs progress events as heartbeats, at most once
+// per progressInterval for every fetch, download and upload
+type logProgress struct {
+	log  *data.Logger
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newLogProgress(log *data.Logger) *logProgress {
+	return &logProgress{
+		log:  log,
+		last: map[string]time.Time{},
+	}
+}
+
+// due reports whether progress of key should be logged now,
+// the final event of an operation is always logged
+func (p *logProgress) due(key string, final bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if final {
+		delete(p.last, key)
+		return true
+	}
+	if time.Since(p.last[key]) < progressInterval {
+		return false
+	}
+	p.last[key] = time.Now()
+
+	return true
+}
+
+func (p *logProgress) FetchProgress(url string, message string) {
+	if p.due("fetch "+url, false) {
+		p.log.Info("fetch progress", "url", url, "progress", message)
+	}
+}
+
+func (p *logProgress) DownloadProgress(url string, bytes int64, total int64) {
+	if p.due("download "+url, bytes == total) {
+		p.log.Info("download progress", "url", url, "bytes", bytes, "total", total)
+	}
+}
+
+func (p *logProgress) UploadProgress(key string, bytes int64, total int64) {
+	if p.due("upload "+key, bytes == total) {
+		p.log.Info("upload progress", "key", key, "bytes", bytes, "total", total)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	"github.com/rocky-linux/srpmproc/pkg/server"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	listenAddr        string
+	grpcListenAddr    string
+	queueSize         int
+	authTokenFile     string
+	serveCertFile     string
+	serveKeyFile      string
+	serveClientCaFile string
+	maxJobs           int
+	jobTTL            time.Duration
+	maxLogLines       int
+)
+
+var serve = &cobra.Command{
+	Use:   "serve",
+	Short: "Run imports requested over HTTP",
+	Long: `Run imports requested over HTTP. POST /import with a JSON body like
+{"package": "bash", "version": 8, "branch": "c8"} queues an import and
+returns its job, GET /jobs/<id> returns the status of a job and GET /jobs
+lists every job. With --grpc-listen, the srpmproc.ImportService gRPC
+service defined in proto/import.proto is served as well over TLS,
+sharing the same jobs and authentication. The flags configure every
+import, version and branch can be overridden per request.
+
+Every request has to send the token of --auth-token-file as
+"Authorization: Bearer <token>", or present a client certificate signed
+by --serve-client-ca-file. The server refuses to start without either.`,
+	Run: runServe,
+}
+
+func init() {
+	addImportFlags(serve.Flags())
+	serve.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:8080", "Address to listen on")
+	serve.Flags().StringVar(&grpcListenAddr, "grpc-listen", "", "Address to serve the gRPC import service on over TLS, disabled if empty")
+	serve.Flags().IntVar(&workers, "workers", 4, "Number of imports run in parallel")
+	serve.Flags().IntVar(&queueSize, "queue-size", 100, "Number of imports that can be queued before requests are rejected")
+	serve.Flags().IntVar(&maxJobs, "max-jobs", server.DefaultMaxJobs, "Number of jobs kept, the oldest finished jobs are dropped beyond it")
+	serve.Flags().DurationVar(&jobTTL, "job-ttl", server.DefaultJobTTL, "How long finished jobs and their logs are kept")
+	serve.Flags().IntVar(&maxLogLines, "max-log-lines", server.DefaultMaxLogLines, "Number of log lines kept per job, older lines are dropped")
+	serve.Flags().StringVar(&authTokenFile, "auth-token-file", "", "File holding the bearer token requests have to present")
+	serve.Flags().StringVar(&serveCertFile, "serve-cert-file", "", "PEM encoded certificate to serve TLS with")
+	serve.Flags().StringVar(&serveKeyFile, "serve-key-file", "", "PEM encoded key of the served certificate (defaults to serve-cert-file)")
+	serve.Flags().StringVar(&serveClientCaFile, "serve-client-ca-file", "", "PEM encoded CA bundle client certificates are verified against, clients presenting one don't need a token")
+	_ = serve.MarkFlagRequired("upstream-prefix")
+	_ = serve.MarkFlagRequired("storage-addr")
+
+	root.AddCommand(serve)
+}
+
+func runServe(_ *cobra.Command, _ []string) {
+	srv := server.New(newRequest(""), queueSize)
+
+	token, err := readAuthToken()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if token == "" && serveClientCaFile == "" {
+		log.Fatal("refusing to serve imports without authentication, set --auth-token-file or --serve-client-ca-file")
+	}
+	srv.Token = token
+	srv.MaxJobs = maxJobs
+	srv.JobTTL = jobTTL
+	srv.MaxLogLines = maxLogLines
+	tlsConfig, err := serveTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := openStateDB()
+	if db != nil {
+		defer db.Close()
+	}
+	srv.Configure = func(pd *data.ProcessData) {
+		if db != nil {
+			pd.ImportState = db
+		}
+		if progress {
+			pd.ProgressReporter = newLogProgress(pd.Log)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx, workers)
+		close(done)
+	}()
+
+	var grpcServer *grpc.Server
+	if grpcListenAddr != "" {
+		if tlsConfig == nil {
+			log.Fatal("--grpc-listen needs --serve-cert-file, the gRPC service is only served over TLS")
+		}
+		lis, err := net.Listen("tcp", grpcListenAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcServer = grpc.NewServer(
+			grpc.Creds(credentials.NewTLS(tlsConfig)),
+			grpc.UnaryInterceptor(srv.UnaryAuthInterceptor()),
+			grpc.StreamInterceptor(srv.StreamAuthInterceptor()),
+		)
+		srpmprocpb.RegisterImportServiceServer(grpcServer, srv.ImportService())
+		go func() {
+			log.Printf("serving gRPC on %s", grpcListenAddr)
+			err := grpcServer.Serve(lis)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	httpServer := &http.Server{Addr: listenAddr, Handler: srv, TLSConfig: tlsConfig}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Printf("shutting down, aborting running imports")
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+		if grpcServer != nil {
+			// log streams only end with their import, don't wait for them
+			grpcServer.Stop()
+		}
+	}()
+
+	log.Printf("listening on %s", listenAddr)
+	if tlsConfig != nil {
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	cancel()
+	<-done
+}
+
+func readAuthToken() (string, error) {
+	if authTokenFile == "" {
+		return "", nil
+	}
+
+	content, err := ioutil.ReadFile(authTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read auth token: %v", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("auth token file %s is empty", authTokenFile)
+	}
+
+	return token, nil
+}
+
+// serveTLSConfig returns the TLS config of the listeners, or nil if no
+// certificate is configured. With a client CA, a certificate signed by
+// it is required unless a token is configured too.
+func serveTLSConfig() (*tls.Config, error) {
+	if serveCertFile == "" {
+		if serveClientCaFile != "" {
+			return nil, fmt.Errorf("--serve-client-ca-file needs --serve-cert-file")
+		}
+		return nil, nil
+	}
+
+	keyFile := serveKeyFile
+	if keyFile == "" {
+		keyFile = serveCertFile
+	}
+	cert, err := tls.LoadX509KeyPair(serveCertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load serve certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if serveClientCaFile != "" {
+		pem, err := ioutil.ReadFile(serveClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", serveClientCaFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if authTokenFile != "" {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return config, nil
+}
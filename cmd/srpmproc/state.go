@@ -0,0 +1,85 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/rocky-linux/srpmproc/pkg/statedb"
+	"github.com/spf13/cobra"
+)
+
+var stateDBPath string
+
+var imports = &cobra.Command{
+	Use:   "imports [package]",
+	Short: "List the imports recorded in the state database",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   runImports,
+}
+
+func init() {
+	root.PersistentFlags().StringVar(&stateDBPath, "state-db", "", "Database file recording every import (upstream tag, sources, target commit and time)")
+
+	root.AddCommand(imports)
+}
+
+// openStateDB opens the state database given with --state-db, or
+// returns nil if there is none
+func openStateDB() *statedb.DB {
+	if stateDBPath == "" {
+		return nil
+	}
+
+	db, err := statedb.Open(stateDBPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return db
+}
+
+func runImports(_ *cobra.Command, args []string) {
+	if stateDBPath == "" {
+		log.Fatal("--state-db is required")
+	}
+	db := openStateDB()
+	defer db.Close()
+
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	records, err := db.Imports(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, record := range records {
+		err := encoder.Encode(record)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -20,8 +20,56 @@
 
 package blob
 
+import "context"
+
 type Storage interface {
 	Write(path string, content []byte) error
 	Read(path string) ([]byte, error)
 	Exists(path string) (bool, error)
 }
+
+// ContextStorage is implemented by storages whose operations
+// can be cancelled or bounded with a deadline through ctx
+type ContextStorage interface {
+	WriteContext(ctx context.Context, path string, content []byte) error
+	ReadContext(ctx context.Context, path string) ([]byte, error)
+	ExistsContext(ctx context.Context, path string) (bool, error)
+}
+
+// WriteContext writes content to path in s, cancelled once ctx is done.
+// Storages not implementing ContextStorage are only checked for a
+// done ctx before the write starts.
+func WriteContext(ctx context.Context, s Storage, path string, content []byte) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.WriteContext(ctx, path, content)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Write(path, content)
+}
+
+// ReadContext reads path from s, cancelled once ctx is done
+func ReadContext(ctx context.Context, s Storage, path string) ([]byte, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.ReadContext(ctx, path)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.Read(path)
+}
+
+// ExistsContext reports whether path exists in s, cancelled once ctx is done
+func ExistsContext(ctx context.Context, s Storage, path string) (bool, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.ExistsContext(ctx, path)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return s.Exists(path)
+}
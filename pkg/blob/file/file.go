@@ -38,6 +38,12 @@ func New(path string) *File {
 }
 
 func (f *File) Write(path string, content []byte) error {
+	// keys may be namespaced like sha256/<hash>
+	err := os.MkdirAll(filepath.Dir(filepath.Join(f.path, path)), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory: %v", err)
+	}
+
 	w, err := os.OpenFile(filepath.Join(f.path, path), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 	if err != nil {
 		return fmt.Errorf("could not open file: %v", err)
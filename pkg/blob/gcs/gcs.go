@@ -44,7 +44,10 @@ func New(name string) (*GCS, error) {
 }
 
 func (g *GCS) Write(path string, content []byte) error {
-	ctx := context.Background()
+	return g.WriteContext(context.Background(), path, content)
+}
+
+func (g *GCS) WriteContext(ctx context.Context, path string, content []byte) error {
 	obj := g.bucket.Object(path)
 	w := obj.NewWriter(ctx)
 
@@ -62,13 +65,17 @@ func (g *GCS) Write(path string, content []byte) error {
 }
 
 func (g *GCS) Read(path string) ([]byte, error) {
-	ctx := context.Background()
+	return g.ReadContext(context.Background(), path)
+}
+
+func (g *GCS) ReadContext(ctx context.Context, path string) ([]byte, error) {
 	obj := g.bucket.Object(path)
 
 	r, err := obj.NewReader(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 
 	body, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -79,8 +86,14 @@ func (g *GCS) Read(path string) ([]byte, error) {
 }
 
 func (g *GCS) Exists(path string) (bool, error) {
-	ctx := context.Background()
+	return g.ExistsContext(context.Background(), path)
+}
+
+func (g *GCS) ExistsContext(ctx context.Context, path string) (bool, error) {
 	obj := g.bucket.Object(path)
-	_, err := obj.NewReader(ctx)
+	_, err := obj.Attrs(ctx)
+	if err != nil && ctx.Err() != nil {
+		return false, ctx.Err()
+	}
 	return err == nil, nil
 }
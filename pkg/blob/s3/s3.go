@@ -22,6 +22,7 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -70,9 +71,13 @@ func New(name string) *S3 {
 }
 
 func (s *S3) Write(path string, content []byte) error {
+	return s.WriteContext(context.Background(), path, content)
+}
+
+func (s *S3) WriteContext(ctx context.Context, path string, content []byte) error {
 	buf := bytes.NewBuffer(content)
 
-	_, err := s.uploader.Upload(&s3manager.UploadInput{
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(path),
 		Body:   buf,
@@ -85,7 +90,11 @@ func (s *S3) Write(path string, content []byte) error {
 }
 
 func (s *S3) Read(path string) ([]byte, error) {
-	obj, err := s.uploader.S3.GetObject(&s3.GetObjectInput{
+	return s.ReadContext(context.Background(), path)
+}
+
+func (s *S3) ReadContext(ctx context.Context, path string) ([]byte, error) {
+	obj, err := s.uploader.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(path),
 	})
@@ -98,6 +107,8 @@ func (s *S3) Read(path string) ([]byte, error) {
 		return nil, nil
 	}
 
+	defer obj.Body.Close()
+
 	body, err := ioutil.ReadAll(obj.Body)
 	if err != nil {
 		return nil, err
@@ -107,9 +118,16 @@ func (s *S3) Read(path string) ([]byte, error) {
 }
 
 func (s *S3) Exists(path string) (bool, error) {
-	_, err := s.uploader.S3.GetObject(&s3.GetObjectInput{
+	return s.ExistsContext(context.Background(), path)
+}
+
+func (s *S3) ExistsContext(ctx context.Context, path string) (bool, error) {
+	_, err := s.uploader.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(path),
 	})
+	if err != nil && ctx.Err() != nil {
+		return false, ctx.Err()
+	}
 	return err == nil, nil
 }
@@ -0,0 +1,185 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// CommitIndex commits the tree staged in the index of src to branch of
+// dst, on top of the current tip of branch if there is one. The blobs,
+// trees and the commit are written straight into the object storage of
+// dst, so dst can be a bare repository. Returns the new commit.
+func CommitIndex(src *git.Repository, dst *git.Repository, branch string, message string, author *object.Signature) (plumbing.Hash, error) {
+	idx, err := src.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not read index: %v", err)
+	}
+
+	root := newTreeNode()
+	for _, entry := range idx.Entries {
+		obj, err := src.Storer.EncodedObject(plumbing.BlobObject, entry.Hash)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("could not read blob of %s: %v", entry.Name, err)
+		}
+		_, err = dst.Storer.SetEncodedObject(obj)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("could not write blob of %s: %v", entry.Name, err)
+		}
+		root.add(strings.Split(entry.Name, "/"), entry.Hash, entry.Mode)
+	}
+
+	treeHash, err := root.write(dst.Storer)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	refName := plumbing.NewBranchReferenceName(branch)
+	var parents []plumbing.Hash
+	tip, err := dst.Reference(refName, true)
+	if err == nil {
+		parents = append(parents, tip.Hash())
+	} else if err != plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, fmt.Errorf("could not resolve %s: %v", refName, err)
+	}
+
+	commit := &object.Commit{
+		Author:       *author,
+		Committer:    *author,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	commitHash, err := writeObject(dst.Storer, commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not write commit: %v", err)
+	}
+
+	err = dst.Storer.SetReference(plumbing.NewHashReference(refName, commitHash))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not update %s: %v", refName, err)
+	}
+
+	return commitHash, nil
+}
+
+// ImportTags returns the import tags of repo as full reference names
+func ImportTags(repo *git.Repository) ([]string, error) {
+	iter, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("could not list tags: %v", err)
+	}
+
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(string(ref.Name()), "refs/tags/imports") {
+			tags = append(tags, string(ref.Name()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list tags: %v", err)
+	}
+
+	return tags, nil
+}
+
+// treeNode is a directory of the tree built by CommitIndex
+type treeNode struct {
+	dirs  map[string]*treeNode
+	files map[string]object.TreeEntry
+}
+
+func newTreeNode() *treeNode {
+	return &treeNode{
+		dirs:  map[string]*treeNode{},
+		files: map[string]object.TreeEntry{},
+	}
+}
+
+func (n *treeNode) add(parts []string, hash plumbing.Hash, mode filemode.FileMode) {
+	if len(parts) == 1 {
+		n.files[parts[0]] = object.TreeEntry{Name: parts[0], Mode: mode, Hash: hash}
+		return
+	}
+
+	dir := n.dirs[parts[0]]
+	if dir == nil {
+		dir = newTreeNode()
+		n.dirs[parts[0]] = dir
+	}
+	dir.add(parts[1:], hash, mode)
+}
+
+// write stores the tree of n and its subtrees and returns its hash
+func (n *treeNode) write(s storer.EncodedObjectStorer) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	for name, dir := range n.dirs {
+		hash, err := dir.write(s)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	for _, entry := range n.files {
+		entries = append(entries, entry)
+	}
+
+	// git orders directories as if their name ended with a slash
+	sortKey := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+
+	hash, err := writeObject(s, &object.Tree{Entries: entries})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not write tree: %v", err)
+	}
+
+	return hash, nil
+}
+
+type encodable interface {
+	Encode(o plumbing.EncodedObject) error
+}
+
+func writeObject(s storer.EncodedObjectStorer, o encodable) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	err := o.Encode(obj)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return s.SetEncodedObject(obj)
+}
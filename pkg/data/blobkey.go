@@ -0,0 +1,77 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+
+	"github.com/rocky-linux/srpmproc/pkg/blob"
+)
+
+// BlobKey returns the key a blob with hash is stored under in blob
+// storage. With NamespacedBlobs set, keys are prefixed with the
+// algorithm of the hash (sha256/<hash>) so hashes of different
+// algorithms never collide.
+func (pd *ProcessData) BlobKey(algorithm string, hash string) string {
+	if !pd.NamespacedBlobs || algorithm == "" {
+		return hash
+	}
+
+	return algorithm + "/" + hash
+}
+
+// ReadBlob reads the blob for checksum from blob storage. The checksum
+// may be prefixed with its algorithm, otherwise it is implied by its
+// length. With NamespacedBlobs set, the algorithm namespace is tried
+// first and the plain hash key second. Returns nil if no blob is stored.
+func (pd *ProcessData) ReadBlob(ctx context.Context, checksum string) ([]byte, error) {
+	algorithm, hash, err := NormalizeHash(checksum)
+	if err != nil {
+		return blob.ReadContext(ctx, pd.BlobStorage, checksum)
+	}
+
+	if pd.NamespacedBlobs {
+		content, err := blob.ReadContext(ctx, pd.BlobStorage, pd.BlobKey(algorithm, hash))
+		if err != nil || content != nil {
+			return content, err
+		}
+	}
+
+	return blob.ReadContext(ctx, pd.BlobStorage, hash)
+}
+
+// WriteBlob writes content to key in blob storage, unless this is a dry run
+func (pd *ProcessData) WriteBlob(ctx context.Context, key string, content []byte) error {
+	if pd.DryRun {
+		pd.Log.Info("dry run: not uploading blob", "key", key, "bytes", len(content))
+		return nil
+	}
+
+	total := int64(len(content))
+	pd.Progress().UploadProgress(key, 0, total)
+	err := blob.WriteContext(ctx, pd.BlobStorage, key, content)
+	if err != nil {
+		return err
+	}
+	pd.Progress().UploadProgress(key, total, total)
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+// BlobCache holds downloaded blobs in memory keyed by their hash.
+// When maxBytes is positive, least recently used blobs are evicted once
+// the cached total exceeds it. Evicted blobs are simply fetched again.
+// It is safe for concurrent use, and a nil cache never stores anything.
+type BlobCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	blobs    map[string]*list.Element
+	compress bool
+}
+
+type blobCacheEntry struct {
+	hash string
+	blob []byte
+	// compressed is set if blob is gzip compressed by the cache
+	compressed bool
+}
+
+func NewBlobCache(maxBytes int64) *BlobCache {
+	return &BlobCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		blobs:    map[string]*list.Element{},
+	}
+}
+
+// NewCompressedBlobCache returns a cache that keeps blobs gzip compressed
+// in memory and decompresses them on read. maxBytes limits the compressed
+// size. Blobs that don't shrink, like tarballs, are kept as they are.
+func NewCompressedBlobCache(maxBytes int64) *BlobCache {
+	c := NewBlobCache(maxBytes)
+	c.compress = true
+	return c
+}
+
+// NewBlobCache returns the blob cache of a single import
+func (pd *ProcessData) NewBlobCache() *BlobCache {
+	if pd.CompressBlobCache {
+		return NewCompressedBlobCache(pd.BlobCacheMaxBytes)
+	}
+	return NewBlobCache(pd.BlobCacheMaxBytes)
+}
+
+func (c *BlobCache) Get(hash string) []byte {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.blobs[hash]
+	if !ok {
+		return nil
+	}
+
+	blob, err := elem.Value.(*blobCacheEntry).content()
+	if err != nil {
+		c.removeElement(elem)
+		return nil
+	}
+	c.order.MoveToFront(elem)
+
+	return blob
+}
+
+func (c *BlobCache) Set(hash string, blob []byte) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.blobs[hash]; ok {
+		c.removeElement(elem)
+	}
+
+	entry := &blobCacheEntry{
+		hash: hash,
+		blob: blob,
+	}
+	if c.compress {
+		if compressed, ok := compressBlob(blob); ok {
+			entry.blob = compressed
+			entry.compressed = true
+		}
+	}
+	if c.maxBytes > 0 && int64(len(entry.blob)) > c.maxBytes {
+		return
+	}
+
+	c.blobs[hash] = c.order.PushFront(entry)
+	c.size += int64(len(entry.blob))
+
+	for c.maxBytes > 0 && c.size > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Size returns the total number of bytes currently cached,
+// after compression for a compressed cache
+func (c *BlobCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// Clear drops every cached blob
+func (c *BlobCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.blobs = map[string]*list.Element{}
+	c.size = 0
+}
+
+func (c *BlobCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*blobCacheEntry)
+	delete(c.blobs, entry.hash)
+	c.size -= int64(len(entry.blob))
+}
+
+func (e *blobCacheEntry) content() ([]byte, error) {
+	if !e.compressed {
+		return e.blob, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(e.blob))
+	if err != nil {
+		return nil, fmt.Errorf("could not read cached blob %s: %v", e.hash, err)
+	}
+	defer r.Close()
+
+	blob, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress cached blob %s: %v", e.hash, err)
+	}
+	return blob, nil
+}
+
+// compressBlob gzip compresses blob, reporting false
+// if that doesn't make it any smaller
+func compressBlob(blob []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := w.Write(blob); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(blob) {
+		return nil, false
+	}
+
+	return buf.Bytes(), true
+}
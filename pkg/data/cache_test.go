@@ -0,0 +1,138 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// patchFixture returns a compressible patch of about size bytes
+func patchFixture(n int, size int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- a/src/file%d.c\n+++ b/src/file%d.c\n", n, n)
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "@@ -%d,3 +%d,3 @@\n-\told_call(%d);\n+\tnew_call(%d);\n", i, i, i, i)
+	}
+
+	return buf.Bytes()
+}
+
+func randomFixture(size int) []byte {
+	blob := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(blob)
+	return blob
+}
+
+func TestBlobCacheCompressed(t *testing.T) {
+	patch := patchFixture(0, 64*1024)
+	random := randomFixture(4096)
+
+	tests := []struct {
+		name     string
+		blob     []byte
+		maxBytes int64
+		cached   bool
+		shrinks  bool
+	}{
+		{name: "compressible", blob: patch, cached: true, shrinks: true},
+		{name: "incompressible", blob: random, cached: true},
+		{name: "empty", blob: []byte{}, cached: true},
+		{name: "limit applies to the compressed size", blob: patch, maxBytes: int64(len(patch)) / 2, cached: true, shrinks: true},
+		{name: "larger than the limit", blob: random, maxBytes: int64(len(random)) / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCompressedBlobCache(tt.maxBytes)
+			c.Set("hash", tt.blob)
+
+			got := c.Get("hash")
+			if !tt.cached {
+				if got != nil || c.Size() != 0 {
+					t.Errorf("expected nothing to be cached, got %d bytes", c.Size())
+				}
+				return
+			}
+			if got == nil || !bytes.Equal(got, tt.blob) {
+				t.Fatalf("expected the cached blob to read back unchanged")
+			}
+			if shrinks := c.Size() < int64(len(tt.blob)); shrinks != tt.shrinks {
+				t.Errorf("expected shrinking %v, cached %d of %d bytes", tt.shrinks, c.Size(), len(tt.blob))
+			}
+		})
+	}
+}
+
+func TestNewBlobCacheCompress(t *testing.T) {
+	patch := patchFixture(0, 64*1024)
+
+	for _, compress := range []bool{false, true} {
+		pd := &ProcessData{CompressBlobCache: compress}
+		c := pd.NewBlobCache()
+		c.Set("hash", patch)
+		if !bytes.Equal(c.Get("hash"), patch) {
+			t.Errorf("compress %v: expected the cached blob to read back unchanged", compress)
+		}
+		if compressed := c.Size() < int64(len(patch)); compressed != compress {
+			t.Errorf("compress %v: cached %d of %d bytes", compress, c.Size(), len(patch))
+		}
+	}
+}
+
+// BenchmarkBlobCache caches and reads back patches with and without
+// compression. cached-bytes is the memory the cache holds for them.
+func BenchmarkBlobCache(b *testing.B) {
+	var hashes []string
+	var patches [][]byte
+	for i := 0; i < 50; i++ {
+		hashes = append(hashes, fmt.Sprintf("%064x", i))
+		patches = append(patches, patchFixture(i, 76*1024))
+	}
+
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "compressed"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var c *BlobCache
+			for i := 0; i < b.N; i++ {
+				c = NewBlobCache(0)
+				if compress {
+					c = NewCompressedBlobCache(0)
+				}
+				for n, patch := range patches {
+					c.Set(hashes[n], patch)
+				}
+				for _, hash := range hashes {
+					if c.Get(hash) == nil {
+						b.Fatal("cached patch is missing")
+					}
+				}
+			}
+			b.ReportMetric(float64(c.Size()), "cached-bytes")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+)
+
+// CheckoutStrategy decides what happens to uncommitted changes
+// in the worktree when the upstream source is checked out
+type CheckoutStrategy int
+
+const (
+	// CheckoutForce discards local changes (default)
+	CheckoutForce CheckoutStrategy = iota
+	// CheckoutKeep keeps local changes on top of the checked out source
+	CheckoutKeep
+	// CheckoutErrorOnDirty refuses to check out over local changes
+	CheckoutErrorOnDirty
+)
+
+// ParseCheckoutStrategy parses "force", "keep" or "error-on-dirty",
+// an empty string selects the default strategy
+func ParseCheckoutStrategy(strategy string) (CheckoutStrategy, error) {
+	switch strategy {
+	case "", "force":
+		return CheckoutForce, nil
+	case "keep":
+		return CheckoutKeep, nil
+	case "error-on-dirty":
+		return CheckoutErrorOnDirty, nil
+	}
+
+	return CheckoutForce, fmt.Errorf("unknown checkout strategy %s", strategy)
+}
+
+// Checkout checks out opts in w according to pd.CheckoutStrategy
+func (pd *ProcessData) Checkout(w *git.Worktree, opts *git.CheckoutOptions) error {
+	switch pd.CheckoutStrategy {
+	case CheckoutKeep:
+		opts.Keep = true
+	case CheckoutErrorOnDirty:
+		status, err := w.Status()
+		if err != nil {
+			return fmt.Errorf("could not get worktree status: %v", err)
+		}
+		if !status.IsClean() {
+			return fmt.Errorf("refusing to checkout over uncommitted changes:\n%s", status)
+		}
+		opts.Force = true
+	default:
+		opts.Force = true
+	}
+
+	return w.Checkout(opts)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+)
+
+// Checkpoint persists the branches of a package that were imported
+// successfully, so an interrupted multi-branch import can be resumed
+// without redoing completed branches
+type Checkpoint interface {
+	// Load returns the completed branches of the package name
+	// mapped to the commit they resulted in, if any
+	Load(name string) (map[string]string, error)
+	// Save marks branch of the package name as completed,
+	// it may be called concurrently by ProcessBranches
+	Save(name string, branch string, commit string) error
+}
+
+// CompletedBranches loads the completed branches of name from the
+// checkpoint store, or returns an empty map if there is none
+func (pd *ProcessData) CompletedBranches(name string) (map[string]string, error) {
+	if pd.Checkpoint == nil {
+		return map[string]string{}, nil
+	}
+
+	completed, err := pd.Checkpoint.Load(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not load checkpoint for %s: %v", name, err)
+	}
+	if completed == nil {
+		completed = map[string]string{}
+	}
+
+	return completed, nil
+}
+
+// SaveCheckpoint marks branch of name as completed in the current
+// run and in the checkpoint store, if one is configured. Dry runs
+// leave the checkpoint store untouched.
+func (pd *ProcessData) SaveCheckpoint(name string, branch string, commit string) error {
+	pd.BranchCompleted(branch)
+	if pd.Checkpoint == nil || pd.DryRun {
+		return nil
+	}
+
+	err := pd.Checkpoint.Save(name, branch, commit)
+	if err != nil {
+		return fmt.Errorf("could not save checkpoint for %s: %v", branch, err)
+	}
+
+	return nil
+}
+
+// processedName is the checkpoint name of the branches of name that
+// ProcessBranches wrote and post-processed. They are not pushed, so they
+// are kept apart from the branches ProcessRPM imported and pushed.
+func processedName(name string) string {
+	return name + "@processed"
+}
+
+// ProcessedBranches loads the branches of name that ProcessBranches
+// completed, mapped to their upstream commit
+func (pd *ProcessData) ProcessedBranches(name string) (map[string]string, error) {
+	return pd.CompletedBranches(processedName(name))
+}
+
+// SaveProcessedBranch marks branch of name as written and post-processed
+// by ProcessBranches from the upstream commit. Unlike SaveCheckpoint, it
+// doesn't mark the branch as imported for ProcessRPM.
+func (pd *ProcessData) SaveProcessedBranch(name string, branch string, commit string) error {
+	if pd.Checkpoint == nil || pd.DryRun {
+		return nil
+	}
+
+	err := pd.Checkpoint.Save(processedName(name), branch, commit)
+	if err != nil {
+		return fmt.Errorf("could not save checkpoint for %s: %v", branch, err)
+	}
+
+	return nil
+}
+
+// BlobCheckpoint is implemented by checkpoint stores that also keep the
+// blobs an import downloaded, so a resumed import does not download them
+// again. The blobs of a package are dropped once its import completed.
+type BlobCheckpoint interface {
+	// LoadBlob returns the blob for hash saved for the package
+	// name, or nil if there is none
+	LoadBlob(name string, hash string) ([]byte, error)
+	// SaveBlob saves the downloaded blob for hash of the package name
+	SaveBlob(name string, hash string, content []byte) error
+	// ClearBlobs drops the saved blobs of the package name
+	ClearBlobs(name string) error
+}
+
+// CheckpointBlob returns the blob for hash of name saved in the
+// checkpoint store, or nil if it does not keep blobs or has none
+func (pd *ProcessData) CheckpointBlob(name string, hash string) ([]byte, error) {
+	store, ok := pd.Checkpoint.(BlobCheckpoint)
+	if !ok {
+		return nil, nil
+	}
+
+	content, err := store.LoadBlob(name, hash)
+	if err != nil {
+		return nil, fmt.Errorf("could not load checkpoint blob for %s: %v", name, err)
+	}
+
+	return content, nil
+}
+
+// SaveCheckpointBlob saves a downloaded blob of name in the
+// checkpoint store, if it keeps blobs
+func (pd *ProcessData) SaveCheckpointBlob(name string, hash string, content []byte) error {
+	store, ok := pd.Checkpoint.(BlobCheckpoint)
+	if !ok || pd.DryRun {
+		return nil
+	}
+
+	err := store.SaveBlob(name, hash, content)
+	if err != nil {
+		return fmt.Errorf("could not save checkpoint blob %s: %v", hash, err)
+	}
+
+	return nil
+}
+
+// ClearableCheckpoint is implemented by checkpoint stores that can drop
+// the completed branches of a package. They are dropped once the import
+// of every branch completed, so the next run imports new upstream
+// commits instead of skipping the branches imported before.
+type ClearableCheckpoint interface {
+	// Clear drops the completed branches of the package name
+	Clear(name string) error
+}
+
+// ClearCheckpoint drops the completed branches and the blobs of name
+// from the checkpoint store once its import completed
+func (pd *ProcessData) ClearCheckpoint(name string) error {
+	if pd.DryRun {
+		return nil
+	}
+
+	if store, ok := pd.Checkpoint.(ClearableCheckpoint); ok {
+		err := store.Clear(name)
+		if err != nil {
+			return fmt.Errorf("could not clear checkpoint for %s: %v", name, err)
+		}
+	}
+
+	if store, ok := pd.Checkpoint.(BlobCheckpoint); ok {
+		err := store.ClearBlobs(name)
+		if err != nil {
+			return fmt.Errorf("could not clear checkpoint blobs for %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
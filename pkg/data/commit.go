@@ -0,0 +1,77 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// CommitMessageData holds the fields available to CommitMessageTemplate
+type CommitMessageData struct {
+	// Name is the package name
+	Name string
+	// ImportName is the name of the import, usually name-version-release
+	ImportName string
+	// Version is the major version being imported
+	Version int
+	// Branch is the branch the import is pushed to
+	Branch string
+	// UpstreamRef is the upstream tag or branch being imported
+	UpstreamRef string
+	// UpstreamCommit is the upstream commit UpstreamRef points to, if known
+	UpstreamCommit string
+	// Tagger is the tagger of the upstream tag, if known
+	Tagger string
+	// Message is the default commit message
+	Message string
+}
+
+// CommitMessage returns the message of the import commit for md. Without a
+// CommitMessageTemplate, defaultMessage is used as is.
+func (pd *ProcessData) CommitMessage(md *ModeData, defaultMessage string) (string, error) {
+	if pd.CommitMessageTemplate == "" {
+		return defaultMessage, nil
+	}
+
+	tmpl, err := template.New("commit").Parse(pd.CommitMessageTemplate)
+	if err != nil {
+		return "", fmt.Errorf("could not parse commit message template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, &CommitMessageData{
+		Name:           md.Name,
+		ImportName:     pd.Importer.ImportName(pd, md),
+		Version:        pd.Version,
+		Branch:         md.PushBranch,
+		UpstreamRef:    md.TagBranch,
+		UpstreamCommit: md.BranchCommits[md.TagBranch],
+		Tagger:         md.BranchTaggers[md.TagBranch],
+		Message:        defaultMessage,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not render commit message: %v", err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// MaxMetadataSize bounds the decompressed size of metadata files
+const MaxMetadataSize = 64 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionFormat detects gzip, xz or zstd compressed content by
+// its magic bytes and returns the format name, or "" for plain content
+func CompressionFormat(content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(content, xzMagic):
+		return "xz"
+	case bytes.HasPrefix(content, zstdMagic):
+		return "zstd"
+	}
+
+	return ""
+}
+
+// Decompress transparently decompresses gzip, xz or zstd metadata and
+// returns plain content unchanged. The decompressed content is limited
+// to MaxMetadataSize.
+func Decompress(content []byte) ([]byte, error) {
+	return DecompressLimit(content, MaxMetadataSize)
+}
+
+// DecompressLimit decompresses gzip, xz or zstd content like Decompress,
+// failing with ErrTooLarge once more than maxSize bytes are decompressed.
+// A maxSize of 0 or less does not limit the decompressed size.
+func DecompressLimit(content []byte, maxSize int64) ([]byte, error) {
+	format := CompressionFormat(content)
+	var r io.Reader
+	switch format {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("could not read gzip content: %v", err)
+		}
+		defer gr.Close()
+		r = gr
+	case "xz":
+		xr, err := xz.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("could not read xz content: %v", err)
+		}
+		r = xr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(content), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("could not read zstd content: %v", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return content, nil
+	}
+
+	plain, err := readLimit(r, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress %s content: %w", format, err)
+	}
+	return plain, nil
+}
+
+// readLimit reads r to the end, failing with ErrTooLarge
+// once more than maxSize bytes are read
+func readLimit(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxSize)
+	}
+	return content, nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const metadataFixture = "# sources\n" +
+	"d41d8cd98f00b204e9800998ecf8427e SOURCES/empty.txt\n" +
+	"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 SOURCES/other.txt\n"
+
+func gzipped(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func xzCompressed(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Write(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, content []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	return w.EncodeAll(content, nil)
+}
+
+func TestCompressionFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		format  string
+	}{
+		{"plain", []byte(metadataFixture), ""},
+		{"empty", nil, ""},
+		{"gzip", gzipped(t, []byte(metadataFixture)), "gzip"},
+		{"xz", append([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, 'x'), "xz"},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, "zstd"},
+		{"truncated magic", []byte{0x1f}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompressionFormat(tt.content); got != tt.format {
+				t.Errorf("expected %q, got %q", tt.format, got)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress func(t *testing.T, content []byte) []byte
+	}{
+		{"plain", func(_ *testing.T, content []byte) []byte { return content }},
+		{"gzip", gzipped},
+		{"xz", xzCompressed},
+		{"zstd", zstdCompressed},
+	}
+
+	want, err := ParseMetadata([]byte(metadataFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := Decompress(tt.compress(t, []byte(metadataFixture)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(plain) != metadataFixture {
+				t.Fatalf("expected %q, got %q", metadataFixture, plain)
+			}
+
+			got, err := ParseMetadata(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("expected %d sources, got %d", len(want), len(got))
+			}
+			for i := range want {
+				if got[i].Path != want[i].Path || got[i].Checksum() != want[i].Checksum() {
+					t.Errorf("expected %s %s, got %s %s", want[i].Checksum(), want[i].Path, got[i].Checksum(), got[i].Path)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	_, err := Decompress([]byte{0x1f, 0x8b, 0x00})
+	if err == nil {
+		t.Error("expected an error for corrupt gzip content")
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 1024)
+
+	tests := []struct {
+		name     string
+		compress func(t *testing.T, content []byte) []byte
+		maxSize  int64
+		err      error
+	}{
+		{"gzip within the limit", gzipped, 1024, nil},
+		{"gzip over the limit", gzipped, 1023, ErrTooLarge},
+		{"xz over the limit", xzCompressed, 100, ErrTooLarge},
+		{"zstd within the limit", zstdCompressed, 2048, nil},
+		{"zstd over the limit", zstdCompressed, 1023, ErrTooLarge},
+		{"zstd without a limit", zstdCompressed, 0, nil},
+		{"plain content is not limited", func(_ *testing.T, content []byte) []byte { return content }, 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := DecompressLimit(tt.compress(t, content), tt.maxSize)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(plain, content) {
+				t.Errorf("expected the content to decompress unchanged")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+// Copy returns a ProcessData for another import with the same options.
+// Maps and slices are copied so either import may change them, while
+// BlobStorage, SharedBlobCache, HostLimiter, Log, HTTPClient and the
+// other shared services, which are safe for concurrent use, are kept.
+// Run state such as branch reports is not copied.
+//
+// A ProcessData must not be used by imports running in parallel, so a
+// template ProcessData should be copied for every import. FetchedRepo
+// and BareTarget are not safe for concurrent use and have to be set on
+// the copy if needed.
+func (pd *ProcessData) Copy() *ProcessData {
+	c := *pd
+	c.run = nil
+	c.busy = 0
+	c.FetchedRepo = nil
+	c.BareTarget = nil
+
+	c.ManualCommits = copyStrings(pd.ManualCommits)
+	c.SourceCacheURLs = copyStrings(pd.SourceCacheURLs)
+	c.IncludeSources = copyStrings(pd.IncludeSources)
+	c.ExcludeSources = copyStrings(pd.ExcludeSources)
+	c.RemotePrecedence = copyStrings(pd.RemotePrecedence)
+	c.DependsOn = copyStrings(pd.DependsOn)
+	if pd.FetchRefSpecs != nil {
+		c.FetchRefSpecs = append([]config.RefSpec{}, pd.FetchRefSpecs...)
+	}
+	if pd.ExtraRemotes != nil {
+		c.ExtraRemotes = append([]UpstreamRemote{}, pd.ExtraRemotes...)
+	}
+	c.PreviousSources = copyStringMap(pd.PreviousSources)
+	c.HashOverrides = copyStringMap(pd.HashOverrides)
+	if pd.Hooks != nil {
+		c.Hooks = map[string][]string{}
+		for phase, hooks := range pd.Hooks {
+			c.Hooks[phase] = copyStrings(hooks)
+		}
+	}
+	if pd.LookasideHostAuth != nil {
+		c.LookasideHostAuth = map[string]*LookasideAuth{}
+		for host, auth := range pd.LookasideHostAuth {
+			c.LookasideHostAuth[host] = auth
+		}
+	}
+
+	return &c
+}
+
+// Fork returns a copy of pd for a branch imported in parallel with
+// other branches of the current run. Unlike Copy, the copy shares the
+// run state of pd, so it is cancelled with the run and its completed
+// branches are reported by pd.
+func (pd *ProcessData) Fork() *ProcessData {
+	c := pd.Copy()
+	c.run = pd.run
+
+	return c
+}
+
+// Acquire marks pd as used by an import. It reports false if another
+// import is using pd already, Release has to be called otherwise.
+func (pd *ProcessData) Acquire() bool {
+	return atomic.CompareAndSwapInt32(&pd.busy, 0, 1)
+}
+
+// Release marks pd as no longer used by an import
+func (pd *ProcessData) Release() {
+	atomic.StoreInt32(&pd.busy, 0)
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append([]string{}, s...)
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
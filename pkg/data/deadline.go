@@ -0,0 +1,98 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runState tracks the branches completed within a run
+// and the reports of imported branches
+type runState struct {
+	mu        sync.Mutex
+	completed []string
+	reports   []BranchReport
+	// deadline is when the run exceeds MaxRunDuration,
+	// zero without MaxRunDuration
+	deadline time.Time
+}
+
+// StartRun starts a run of pd, which is cancelled once parent is done or
+// MaxRunDuration, if set, is exceeded. The import passes the returned
+// context to everything it runs. The returned function releases the
+// deadline and must be called once the run is over.
+func (pd *ProcessData) StartRun(parent context.Context) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	pd.run = &runState{}
+	if pd.MaxRunDuration > 0 {
+		pd.run.deadline = time.Now().Add(pd.MaxRunDuration)
+		ctx, cancel = context.WithDeadline(parent, pd.run.deadline)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+
+	return ctx, cancel
+}
+
+// RunExpired reports whether the run ctx was returned for by
+// StartRun exceeded MaxRunDuration. A deadline of the parent
+// context passing before that is not reported.
+func (pd *ProcessData) RunExpired(ctx context.Context) bool {
+	if pd.run == nil || pd.run.deadline.IsZero() {
+		return false
+	}
+
+	return ctx.Err() == context.DeadlineExceeded && !time.Now().Before(pd.run.deadline)
+}
+
+// BranchCompleted records branch as imported within the current run
+func (pd *ProcessData) BranchCompleted(branch string) {
+	if pd.run == nil {
+		return
+	}
+
+	pd.run.mu.Lock()
+	defer pd.run.mu.Unlock()
+	pd.run.completed = append(pd.run.completed, branch)
+}
+
+// TimeoutError turns err into a *TimeoutError listing the completed
+// branches if the run exceeded MaxRunDuration, otherwise err is
+// returned as is
+func (pd *ProcessData) TimeoutError(ctx context.Context, err error) error {
+	if err == nil || !pd.RunExpired(ctx) {
+		return err
+	}
+
+	pd.run.mu.Lock()
+	defer pd.run.mu.Unlock()
+	completed := make([]string, len(pd.run.completed))
+	copy(completed, pd.run.completed)
+
+	return &TimeoutError{
+		Limit:     pd.MaxRunDuration,
+		Completed: completed,
+		Err:       err,
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeoutError(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxRunDuration time.Duration
+		parentTimeout  time.Duration
+		want           bool
+	}{
+		{
+			name:           "run expired",
+			maxRunDuration: 10 * time.Millisecond,
+			want:           true,
+		},
+		{
+			name:           "parent expired",
+			maxRunDuration: time.Hour,
+			parentTimeout:  10 * time.Millisecond,
+		},
+		{
+			name:          "parent expired without limit",
+			parentTimeout: 10 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := context.Background()
+			if tt.parentTimeout > 0 {
+				var cancel context.CancelFunc
+				parent, cancel = context.WithTimeout(parent, tt.parentTimeout)
+				defer cancel()
+			}
+
+			pd := &ProcessData{MaxRunDuration: tt.maxRunDuration}
+			ctx, cancel := pd.StartRun(parent)
+			defer cancel()
+			pd.BranchCompleted("r8")
+			<-ctx.Done()
+
+			err := pd.TimeoutError(ctx, ctx.Err())
+			var timeoutErr *TimeoutError
+			if got := errors.As(err, &timeoutErr); got != tt.want {
+				t.Fatalf("expected timeout error %v, got %v", tt.want, err)
+			}
+			if tt.want && (len(timeoutErr.Completed) != 1 || timeoutErr.Completed[0] != "r8") {
+				t.Errorf("expected completed branch r8, got %v", timeoutErr.Completed)
+			}
+		})
+	}
+}
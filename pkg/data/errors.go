@@ -0,0 +1,208 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sentinel errors to check import failures against with errors.Is.
+// The error types below carry the context of the failure.
+var (
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrBlobNotFound       = errors.New("blob not found")
+	ErrMetadataMissing    = errors.New("metadata file missing")
+	ErrFetchFailed        = errors.New("fetch failed")
+	ErrListFailed         = errors.New("list failed")
+	ErrRunTimeout         = errors.New("run timeout")
+	ErrDependencyCycle    = errors.New("dependency cycle")
+	ErrDependencyFailed   = errors.New("dependency failed")
+	ErrProcessDataInUse   = errors.New("process data is in use by another import")
+	ErrInvalidProcessData = errors.New("invalid process data")
+	ErrTooLarge           = errors.New("decompressed content is too large")
+)
+
+// ChecksumMismatchError is returned when a source does not match
+// the hash listed for it in the metadata file
+type ChecksumMismatchError struct {
+	Path string
+	Hash string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum in metadata does not match dist-git file %s (wanted %s)", e.Path, e.Hash)
+}
+
+func (e *ChecksumMismatchError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// BlobNotFoundError is returned when no location serves a blob.
+// Url is the last location tried, if any.
+type BlobNotFoundError struct {
+	Hash string
+	Url  string
+	Err  error
+}
+
+func (e *BlobNotFoundError) Error() string {
+	if e.Url == "" {
+		return fmt.Sprintf("could not find blob %s: %v", e.Hash, e.Err)
+	}
+	return fmt.Sprintf("could not download blob %s from %s: %v", e.Hash, e.Url, e.Err)
+}
+
+func (e *BlobNotFoundError) Is(target error) bool {
+	return target == ErrBlobNotFound
+}
+
+func (e *BlobNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// MetadataMissingError is returned when the metadata file
+// of a package exists but cannot be read
+type MetadataMissingError struct {
+	Path string
+	Err  error
+}
+
+func (e *MetadataMissingError) Error() string {
+	return fmt.Sprintf("could not read metadata file %s: %v", e.Path, e.Err)
+}
+
+func (e *MetadataMissingError) Is(target error) bool {
+	return target == ErrMetadataMissing
+}
+
+func (e *MetadataMissingError) Unwrap() error {
+	return e.Err
+}
+
+// FetchError is returned when fetching or listing an upstream repository fails
+type FetchError struct {
+	Url string
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("could not fetch upstream %s: %v", e.Url, e.Err)
+}
+
+func (e *FetchError) Is(target error) bool {
+	return target == ErrFetchFailed
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// ListError is returned together with the mode data by RetrieveSource
+// when listing the refs of the upstream repository fails. The branches
+// found in the fetched tags are still usable.
+type ListError struct {
+	Url string
+	Err error
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("could not list upstream %s: %v", e.Url, e.Err)
+}
+
+func (e *ListError) Is(target error) bool {
+	return target == ErrListFailed
+}
+
+func (e *ListError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when an import exceeds MaxRunDuration.
+// Completed lists the branches imported before the deadline.
+type TimeoutError struct {
+	Limit     time.Duration
+	Completed []string
+	Err       error
+}
+
+func (e *TimeoutError) Error() string {
+	completed := "none"
+	if len(e.Completed) > 0 {
+		completed = strings.Join(e.Completed, ", ")
+	}
+	return fmt.Sprintf("import exceeded maximum run duration of %s (completed branches: %s): %v", e.Limit, completed, e.Err)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrRunTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// ModifiedSourceError is returned when an externalized source no longer
+// matches the content it was externalized with and is not removed
+type ModifiedSourceError struct {
+	Path string
+	Hash string
+}
+
+func (e *ModifiedSourceError) Error() string {
+	return fmt.Sprintf("refusing to remove %s, it was modified since it was externalized as %s", e.Path, e.Hash)
+}
+
+func (e *ModifiedSourceError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// DependencyCycleError is returned for packages that depend on each
+// other through DependsOn. Cycle lists the packages of one cycle,
+// starting and ending with the same package.
+type DependencyCycleError struct {
+	Cycle []string
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("packages depend on each other: %s", strings.Join(e.Cycle, " -> "))
+}
+
+func (e *DependencyCycleError) Is(target error) bool {
+	return target == ErrDependencyCycle
+}
+
+// DependencyFailedError is returned for a package that is not imported
+// because the import of a package it depends on failed
+type DependencyFailedError struct {
+	Package    string
+	Dependency string
+}
+
+func (e *DependencyFailedError) Error() string {
+	return fmt.Sprintf("not importing %s, its dependency %s failed", e.Package, e.Dependency)
+}
+
+func (e *DependencyFailedError) Is(target error) bool {
+	return target == ErrDependencyFailed
+}
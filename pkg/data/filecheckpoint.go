@@ -0,0 +1,154 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// FileCheckpoint is a checkpoint store keeping the state of every
+// package in dir/packages/<name>: the completed branches in
+// branches.json and the downloaded blobs, named by hash, in blobs.
+// Both are removed once the import of the package completed.
+// It is safe for concurrent use.
+type FileCheckpoint struct {
+	mu  sync.Mutex
+	dir string
+	fs  billy.Filesystem
+}
+
+// NewFileCheckpoint returns a checkpoint store in dir, which is
+// created if missing
+func NewFileCheckpoint(dir string) (*FileCheckpoint, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create checkpoint directory: %v", err)
+	}
+
+	return &FileCheckpoint{dir: dir, fs: osfs.New(dir)}, nil
+}
+
+// Dir returns the directory of the checkpoint store
+func (c *FileCheckpoint) Dir() string {
+	return c.dir
+}
+
+func (c *FileCheckpoint) Load(name string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.load(name)
+}
+
+func (c *FileCheckpoint) Save(name string, branch string, commit string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	completed, err := c.load(name)
+	if err != nil {
+		return err
+	}
+	completed[branch] = commit
+
+	content, err := json.MarshalIndent(completed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode checkpoint: %v", err)
+	}
+
+	return WriteFileAtomic(c.fs, c.path(name, "branches.json"), content, 0644)
+}
+
+func (c *FileCheckpoint) Clear(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := os.Remove(filepath.Join(c.dir, c.path(name, "branches.json")))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove checkpoint: %v", err)
+	}
+
+	return nil
+}
+
+func (c *FileCheckpoint) LoadBlob(name string, hash string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(c.dir, c.path(name, "blobs", checkpointName(hash))))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint blob %s: %v", hash, err)
+	}
+
+	return content, nil
+}
+
+func (c *FileCheckpoint) SaveBlob(name string, hash string, content []byte) error {
+	return WriteFileAtomic(c.fs, c.path(name, "blobs", checkpointName(hash)), content, 0644)
+}
+
+func (c *FileCheckpoint) ClearBlobs(name string) error {
+	err := os.RemoveAll(filepath.Join(c.dir, c.path(name, "blobs")))
+	if err != nil {
+		return fmt.Errorf("could not remove checkpoint blobs: %v", err)
+	}
+
+	return nil
+}
+
+// load reads the completed branches of name, c.mu has to be held
+func (c *FileCheckpoint) load(name string) (map[string]string, error) {
+	completed := map[string]string{}
+
+	content, err := ioutil.ReadFile(filepath.Join(c.dir, c.path(name, "branches.json")))
+	if os.IsNotExist(err) {
+		return completed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint: %v", err)
+	}
+
+	err = json.Unmarshal(content, &completed)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode checkpoint: %v", err)
+	}
+
+	return completed, nil
+}
+
+// path returns the path of elem in the directory of
+// the package name, relative to the store directory
+func (c *FileCheckpoint) path(name string, elem ...string) string {
+	return filepath.Join(append([]string{"packages", checkpointName(name)}, elem...)...)
+}
+
+// checkpointName turns name into a single path element
+func checkpointName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(name)
+}
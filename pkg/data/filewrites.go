@@ -0,0 +1,98 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RecordFileWrite records that path should contain content once the
+// import is processed. Recorded writes are applied with ApplyFileWrites.
+func (md *ModeData) RecordFileWrite(path string, content []byte) {
+	if md.FileWrites == nil {
+		md.FileWrites = map[string][]byte{}
+	}
+	md.FileWrites[path] = content
+}
+
+// ApplyFileWrites writes every recorded file write to the worktree,
+// keeping the mode of files that already exist
+func (md *ModeData) ApplyFileWrites() error {
+	fs := md.Worktree.Filesystem
+	for _, path := range md.fileWritePaths() {
+		mode := os.FileMode(0644)
+		if fi, err := fs.Stat(path); err == nil {
+			mode = fi.Mode().Perm()
+		}
+
+		err := WriteFileAtomic(fs, path, md.FileWrites[path], mode)
+		if err != nil {
+			return fmt.Errorf("could not apply write to %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+// VerifyFileWrites checks that every recorded file write landed in the
+// worktree with the recorded content
+func (md *ModeData) VerifyFileWrites() error {
+	fs := md.Worktree.Filesystem
+
+	var mismatches []string
+	for _, path := range md.fileWritePaths() {
+		f, err := fs.Open(path)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		content, err := ioutil.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", path, err)
+		}
+
+		if !bytes.Equal(content, md.FileWrites[path]) {
+			mismatches = append(mismatches, fmt.Sprintf("%s: content does not match", path))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("file writes were not applied: %s", strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
+
+func (md *ModeData) fileWritePaths() []string {
+	var paths []string
+	for path := range md.FileWrites {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func newTestWorktree(t *testing.T, files map[string]string) *git.Worktree {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		err := util.WriteFile(w.Filesystem, path, []byte(content), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w
+}
+
+func readTestFile(t *testing.T, w *git.Worktree, path string) string {
+	f, err := w.Filesystem.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", path, err)
+	}
+
+	return string(content)
+}
+
+func TestApplyFileWrites(t *testing.T) {
+	w := newTestWorktree(t, map[string]string{
+		"SPECS/pkg.spec": "Release: 1%{?dist}\n",
+		"keep.txt":       "untouched\n",
+	})
+	md := &ModeData{Worktree: w}
+	md.RecordFileWrite("SPECS/pkg.spec", []byte("Release: 1%{?dist}.rocky\n"))
+	md.RecordFileWrite("SOURCES/new.patch", []byte("--- a\n+++ b\n"))
+	md.RecordFileWrite("SOURCES/new.patch", []byte("--- a\n+++ c\n"))
+
+	err := md.ApplyFileWrites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = md.VerifyFileWrites()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"SPECS/pkg.spec":    "Release: 1%{?dist}.rocky\n",
+		"SOURCES/new.patch": "--- a\n+++ c\n",
+		"keep.txt":          "untouched\n",
+	}
+	for path, content := range want {
+		if got := readTestFile(t, w, path); got != content {
+			t.Errorf("%s: expected %q, got %q", path, content, got)
+		}
+	}
+
+	fi, err := w.Filesystem.Stat("SPECS/pkg.spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Errorf("expected the mode of the existing file to be kept, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestVerifyFileWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		writes map[string]string
+		err    string
+	}{
+		{
+			name:   "applied",
+			files:  map[string]string{"a.txt": "new"},
+			writes: map[string]string{"a.txt": "new"},
+		},
+		{
+			name: "nothing recorded",
+		},
+		{
+			name:   "content differs",
+			files:  map[string]string{"a.txt": "old"},
+			writes: map[string]string{"a.txt": "new"},
+			err:    "a.txt: content does not match",
+		},
+		{
+			name:   "missing",
+			writes: map[string]string{"missing.txt": "new"},
+			err:    "missing.txt",
+		},
+		{
+			name:   "empty write",
+			files:  map[string]string{"empty.txt": ""},
+			writes: map[string]string{"empty.txt": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := &ModeData{Worktree: newTestWorktree(t, tt.files)}
+			for path, content := range tt.writes {
+				md.RecordFileWrite(path, []byte(content))
+			}
+
+			err := md.VerifyFileWrites()
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
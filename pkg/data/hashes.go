@@ -0,0 +1,148 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// HashAlgorithm describes a hash function that checksums in metadata
+// files and directives can be verified with
+type HashAlgorithm struct {
+	// Name is the lowercase algorithm prefix, e.g. "sha256"
+	Name string
+	// Size is the digest size in bytes
+	Size int
+	// New returns a fresh hash function
+	New func() hash.Hash
+}
+
+var (
+	hashesMu sync.RWMutex
+	// hashes maps algorithm names to their registration
+	hashes = map[string]*HashAlgorithm{}
+	// hashesBySize maps digest sizes to the algorithm with that size,
+	// which bare checksums of that length are assumed to be
+	hashesBySize = map[int]*HashAlgorithm{}
+)
+
+func init() {
+	for _, algorithm := range []HashAlgorithm{
+		{"md5", md5.Size, md5.New},
+		{"sha1", sha1.Size, sha1.New},
+		{"sha256", sha256.Size, sha256.New},
+		{"sha512", sha512.Size, sha512.New},
+	} {
+		if err := RegisterHash(algorithm.Name, algorithm.Size, algorithm.New); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterHash makes a hash algorithm available for checksum verification.
+// Checksums name the algorithm with a prefix ("sha384:<hex>") or are
+// matched by length, so every algorithm must have its own digest size.
+// Registering a name again replaces the algorithm, registering a size
+// that another algorithm already uses is an error.
+func RegisterHash(name string, size int, newFunc func() hash.Hash) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || size <= 0 || newFunc == nil {
+		return fmt.Errorf("invalid hash algorithm registration %q", name)
+	}
+
+	hashesMu.Lock()
+	defer hashesMu.Unlock()
+
+	if other, ok := hashesBySize[size]; ok && other.Name != name {
+		return fmt.Errorf("hash algorithm %s has the same digest size (%d bytes) as %s", name, size, other.Name)
+	}
+
+	algorithm := &HashAlgorithm{Name: name, Size: size, New: newFunc}
+	if previous, ok := hashes[name]; ok {
+		delete(hashesBySize, previous.Size)
+	}
+	hashes[name] = algorithm
+	hashesBySize[size] = algorithm
+
+	return nil
+}
+
+// LookupHash returns the registered hash algorithm called name
+func LookupHash(name string) (*HashAlgorithm, bool) {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+
+	algorithm, ok := hashes[strings.ToLower(strings.TrimSpace(name))]
+	return algorithm, ok
+}
+
+// HashForSize returns the hash algorithm bare checksums with
+// a digest of size bytes are assumed to be
+func HashForSize(size int) (*HashAlgorithm, bool) {
+	hashesMu.RLock()
+	defer hashesMu.RUnlock()
+
+	algorithm, ok := hashesBySize[size]
+	return algorithm, ok
+}
+
+// namedHash is a hash function that remembers the
+// algorithm it was created for, so HashName can tell
+// apart algorithms with the same digest size
+type namedHash struct {
+	hash.Hash
+	name string
+}
+
+func (h *namedHash) Algorithm() string {
+	return h.name
+}
+
+// newHash returns a fresh hash function for algorithm,
+// or nil if the algorithm is not registered
+func newHash(algorithm string) hash.Hash {
+	registered, ok := LookupHash(algorithm)
+	if !ok {
+		return nil
+	}
+
+	return &namedHash{Hash: registered.New(), name: registered.Name}
+}
+
+// HashName returns the algorithm name of a hash function created by
+// CompareHash. Other hash functions are named by their digest size.
+func HashName(h hash.Hash) string {
+	if named, ok := h.(interface{ Algorithm() string }); ok {
+		return named.Algorithm()
+	}
+	if algorithm, ok := HashForSize(h.Size()); ok {
+		return algorithm.Name
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Import phases hooks can be run at
+const (
+	HookPreFetch       = "pre-fetch"
+	HookPostDirectives = "post-directives"
+	HookPrePush        = "pre-push"
+	HookPostPush       = "post-push"
+)
+
+// HookPhases lists the phases hooks can be configured for
+var HookPhases = []string{HookPreFetch, HookPostDirectives, HookPrePush, HookPostPush}
+
+// HookEvent describes the in-flight import to a hook,
+// it is passed to the hook as JSON on stdin
+type HookEvent struct {
+	Phase string `json:"phase"`
+	// Package is the name of the package, Location
+	// where it is imported from
+	Package  string `json:"package"`
+	Location string `json:"location"`
+	// SourceTag is the upstream tag or commit being imported
+	SourceTag string `json:"source_tag,omitempty"`
+	// Branch is the target branch of the import
+	Branch string `json:"branch,omitempty"`
+	// Commit and Tag are the import commit and tag, once created
+	Commit     string   `json:"commit,omitempty"`
+	Tag        string   `json:"tag,omitempty"`
+	Directives []string `json:"directives,omitempty"`
+	DryRun     bool     `json:"dry_run"`
+}
+
+// HookError is returned when a hook rejects an import
+// by exiting with a non-zero status
+type HookError struct {
+	Phase  string
+	Hook   string
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("%s hook %s failed: %v", e.Phase, e.Hook, e.Err)
+	}
+	return fmt.Sprintf("%s hook %s failed: %v: %s", e.Phase, e.Hook, e.Err, e.Output)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// NewHookEvent returns the event of phase for the import of
+// md.TagBranch, or for the whole package if md is nil
+func (pd *ProcessData) NewHookEvent(phase string, md *ModeData) *HookEvent {
+	event := &HookEvent{
+		Phase:    phase,
+		Package:  PackageName(pd.RpmLocation),
+		Location: pd.RpmLocation,
+		DryRun:   pd.DryRun,
+	}
+	if md != nil {
+		event.Package = md.Name
+		event.SourceTag = strings.TrimPrefix(md.TagBranch, "refs/tags/")
+		event.Branch = md.PushBranch
+		event.Directives = md.AppliedDirectives
+	}
+
+	return event
+}
+
+// RunHooks runs the executables configured in Hooks for the phase of
+// event one after another, passing event as JSON on stdin. SRPMPROC_HOOK
+// is set to the phase. A hook exiting with a non-zero status stops the
+// import with a *HookError, except in post-push, where the import is
+// done already and the failure is only logged.
+func (pd *ProcessData) RunHooks(ctx context.Context, event *HookEvent) error {
+	hooks := pd.Hooks[event.Phase]
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode %s hook event: %v", event.Phase, err)
+	}
+
+	for _, hook := range hooks {
+		pd.Log.Debug("running hook", "phase", event.Phase, "hook", hook, "branch", event.Branch)
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, hook)
+		cmd.Stdin = bytes.NewReader(content)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		cmd.Env = append(os.Environ(), "SRPMPROC_HOOK="+event.Phase)
+
+		err := cmd.Run()
+		if output := strings.TrimSpace(stdout.String()); output != "" {
+			pd.Log.Info("hook output", "phase", event.Phase, "hook", hook, "output", output)
+		}
+		if err == nil {
+			continue
+		}
+
+		hookErr := &HookError{
+			Phase:  event.Phase,
+			Hook:   hook,
+			Output: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+		if event.Phase == HookPostPush {
+			pd.Log.Warn("post-push hook failed", "hook", hook, "error", hookErr)
+			continue
+		}
+		return hookErr
+	}
+
+	return nil
+}
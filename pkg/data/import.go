@@ -21,31 +21,152 @@
 package data
 
 import (
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5"
 	"hash"
+	"io"
+	"os"
 )
 
+// ImportMode retrieves and writes upstream sources. The import passes
+// its context, which is done once the import is aborted, to every method
+// that may fetch or download.
 type ImportMode interface {
-	RetrieveSource(pd *ProcessData) (*ModeData, error)
-	WriteSource(pd *ProcessData, md *ModeData) error
-	PostProcess(md *ModeData) error
+	RetrieveSource(ctx context.Context, pd *ProcessData) (*ModeData, error)
+	WriteSource(ctx context.Context, pd *ProcessData, md *ModeData) error
+	PostProcess(ctx context.Context, pd *ProcessData, md *ModeData) error
 	ImportName(pd *ProcessData, md *ModeData) string
 }
 
 type ModeData struct {
-	Name            string
-	Repo            *git.Repository
-	Worktree        *git.Worktree
-	FileWrites      map[string][]byte
-	TagBranch       string
-	PushBranch      string
-	Branches        []string
-	SourcesToIgnore []*IgnoredSource
-	BlobCache       map[string][]byte
+	Name             string
+	Repo             *git.Repository
+	Worktree         *git.Worktree
+	FileWrites       map[string][]byte
+	TagBranch        string
+	PushBranch       string
+	Branches         []string
+	BranchCommits    map[string]string
+	BranchTaggers    map[string]string
+	BranchRemotes    map[string]string
+	SourcesToIgnore  []*IgnoredSource
+	BlobCache        *BlobCache
+	SnapshotBlobs    billy.Filesystem
+	TempDirs         []string
+	UnchangedSources int
+	BlobSources      map[string]string
+	Version          int
+	SkippedSources   []string
+	// Provenance of the import of TagBranch, RetrieveSource
+	// only records when upstream was fetched
+	Provenance *Provenance
+	// Blob storage keys written and directive files
+	// applied by the import of TagBranch
+	UploadedBlobs     []string
+	AppliedDirectives []string
+	// UpstreamTree is the tree hash of the upstream commit
+	// of TagBranch, if known
+	UpstreamTree string
+	// ResolvedBlobs maps the hash of every source WriteSource resolved
+	// to the checksum its blob is stored under, across all branches
+	ResolvedBlobs map[string]string
+
+	sharedBlobCache bool
+}
+
+// UseSharedBlobCache makes md use cache, which is shared with other
+// imports and therefore not cleared when md is closed
+func (md *ModeData) UseSharedBlobCache(cache *BlobCache) {
+	md.BlobCache = cache
+	md.sharedBlobCache = true
+}
+
+// Close releases the repository storage, removes temporary directories
+// and clears the blob cache. Callers should defer md.Close() once an
+// import is done with the mode data.
+func (md *ModeData) Close() error {
+	var firstErr error
+
+	if md.Repo != nil {
+		if closer, ok := md.Repo.Storer.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil {
+				firstErr = fmt.Errorf("could not close repository storage: %v", err)
+			}
+		}
+	}
+	for _, dir := range md.TempDirs {
+		err := os.RemoveAll(dir)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not remove temporary directory %s: %v", dir, err)
+		}
+	}
+	if !md.sharedBlobCache {
+		md.BlobCache.Clear()
+	}
+
+	md.BlobCache = nil
+	md.Repo = nil
+	md.Worktree = nil
+	md.FileWrites = nil
+	md.SnapshotBlobs = nil
+	md.TempDirs = nil
+
+	return firstErr
+}
+
+// LookasideSource is a source referenced by a metadata file. Path is
+// where it goes in the repository and Name its file name. Hash is the
+// strongest digest listed for it, ExtraChecksums the other ones.
+type LookasideSource struct {
+	Name           string
+	Path           string
+	Hash           string
+	Algorithm      string
+	ExtraChecksums []string
+	// Line of the source in the metadata file, 0 if the parser does not know it
+	Line int
+}
+
+// SourceRef is the previous name of LookasideSource
+//
+// Deprecated: use LookasideSource
+type SourceRef = LookasideSource
+
+// Checksum returns the hash prefixed with its algorithm
+func (s LookasideSource) Checksum() string {
+	return s.Algorithm + ":" + s.Hash
+}
+
+// Checksums returns every digest listed for the source
+func (s LookasideSource) Checksums() []string {
+	return append([]string{s.Checksum()}, s.ExtraChecksums...)
+}
+
+type FileEntry struct {
+	Name string
+	Size int64
+	Mode os.FileMode
 }
 
 type IgnoredSource struct {
 	Name         string
 	HashFunction hash.Hash
 	Expired      bool
+	// Checksum is the hex digest of the content the source was
+	// externalized with, if known
+	Checksum string
+	// Algorithm is the name of the hash algorithm of Checksum
+	Algorithm string
+}
+
+// HashName returns the name of the hash algorithm the source
+// was externalized with
+func (s *IgnoredSource) HashName() string {
+	if s.Algorithm != "" {
+		return s.Algorithm
+	}
+	return HashName(s.HashFunction)
 }
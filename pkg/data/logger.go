@@ -0,0 +1,222 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log record
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// ParseLogLevel parses debug, info, warn or error,
+// an empty string is the info level
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+}
+
+// Logger writes leveled log records carrying key value fields, either
+// as logfmt text or as one JSON object per line. Records below the
+// level of the logger are dropped.
+type Logger struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  LogLevel
+	json   bool
+	fields []interface{}
+}
+
+// NewLogger returns a logger writing logfmt records of at least level to w
+func NewLogger(w io.Writer, level LogLevel) *Logger {
+	return &Logger{
+		mu:    &sync.Mutex{},
+		w:     w,
+		level: level,
+	}
+}
+
+// NewJSONLogger returns a logger writing JSON records of at least level to w
+func NewJSONLogger(w io.Writer, level LogLevel) *Logger {
+	l := NewLogger(w, level)
+	l.json = true
+
+	return l
+}
+
+// NewLoggerFormat returns a logger in format, which is text (the default) or json
+func NewLoggerFormat(w io.Writer, level LogLevel, format string) (*Logger, error) {
+	switch format {
+	case "", "text":
+		return NewLogger(w, level), nil
+	case "json":
+		return NewJSONLogger(w, level), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+}
+
+// With returns a logger adding the key value pairs in fields to every record
+func (l *Logger) With(fields ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), fields...)
+
+	return &child
+}
+
+// Enabled reports whether records of level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+// Debug logs msg with the key value pairs in fields at the debug level
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log(LevelDebug, msg, fields)
+}
+
+// Info logs msg with the key value pairs in fields at the info level
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log(LevelInfo, msg, fields)
+}
+
+// Warn logs msg with the key value pairs in fields at the warn level
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log(LevelWarn, msg, fields)
+}
+
+// Error logs msg with the key value pairs in fields at the error level
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log(LevelError, msg, fields)
+}
+
+func (l *Logger) log(level LogLevel, msg string, fields []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	all := append([]interface{}{
+		"time", time.Now().Format(time.RFC3339),
+		"level", level.String(),
+		"msg", msg,
+	}, l.fields...)
+	all = append(all, fields...)
+	// a trailing key without a value is logged like slog does
+	if len(all)%2 != 0 {
+		all = append(all[:len(all)-1], "!BADKEY", all[len(all)-1])
+	}
+
+	var buf bytes.Buffer
+	if l.json {
+		writeJSONRecord(&buf, all)
+	} else {
+		writeTextRecord(&buf, all)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(buf.Bytes())
+}
+
+func writeTextRecord(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+
+		value := fmt.Sprint(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+}
+
+func writeJSONRecord(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(jsonValue(fields[i+1]))
+	}
+	buf.WriteByte('}')
+}
+
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	return encoded
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import "time"
+
+// Metrics receives measurements taken during an import, so they can be
+// exported to Prometheus collectors or any other monitoring backend.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// BlobDownloaded is called for every blob downloaded from the lookaside
+	BlobDownloaded(bytes int64, duration time.Duration)
+	// DownloadFailed is called when a blob could not be downloaded
+	DownloadFailed()
+	// BlobCacheHit and BlobCacheMiss are called on every blob cache lookup
+	BlobCacheHit()
+	BlobCacheMiss()
+	// TagScanDuration is called once the upstream import tags are resolved
+	TagScanDuration(duration time.Duration)
+	// FetchFailed is called when fetching from the upstream git remote fails
+	FetchFailed()
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) BlobDownloaded(int64, time.Duration) {}
+func (nopMetrics) DownloadFailed()                     {}
+func (nopMetrics) BlobCacheHit()                       {}
+func (nopMetrics) BlobCacheMiss()                      {}
+func (nopMetrics) TagScanDuration(time.Duration)       {}
+func (nopMetrics) FetchFailed()                        {}
+
+// Metrics returns the configured metrics sink, or one that
+// discards everything if none is set
+func (pd *ProcessData) Metrics() Metrics {
+	if pd.MetricsSink == nil {
+		return nopMetrics{}
+	}
+
+	return pd.MetricsSink
+}
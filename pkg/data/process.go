@@ -21,14 +21,71 @@
 package data
 
 import (
+	"crypto/tls"
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/rocky-linux/srpmproc/pkg/blob"
-	"log"
+	"golang.org/x/crypto/openpgp"
+	"net/http"
+	"time"
 )
 
 type FsCreatorFunc func(branch string) (billy.Filesystem, error)
 
+// PathRewriteFunc maps a source path from the metadata file to the path it
+// should be written to in the worktree. Returning false drops the source.
+type PathRewriteFunc func(path string) (string, bool)
+
+// MetadataParseFunc parses the content of a metadata file
+// into the sources it references
+type MetadataParseFunc func(content []byte) ([]LookasideSource, error)
+
+// SourceWrittenFunc is called for every source written to the
+// worktree after its content was verified against hash
+type SourceWrittenFunc func(name string, hash string, size int64)
+
+// LookasideBranchFunc maps the upstream git branch of an import to
+// the branch name the lookaside cache keys its sources by
+type LookasideBranchFunc func(gitBranch string) string
+
+// ExternalizeFunc decides whether a file in the worktree that the upstream
+// metadata does not list should still be moved to the lookaside
+type ExternalizeFunc func(name string, size int) bool
+
+// LookasideAuth holds credentials for the lookaside cache, either
+// a username and password for basic auth or a bearer token
+type LookasideAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// AuthForHost returns the lookaside credentials for host. Credentials in
+// LookasideHostAuth take precedence, LookasideAuth is only used for the
+// host of CdnUrl.
+func (pd *ProcessData) AuthForHost(host string) *LookasideAuth {
+	if auth, ok := pd.LookasideHostAuth[host]; ok {
+		return auth
+	}
+	if host == HostOf(pd.CdnUrl) {
+		return pd.LookasideAuth
+	}
+
+	return nil
+}
+
+// UpstreamRemote is a repository fetched next to upstream, whose
+// import tags are merged with the upstream ones
+type UpstreamRemote struct {
+	Name string
+	Url  string
+}
+
+// ProcessData holds the options and the state of a single import. It
+// must not be shared by imports running in parallel, Copy derives the
+// ProcessData of another import.
 type ProcessData struct {
 	RpmLocation          string
 	UpstreamPrefix       string
@@ -54,9 +111,103 @@ type ProcessData struct {
 	StrictBranchMode     bool
 	FsCreator            FsCreatorFunc
 	CdnUrl               string
-	Log                  *log.Logger
+	Log                  *Logger
 	PackageVersion       string
 	PackageRelease       string
 	TaglessMode          bool
 	AltLookAside         bool
+	PathRewriter         PathRewriteFunc
+	OverwriteExisting    bool
+	BlobCacheMaxBytes    int64
+	SourcesManifestPath  string
+	TlsConfig            *tls.Config
+	MetricsSink          Metrics
+	ProgressReporter     ProgressReporter
+	SnapshotPath         string
+	SnapshotExportPath   string
+	LookasideETags       bool
+	ShouldExternalize    ExternalizeFunc
+	PushTarget           *PushTarget
+	ImportCommit         string
+	HostLimiter          *HostLimiter
+	CheckoutStrategy     CheckoutStrategy
+	PreserveFileModes    bool
+	SourceMtime          time.Time
+	PreviousSources      map[string]string
+	LookasideAuth        *LookasideAuth
+	LookasideHostAuth    map[string]*LookasideAuth
+	MaxRedirects         int
+	SameHostRedirects    bool
+	SourceCacheURLs      []string
+	SourceSubdir         string
+	LatestVersion        bool
+	OnSourceWritten      SourceWrittenFunc
+	IncludeSources       []string
+	ExcludeSources       []string
+	PreflightHead        bool
+	SharedBlobCache      *BlobCache
+	FetchRefSpecs        []config.RefSpec
+	MaxBlobSize          int64
+	HTTPClient           *http.Client
+	Checkpoint           Checkpoint
+	AllowDuplicates      bool
+	HashOverrides        map[string]string
+	MetadataParser       MetadataParseFunc
+	GenerateSourcesFile  bool
+	SourcesFileFormat    string
+	ImportNameFunc       func(md *ModeData) string
+	StrictTagResolution  bool
+	FetchedRepo          *git.Repository
+	DecompressZstdBlobs  bool
+	NamespacedBlobs      bool
+	LocalSourceDir       string
+	SourceKeyring        openpgp.EntityList
+	SignatureWarnOnly    bool
+	GitProtocolV2        bool
+	MaxRunDuration       time.Duration
+	MetadataFilePattern  string
+	ExtraRemotes         []UpstreamRemote
+	RemotePrecedence     []string
+	VerifyBeforeStrip    bool
+	BareTarget           *git.Repository
+	UserAgent            string
+	UpstreamCacheDir     string
+	CompressBlobCache    bool
+	DependsOn            []string
+	ProvenancePath       string
+	LookasideBranchFunc  LookasideBranchFunc
+
+	// CommitMessageTemplate is a text/template rendered with
+	// CommitMessageData into the message of import commits
+	CommitMessageTemplate string
+
+	// Downloads slower than SlowDownloadThreshold bytes per second
+	// or taking longer than SlowDownloadDuration are logged
+	SlowDownloadThreshold int64
+	SlowDownloadDuration  time.Duration
+
+	// Hooks maps import phases (see HookPhases) to the
+	// executables run at them, see RunHooks
+	Hooks map[string][]string
+
+	// AtomicImport buffers the commits and tags of every branch and only
+	// pushes them once all branches were imported. If a push fails,
+	// the branches pushed before are rolled back. New blobs are only
+	// uploaded once every branch was pushed.
+	AtomicImport bool
+
+	// ImportState records every import, see ImportState
+	ImportState ImportState
+
+	// DryRun imports in memory, but only logs the commits, tags and
+	// blobs instead of pushing them or writing them to blob storage
+	DryRun bool
+
+	// KeepLookasideSources keeps the externalized sources in the
+	// worktree in PostProcess instead of stripping them, producing
+	// a fat repo
+	KeepLookasideSources bool
+
+	run  *runState
+	busy int32
 }
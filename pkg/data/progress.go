@@ -0,0 +1,140 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"io"
+)
+
+// ProgressReporter receives progress events of long running operations,
+// so callers can render progress bars or log heartbeats. Implementations
+// must be safe for concurrent use.
+type ProgressReporter interface {
+	// FetchProgress is called with every progress message the
+	// git remote at url sends while it is fetched
+	FetchProgress(url string, message string)
+	// DownloadProgress is called while a blob is downloaded from url
+	// with the bytes read so far, total is -1 if the size is unknown
+	DownloadProgress(url string, bytes int64, total int64)
+	// UploadProgress is called before and after a blob is
+	// written to blob storage under key
+	UploadProgress(key string, bytes int64, total int64)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) FetchProgress(string, string)          {}
+func (nopProgress) DownloadProgress(string, int64, int64) {}
+func (nopProgress) UploadProgress(string, int64, int64)   {}
+
+// Progress returns the configured progress reporter, or one
+// that discards everything if none is set
+func (pd *ProcessData) Progress() ProgressReporter {
+	if pd.ProgressReporter == nil {
+		return nopProgress{}
+	}
+
+	return pd.ProgressReporter
+}
+
+// FetchProgressWriter returns the writer to pass as progress to git
+// fetches of url, it reports every line the remote sends. Returns nil
+// if no progress reporter is set, so git does not ask for progress.
+func (pd *ProcessData) FetchProgressWriter(url string) io.Writer {
+	if pd.ProgressReporter == nil {
+		return nil
+	}
+
+	return &fetchProgressWriter{reporter: pd.ProgressReporter, url: url}
+}
+
+// fetchProgressWriter splits the sideband progress of a remote into
+// messages, which end with a carriage return when they are updated
+// in place (e.g. "Receiving objects:  45%") or a newline otherwise
+type fetchProgressWriter struct {
+	reporter ProgressReporter
+	url      string
+	buf      []byte
+}
+
+func (w *fetchProgressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		if message := string(bytes.TrimSpace(w.buf[:i])); message != "" {
+			w.reporter.FetchProgress(w.url, message)
+		}
+		w.buf = w.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// downloadProgressStep is how many bytes are read
+// between two download progress events
+const downloadProgressStep = 1 << 20
+
+// ProgressReader wraps the body of a download from url, reporting the
+// bytes read every MiB and once the body is read completely. total is
+// the announced size of the body, or -1 if it is unknown.
+func (pd *ProcessData) ProgressReader(r io.Reader, url string, total int64) io.Reader {
+	if pd.ProgressReporter == nil {
+		return r
+	}
+
+	return &progressReader{
+		r:        r,
+		reporter: pd.ProgressReporter,
+		url:      url,
+		total:    total,
+		next:     downloadProgressStep,
+	}
+}
+
+type progressReader struct {
+	r        io.Reader
+	reporter ProgressReporter
+	url      string
+	total    int64
+	read     int64
+	next     int64
+	done     bool
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.read >= r.next {
+		r.reporter.DownloadProgress(r.url, r.read, r.total)
+		r.next = r.read + downloadProgressStep
+	} else if err == io.EOF && !r.done {
+		r.reporter.DownloadProgress(r.url, r.read, r.total)
+	}
+	if err == io.EOF {
+		r.done = true
+	}
+
+	return n, err
+}
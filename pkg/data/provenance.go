@@ -0,0 +1,114 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Origins of imported sources
+const (
+	OriginLookaside   = "lookaside"
+	OriginBlobStorage = "blob-storage"
+	OriginCache       = "cache"
+	OriginSnapshot    = "snapshot"
+	OriginLocal       = "local"
+	OriginCheckpoint  = "checkpoint"
+	OriginWorktree    = "worktree"
+	OriginEmpty       = "empty"
+)
+
+// Provenance records where the import of a branch came from, so
+// provenance attestations can be generated without re-deriving it
+type Provenance struct {
+	Package        string             `json:"package"`
+	UpstreamUrl    string             `json:"upstream_url,omitempty"`
+	UpstreamRef    string             `json:"upstream_ref"`
+	UpstreamBranch string             `json:"upstream_branch,omitempty"`
+	UpstreamCommit string             `json:"upstream_commit,omitempty"`
+	Tagger         string             `json:"tagger,omitempty"`
+	FetchedAt      time.Time          `json:"fetched_at"`
+	ImportedAt     time.Time          `json:"imported_at"`
+	Sources        []SourceProvenance `json:"sources"`
+}
+
+// SourceProvenance records where a single source came from. Url is
+// the lookaside location that served it, if it was downloaded.
+type SourceProvenance struct {
+	Path        string    `json:"path"`
+	Algorithm   string    `json:"algorithm"`
+	Hash        string    `json:"hash"`
+	Origin      string    `json:"origin"`
+	Url         string    `json:"url,omitempty"`
+	Host        string    `json:"host,omitempty"`
+	RetrievedAt time.Time `json:"retrieved_at"`
+}
+
+// AddSource records that source was written to path after it was
+// retrieved from origin. blobUrl is the location that served it, if known.
+func (p *Provenance) AddSource(source LookasideSource, path string, origin string, blobUrl string) {
+	prov := SourceProvenance{
+		Path:        path,
+		Algorithm:   source.Algorithm,
+		Hash:        source.Hash,
+		Origin:      origin,
+		Url:         blobUrl,
+		RetrievedAt: time.Now().UTC(),
+	}
+	if u, err := url.Parse(blobUrl); err == nil {
+		prov.Host = u.Host
+	}
+
+	p.Sources = append(p.Sources, prov)
+}
+
+// BranchProvenance returns the provenance of the import of md.TagBranch,
+// taking the fetch time from the provenance RetrieveSource recorded
+func BranchProvenance(md *ModeData, upstreamUrl string, upstreamBranch string) *Provenance {
+	prov := &Provenance{
+		Package:        md.Name,
+		UpstreamUrl:    upstreamUrl,
+		UpstreamRef:    md.TagBranch,
+		UpstreamBranch: upstreamBranch,
+		UpstreamCommit: md.BranchCommits[md.TagBranch],
+		Tagger:         md.BranchTaggers[md.TagBranch],
+		ImportedAt:     time.Now().UTC(),
+		Sources:        []SourceProvenance{},
+	}
+	if md.Provenance != nil {
+		prov.FetchedAt = md.Provenance.FetchedAt
+	}
+
+	return prov
+}
+
+// JSON returns the provenance as indented JSON
+func (p *Provenance) JSON() ([]byte, error) {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal provenance: %v", err)
+	}
+
+	return append(content, '\n'), nil
+}
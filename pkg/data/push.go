@@ -0,0 +1,252 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"time"
+)
+
+const pushTargetRemote = "target"
+
+// PushTarget is an additional dist-git remote imports are pushed to
+type PushTarget struct {
+	Url string
+	// Auth defaults to the Authenticator of the import
+	Auth transport.AuthMethod
+	// RefSpecs default to pushing HEAD to the push branch
+	// together with the tags that point at it
+	RefSpecs []config.RefSpec
+	// Force allows non fast-forward updates of the target
+	Force bool
+}
+
+type PushResult struct {
+	Ref      string
+	Commit   string
+	Tags     []string
+	UpToDate bool
+}
+
+// Push commits pending changes in the worktree of md, if any, and pushes
+// the resulting branch and its tags to pd.PushTarget. Without Force the
+// push is rejected unless it fast-forwards the target branch.
+func (pd *ProcessData) Push(ctx context.Context, md *ModeData) (*PushResult, error) {
+	if pd.PushTarget == nil || pd.PushTarget.Url == "" {
+		return nil, fmt.Errorf("no push target configured")
+	}
+
+	status, err := md.Worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("could not get worktree status: %v", err)
+	}
+	if !status.IsClean() {
+		var parents []plumbing.Hash
+		head, err := md.Repo.Head()
+		if err == nil {
+			parents = append(parents, head.Hash())
+		}
+
+		message, err := pd.CommitMessage(md, "import "+pd.Importer.ImportName(pd, md))
+		if err != nil {
+			return nil, err
+		}
+		_, err = md.Worktree.Commit(message, &git.CommitOptions{
+			Author: &object.Signature{
+				Name:  pd.GitCommitterName,
+				Email: pd.GitCommitterEmail,
+				When:  time.Now(),
+			},
+			Parents: parents,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not commit object: %v", err)
+		}
+	}
+
+	head, err := md.Repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("could not get HEAD: %v", err)
+	}
+
+	result := &PushResult{
+		Ref:    plumbing.NewBranchReferenceName(md.PushBranch).String(),
+		Commit: head.Hash().String(),
+	}
+
+	refspecs := pd.PushTarget.RefSpecs
+	if len(refspecs) == 0 {
+		refspecs = append(refspecs, config.RefSpec(head.Name().String()+":"+result.Ref))
+
+		tags, err := tagsPointingAt(md.Repo, head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			refspecs = append(refspecs, config.RefSpec(tag+":"+tag))
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+
+	err = pd.ensurePushTargetRemote(md)
+	if err != nil {
+		return nil, err
+	}
+
+	pd.Log.Info("pushing to push target", "commit", result.Commit, "url", pd.PushTarget.Url)
+	err = md.Repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: pushTargetRemote,
+		Auth:       pd.pushTargetAuth(),
+		RefSpecs:   refspecs,
+		Force:      pd.PushTarget.Force,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		result.UpToDate = true
+	} else if err != nil {
+		return nil, fmt.Errorf("could not push to target: %v", err)
+	}
+
+	return result, nil
+}
+
+// BackupPushTarget fetches the commit the push branch of md points to in
+// pd.PushTarget into md.Repo, so RollbackPushTarget can restore it. The
+// zero hash is returned if the push target has no such branch yet.
+func (pd *ProcessData) BackupPushTarget(ctx context.Context, md *ModeData) (plumbing.Hash, error) {
+	if pd.PushTarget == nil || pd.PushTarget.Url == "" {
+		return plumbing.ZeroHash, fmt.Errorf("no push target configured")
+	}
+	err := pd.ensurePushTargetRemote(md)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	backupRef := pushTargetBackupRef(md)
+	err = md.Repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: pushTargetRemote,
+		Auth:       pd.pushTargetAuth(),
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(md.PushBranch), backupRef))},
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if errors.Is(err, git.NoMatchingRefSpecError{}) || err == transport.ErrEmptyRemoteRepository {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, fmt.Errorf("could not fetch push target branch: %v", err)
+	}
+
+	ref, err := md.Repo.Reference(backupRef, true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("could not get push target branch: %v", err)
+	}
+
+	return ref.Hash(), nil
+}
+
+// RollbackPushTarget undoes a Push to pd.PushTarget that returned result.
+// The pushed tags are deleted and the push branch of md is reset to
+// previous, as returned by BackupPushTarget, or deleted if it is zero.
+// It runs with ctx, as the context of the failed import may be done.
+func (pd *ProcessData) RollbackPushTarget(ctx context.Context, md *ModeData, result *PushResult, previous plumbing.Hash) error {
+	var refspecs []config.RefSpec
+	for _, tag := range result.Tags {
+		refspecs = append(refspecs, config.RefSpec(":"+tag))
+	}
+	if previous.IsZero() {
+		refspecs = append(refspecs, config.RefSpec(":"+result.Ref))
+	} else {
+		refspecs = append(refspecs, config.RefSpec(fmt.Sprintf("+%s:%s", pushTargetBackupRef(md), result.Ref)))
+	}
+
+	err := md.Repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: pushTargetRemote,
+		Auth:       pd.pushTargetAuth(),
+		RefSpecs:   refspecs,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("could not roll back push target: %v", err)
+	}
+
+	return nil
+}
+
+// ensurePushTargetRemote adds pd.PushTarget as a remote of md.Repo
+func (pd *ProcessData) ensurePushTargetRemote(md *ModeData) error {
+	_, err := md.Repo.Remote(pushTargetRemote)
+	if err == git.ErrRemoteNotFound {
+		_, err = md.Repo.CreateRemote(&config.RemoteConfig{
+			Name: pushTargetRemote,
+			URLs: []string{pd.PushTarget.Url},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("could not create push target remote: %v", err)
+	}
+
+	return nil
+}
+
+func (pd *ProcessData) pushTargetAuth() transport.AuthMethod {
+	if pd.PushTarget.Auth != nil {
+		return pd.PushTarget.Auth
+	}
+	return pd.Authenticator
+}
+
+// pushTargetBackupRef is the local ref BackupPushTarget
+// keeps the push target branch of md in
+func pushTargetBackupRef(md *ModeData) plumbing.ReferenceName {
+	return plumbing.ReferenceName("refs/rollback/target/" + md.PushBranch)
+}
+
+func tagsPointingAt(repo *git.Repository, commit plumbing.Hash) ([]string, error) {
+	iter, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("could not list tags: %v", err)
+	}
+
+	var tags []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		target := ref.Hash()
+		tag, err := repo.TagObject(target)
+		if err == nil {
+			target = tag.Target
+		}
+		if target == commit {
+			tags = append(tags, ref.Name().String())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list tags: %v", err)
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HostLimiter spaces out requests to the same host so mass imports
+// don't trip server-side throttling. A small random jitter is added
+// to every interval. A nil HostLimiter does not limit.
+type HostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+// NewHostLimiter allows requestsPerSecond requests per host
+func NewHostLimiter(requestsPerSecond float64) *HostLimiter {
+	return &HostLimiter{
+		interval: time.Duration(float64(time.Second) / requestsPerSecond),
+		next:     map[string]time.Time{},
+	}
+}
+
+// Wait blocks until the next request to host is allowed or ctx is
+// done, in which case ctx.Err() is returned
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	jitter := time.Duration(rand.Int63n(int64(l.interval)/10 + 1))
+	l.next[host] = slot.Add(l.interval + jitter)
+	l.mu.Unlock()
+
+	return sleepContext(ctx, time.Until(slot))
+}
+
+// Delay holds back every request to host for d, e.g. as told by a
+// Retry-After header. Without a limiter the caller itself sleeps,
+// returning ctx.Err() if ctx is done before.
+func (l *HostLimiter) Delay(ctx context.Context, host string, d time.Duration) error {
+	if l == nil {
+		return sleepContext(ctx, d)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if l.next[host].Before(until) {
+		l.next[host] = until
+	}
+	return nil
+}
+
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// HostOf returns the host part of rawUrl, or rawUrl itself
+// for locations that aren't URLs such as local paths
+func HostOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return rawUrl
+	}
+
+	return u.Host
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ImportModeFactory creates a new instance of a registered import mode
+type ImportModeFactory func() ImportMode
+
+var (
+	modesMu sync.RWMutex
+	modes   = map[string]ImportModeFactory{}
+)
+
+// RegisterMode makes an import mode available under name, so it can be
+// selected with NewImportMode. It panics if name is already registered,
+// modes are meant to be registered from init functions.
+func RegisterMode(name string, factory ImportModeFactory) {
+	modesMu.Lock()
+	defer modesMu.Unlock()
+
+	if factory == nil {
+		panic("data: RegisterMode factory is nil")
+	}
+	if _, dup := modes[name]; dup {
+		panic("data: RegisterMode called twice for mode " + name)
+	}
+	modes[name] = factory
+}
+
+// NewImportMode returns a new instance of the import mode registered as name
+func NewImportMode(name string) (ImportMode, error) {
+	modesMu.RLock()
+	factory, ok := modes[name]
+	modesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown import mode %q (available: %s)", name, strings.Join(ImportModes(), ", "))
+	}
+
+	return factory(), nil
+}
+
+// ImportModes returns the names of the registered import modes, sorted
+func ImportModes() []string {
+	modesMu.RLock()
+	defer modesMu.RUnlock()
+
+	names := make([]string, 0, len(modes))
+	for name := range modes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
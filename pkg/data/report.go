@@ -0,0 +1,63 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+// BranchReport describes what the import of a single branch did
+type BranchReport struct {
+	// SourceTag is the upstream tag or commit the branch was imported from
+	SourceTag string `json:"source_tag"`
+	// Branch is the target branch the import was committed to
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+	Tag    string `json:"tag"`
+	// TagHash is the hash of the annotated import tag
+	TagHash string `json:"tag_hash,omitempty"`
+	// UploadedBlobs lists the blob storage keys written by the import
+	UploadedBlobs []string `json:"uploaded_blobs,omitempty"`
+	// Directives lists the directive files applied to the branch
+	Directives []string `json:"directives,omitempty"`
+}
+
+// ReportBranch records the report of an imported branch in the current run
+func (pd *ProcessData) ReportBranch(report BranchReport) {
+	if pd.run == nil {
+		return
+	}
+
+	pd.run.mu.Lock()
+	defer pd.run.mu.Unlock()
+	pd.run.reports = append(pd.run.reports, report)
+}
+
+// BranchReports returns the reports of the branches
+// imported in the current run, in import order
+func (pd *ProcessData) BranchReports() []BranchReport {
+	if pd.run == nil {
+		return nil
+	}
+
+	pd.run.mu.Lock()
+	defer pd.run.mu.Unlock()
+	reports := make([]BranchReport, len(pd.run.reports))
+	copy(reports, pd.run.reports)
+
+	return reports
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Formats of the sources file read by rpkg and centpkg
+const (
+	SourcesFormatBSD    = "bsd"
+	SourcesFormatLegacy = "legacy"
+)
+
+// SourcesFileLine formats the sources file entry of the source at path
+// with the checksum calculated by algorithm. The BSD format is used
+// unless SourcesFileFormat selects the legacy one.
+func (pd *ProcessData) SourcesFileLine(algorithm string, checksum string, path string) string {
+	name := filepath.Base(path)
+	if pd.SourcesFileFormat == SourcesFormatLegacy {
+		return fmt.Sprintf("%s  %s\n", checksum, name)
+	}
+
+	return fmt.Sprintf("%s (%s) = %s\n", strings.ToUpper(algorithm), name, checksum)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"time"
+)
+
+// ImportRecord describes the import of an upstream tag or commit
+type ImportRecord struct {
+	// Package is the name of the imported package
+	Package string `json:"package"`
+	// SourceTag is the upstream tag or commit that was imported
+	SourceTag string `json:"source_tag"`
+	// Branch is the target branch the import was committed to
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+	Tag    string `json:"tag"`
+	// UpstreamTree is the tree hash of the upstream commit
+	UpstreamTree string `json:"upstream_tree,omitempty"`
+	// Sources maps the paths of the externalized sources
+	// to their checksum, prefixed with the algorithm
+	Sources    map[string]string `json:"sources,omitempty"`
+	ImportedAt time.Time         `json:"imported_at"`
+}
+
+// ImportState records every import, so what has been imported
+// already can be queried without asking the target forge
+type ImportState interface {
+	// RecordImport stores record, replacing an earlier
+	// import of the same package and source tag
+	RecordImport(record *ImportRecord) error
+	// LookupImport returns the import of sourceTag of the
+	// package name, or nil if it was not imported yet
+	LookupImport(name string, sourceTag string) (*ImportRecord, error)
+	// Imports returns the imports of the package name,
+	// or of every package if name is empty
+	Imports(name string) ([]*ImportRecord, error)
+}
+
+// RecordImport stores record in the import state, if one is
+// configured. Dry runs leave the import state untouched.
+func (pd *ProcessData) RecordImport(record *ImportRecord) error {
+	if pd.ImportState == nil || pd.DryRun {
+		return nil
+	}
+
+	err := pd.ImportState.RecordImport(record)
+	if err != nil {
+		return fmt.Errorf("could not record import of %s: %v", record.SourceTag, err)
+	}
+
+	return nil
+}
+
+// LookupImport returns the recorded import of sourceTag of name,
+// or nil if there is none or no import state is configured
+func (pd *ProcessData) LookupImport(name string, sourceTag string) (*ImportRecord, error) {
+	if pd.ImportState == nil {
+		return nil, nil
+	}
+
+	record, err := pd.ImportState.LookupImport(name, sourceTag)
+	if err != nil {
+		return nil, fmt.Errorf("could not look up import of %s: %v", sourceTag, err)
+	}
+
+	return record, nil
+}
+
+// LatestImport returns the most recent recorded import of name to
+// branch, or nil if there is none or no import state is configured
+func (pd *ProcessData) LatestImport(name string, branch string) (*ImportRecord, error) {
+	if pd.ImportState == nil {
+		return nil, nil
+	}
+
+	records, err := pd.ImportState.Imports(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not list imports of %s: %v", name, err)
+	}
+
+	var latest *ImportRecord
+	for _, record := range records {
+		if record.Branch != branch {
+			continue
+		}
+		if latest == nil || record.ImportedAt.After(latest.ImportedAt) {
+			latest = record
+		}
+	}
+
+	return latest, nil
+}
@@ -21,10 +21,9 @@
 package data
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
+	"bytes"
+	"context"
 	"crypto/sha256"
-	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"github.com/go-git/go-billy/v5"
@@ -32,6 +31,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func CopyFromFs(from billy.Filesystem, to billy.Filesystem, path string) error {
@@ -82,6 +83,363 @@ func IgnoredContains(a []*IgnoredSource, b string) bool {
 	return false
 }
 
+// ApplyExternalizePolicy walks the worktree and adds every file that
+// pd.ShouldExternalize selects to the sources to ignore, so they end up
+// in the metadata file and blob storage like the upstream sources.
+// Dotfiles such as .gitignore and the metadata file itself are never
+// externalized. Without a policy nothing is changed.
+func ApplyExternalizePolicy(pd *ProcessData, md *ModeData) error {
+	if pd.ShouldExternalize == nil {
+		return nil
+	}
+
+	return applyExternalizePolicy(pd, md, ".")
+}
+
+func applyExternalizePolicy(pd *ProcessData, md *ModeData, dir string) error {
+	read, err := md.Worktree.Filesystem.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read dir: %v", err)
+	}
+
+	for _, fi := range read {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		fullPath := filepath.Join(dir, fi.Name())
+
+		if fi.IsDir() {
+			err := applyExternalizePolicy(pd, md, fullPath)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || externalized(md.SourcesToIgnore, fullPath) {
+			continue
+		}
+
+		if pd.ShouldExternalize(fullPath, int(fi.Size())) {
+			pd.Log.Info("externalizing by policy", "path", fullPath)
+			md.SourcesToIgnore = append(md.SourcesToIgnore, &IgnoredSource{
+				Name:         fullPath,
+				HashFunction: sha256.New(),
+				Algorithm:    "sha256",
+			})
+		}
+	}
+
+	return nil
+}
+
+func externalized(a []*IgnoredSource, name string) bool {
+	for _, val := range a {
+		if val.Name == name && !val.Expired {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseMetadata is the built-in metadata parser. Every line lists
+// the digests of a source, separated by commas, followed by its path.
+// Empty lines and lines starting with # are skipped. The strongest
+// digest becomes the hash of a source, the others ExtraChecksums.
+func ParseMetadata(content []byte) ([]LookasideSource, error) {
+	var sources []LookasideSource
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineInfo := strings.SplitN(line, " ", 2)
+		if len(lineInfo) != 2 {
+			return nil, fmt.Errorf("malformed line %d (%q)", i+1, line)
+		}
+
+		var source LookasideSource
+		var checksums []string
+		for _, digest := range strings.Split(lineInfo[0], ",") {
+			algorithm, hash, err := NormalizeHash(digest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash on line %d (%q): %v", i+1, line, err)
+			}
+			checksums = append(checksums, algorithm+":"+hash)
+			if len(hash) > len(source.Hash) {
+				source.Algorithm, source.Hash = algorithm, hash
+			}
+		}
+		for _, checksum := range checksums {
+			if checksum != source.Checksum() {
+				source.ExtraChecksums = append(source.ExtraChecksums, checksum)
+			}
+		}
+
+		path, err := SanitizeSourcePath(strings.TrimSpace(lineInfo[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid path on line %d (%q): %v", i+1, line, err)
+		}
+		source.Path = path
+		source.Name = filepath.Base(path)
+		source.Line = i + 1
+
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// ParseMetadataHashes maps the source paths listed in
+// the content of a metadata file to their hashes
+func ParseMetadataHashes(content []byte) map[string]string {
+	hashes := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		lineInfo := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(lineInfo) != 2 {
+			continue
+		}
+		hash := lineInfo[0]
+		if _, normalized, err := NormalizeHash(hash); err == nil {
+			hash = normalized
+		}
+		hashes[strings.TrimSpace(lineInfo[1])] = hash
+	}
+
+	return hashes
+}
+
+// PackageName extracts the package name from an RPM location, which may
+// be a path, an HTTPS URL or an SSH URL like git@host:org/pkg.git. Query
+// strings, fragments and known repository or package suffixes are stripped.
+func PackageName(location string) string {
+	name := location
+	if i := strings.IndexAny(name, "?#"); i != -1 {
+		name = name[:i]
+	}
+	name = strings.TrimRight(name, "/")
+	if i := strings.LastIndexAny(name, "/:"); i != -1 {
+		name = name[i+1:]
+	}
+	for _, suffix := range []string{".git", ".src.rpm"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+
+	return name
+}
+
+// DefaultMetadataFilePattern names the metadata file after the package
+const DefaultMetadataFilePattern = ".%s.metadata"
+
+// MetadataFileName returns the metadata file name of the package name
+// according to MetadataFilePattern, where %s is the package name
+func (pd *ProcessData) MetadataFileName(name string) string {
+	pattern := pd.MetadataFilePattern
+	if pattern == "" {
+		pattern = DefaultMetadataFilePattern
+	}
+	if !strings.Contains(pattern, "%s") {
+		return pattern
+	}
+
+	return strings.Replace(pattern, "%s", PackageName(name), -1)
+}
+
+// FindMetadataFile returns the metadata file at the root of fs. The name
+// derived from the package name is used if no metadata file exists, and
+// a warning is logged if the existing file is named differently.
+// With a custom MetadataFilePattern the file has to exist under exactly
+// that name, otherwise the error lists the files that were found.
+func (pd *ProcessData) FindMetadataFile(fs billy.Filesystem, name string) (string, error) {
+	expected := pd.MetadataFileName(name)
+
+	ls, err := fs.ReadDir(".")
+	if err != nil {
+		return "", fmt.Errorf("could not read directory: %v", err)
+	}
+
+	if pd.MetadataFilePattern != "" && pd.MetadataFilePattern != DefaultMetadataFilePattern {
+		var found []string
+		for _, f := range ls {
+			if f.Name() == expected && !f.IsDir() {
+				return expected, nil
+			}
+			found = append(found, f.Name())
+		}
+		return "", &MetadataMissingError{
+			Path: expected,
+			Err:  fmt.Errorf("no file matches pattern %s, found: %s", pd.MetadataFilePattern, strings.Join(found, ", ")),
+		}
+	}
+
+	metadataPath := ""
+	for _, f := range ls {
+		if strings.HasSuffix(f.Name(), ".metadata") {
+			if metadataPath != "" {
+				return "", fmt.Errorf("multiple metadata files found")
+			}
+			metadataPath = f.Name()
+		}
+	}
+	if metadataPath == "" {
+		return expected, nil
+	}
+	if metadataPath != expected {
+		pd.Log.Warn("metadata file does not match the expected name, using it anyway", "path", metadataPath, "expected", expected)
+	}
+
+	return metadataPath, nil
+}
+
+// WriteFileAtomic writes content to a temporary file next to path and
+// renames it into place, so path never holds partially written content
+func WriteFileAtomic(fs billy.Filesystem, path string, content []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := fs.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create directory %s: %v", dir, err)
+	}
+
+	// not using TempFile, as it ignores mode
+	tmpPath := filepath.Join(dir, fmt.Sprintf(".%s.%d.staging", filepath.Base(path), time.Now().UnixNano()))
+	f, err := fs.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("could not create staging file: %v", err)
+	}
+
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fs.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = fs.Remove(tmpPath)
+		return fmt.Errorf("could not write %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// ListWorktreeFiles returns every file in the worktree of md with its
+// size and mode, skipping the .git directory
+func ListWorktreeFiles(md *ModeData) ([]FileEntry, error) {
+	var entries []FileEntry
+	err := listFiles(md.Worktree.Filesystem, ".", &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func listFiles(fs billy.Filesystem, dir string, entries *[]FileEntry) error {
+	read, err := fs.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read dir: %v", err)
+	}
+
+	for _, fi := range read {
+		fullPath := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			if fullPath == ".git" {
+				continue
+			}
+			err := listFiles(fs, fullPath, entries)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		*entries = append(*entries, FileEntry{
+			Name: fullPath,
+			Size: fi.Size(),
+			Mode: fi.Mode(),
+		})
+	}
+
+	return nil
+}
+
+// SourceAllowed reports whether a metadata source passes the include
+// and exclude globs of pd. Globs match the full path or the file name.
+func (pd *ProcessData) SourceAllowed(path string) bool {
+	if len(pd.IncludeSources) > 0 && !matchesAnyGlob(pd.IncludeSources, path) {
+		return false
+	}
+
+	return !matchesAnyGlob(pd.ExcludeSources, path)
+}
+
+func matchesAnyGlob(globs []string, path string) bool {
+	for _, glob := range globs {
+		if ok, _ := filepath.Match(glob, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(glob, filepath.Base(path)); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SanitizeSourcePath cleans a source path and rejects absolute paths
+// or paths containing ".." segments, so writes stay inside the worktree
+func SanitizeSourcePath(path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("absolute path %s not allowed", path)
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(path), "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path %s contains a parent directory segment", path)
+		}
+	}
+
+	cleaned := filepath.Clean(path)
+	if cleaned == "." {
+		return "", fmt.Errorf("path %s does not name a file", path)
+	}
+
+	return cleaned, nil
+}
+
+// CheckSourcePathInFs makes sure no existing parent of path inside fs
+// is a symlink, as writing through one could escape the worktree
+func CheckSourcePathInFs(fs billy.Filesystem, path string) error {
+	current := ""
+	for _, segment := range strings.Split(filepath.Dir(path), string(filepath.Separator)) {
+		if segment == "" || segment == "." {
+			continue
+		}
+		current = filepath.Join(current, segment)
+
+		fi, err := fs.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return fmt.Errorf("could not stat %s: %v", current, err)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("path %s goes through symlink %s", path, current)
+		}
+	}
+
+	fi, err := fs.Lstat(path)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("path %s is a symlink", path)
+	}
+
+	return nil
+}
+
 func StrContains(a []string, b string) bool {
 	for _, val := range a {
 		if val == b {
@@ -95,36 +453,139 @@ func StrContains(a []string, b string) bool {
 // CompareHash checks if content and checksum matches
 // returns the hash type if success else nil
 func (pd *ProcessData) CompareHash(content []byte, checksum string) hash.Hash {
-	var hashType hash.Hash
-
-	switch len(checksum) {
-	case 128:
-		hashType = sha512.New()
-		break
-	case 64:
-		hashType = sha256.New()
-		break
-	case 40:
-		hashType = sha1.New()
-		break
-	case 32:
-		hashType = md5.New()
-		break
-	default:
+	hashType, err := compareHashReader(bytes.NewReader(content), checksum)
+	if err != nil {
+		pd.Log.Debug("checksum does not match", "checksum", checksum, "error", err)
 		return nil
 	}
 
-	hashType.Reset()
-	_, err := hashType.Write(content)
+	return hashType
+}
+
+// CompareHashes checks content against several checksums of it. With all
+// set every checksum has to match, otherwise a single match is enough.
+// Returns the hash type of the strongest matching checksum, or nil and the
+// checksums that did not match.
+func (pd *ProcessData) CompareHashes(content []byte, checksums []string, all bool) (hash.Hash, []string) {
+	var strongest hash.Hash
+	var failed []string
+	for _, checksum := range checksums {
+		hashType, err := compareHashReader(bytes.NewReader(content), checksum)
+		if err != nil {
+			pd.Log.Debug("checksum does not match", "checksum", checksum, "error", err)
+			failed = append(failed, checksum)
+			continue
+		}
+		if strongest == nil || hashType.Size() > strongest.Size() {
+			strongest = hashType
+		}
+	}
+
+	if strongest == nil || (all && len(failed) > 0) {
+		return nil, failed
+	}
+
+	return strongest, nil
+}
+
+// CompareHashReader hashes everything read from r and compares it to
+// checksum without keeping the content in memory. The checksum is
+// normalized with NormalizeHash first. Returns the matching hash type.
+// Reading stops with ctx.Err() once ctx is done.
+func (pd *ProcessData) CompareHashReader(ctx context.Context, r io.Reader, checksum string) (hash.Hash, error) {
+	return compareHashReader(&contextReader{ctx: ctx, r: r}, checksum)
+}
+
+func compareHashReader(r io.Reader, checksum string) (hash.Hash, error) {
+	hashType, checksum, err := hashForChecksum(checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(hashType, r)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("could not hash content: %w", err)
 	}
 
 	calculated := hex.EncodeToString(hashType.Sum(nil))
 	if calculated != checksum {
-		pd.Log.Printf("wanted checksum %s, but got %s", checksum, calculated)
-		return nil
+		return nil, fmt.Errorf("wanted checksum %s, but got %s", checksum, calculated)
 	}
 
-	return hashType
+	return hashType, nil
+}
+
+// contextReader fails reads with ctx.Err() once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// HashOverride returns the hash HashOverrides substitutes for the source
+// at path, matched by its full path or its file name
+func HashOverride(pd *ProcessData, path string) (string, bool) {
+	if override, ok := pd.HashOverrides[path]; ok {
+		return override, true
+	}
+	override, ok := pd.HashOverrides[filepath.Base(path)]
+
+	return override, ok
+}
+
+// IsEmptyHash reports whether checksum is the digest of empty content
+func IsEmptyHash(checksum string) bool {
+	hashType, checksum, err := hashForChecksum(checksum)
+	if err != nil {
+		return false
+	}
+
+	return hex.EncodeToString(hashType.Sum(nil)) == checksum
+}
+
+// hashForChecksum returns a fresh hash type for checksum together
+// with the normalized checksum
+func hashForChecksum(checksum string) (hash.Hash, string, error) {
+	algorithm, checksum, err := NormalizeHash(checksum)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return newHash(algorithm), checksum, nil
+}
+
+// NormalizeHash trims whitespace from checksum, lowercases it and strips
+// an algorithm prefix ("sha256:<hex>" or "SHA256=<hex>"). Returns the
+// algorithm, either from the prefix or implied by the checksum length
+// (see RegisterHash), and the bare hex digest.
+func NormalizeHash(checksum string) (string, string, error) {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+
+	algorithm := ""
+	if i := strings.IndexAny(checksum, ":="); i != -1 {
+		algorithm = strings.TrimSpace(checksum[:i])
+		checksum = strings.TrimSpace(checksum[i+1:])
+		if newHash(algorithm) == nil {
+			return "", "", fmt.Errorf("unsupported hash algorithm %s", algorithm)
+		}
+	} else {
+		registered, ok := HashForSize(len(checksum) / 2)
+		if !ok || len(checksum)%2 != 0 {
+			return "", "", fmt.Errorf("could not determine hash algorithm of checksum %s", checksum)
+		}
+		algorithm = registered.Name
+	}
+
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != newHash(algorithm).Size()*2 {
+		return "", "", fmt.Errorf("invalid %s checksum %s", algorithm, checksum)
+	}
+
+	return algorithm, checksum, nil
 }
@@ -0,0 +1,328 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestSanitizeSourcePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		cleaned string
+		ok      bool
+	}{
+		{"SOURCES/a.tar.gz", "SOURCES/a.tar.gz", true},
+		{"./SOURCES//a.tar.gz", "SOURCES/a.tar.gz", true},
+		{"a..b.tar.gz", "a..b.tar.gz", true},
+		{"", "", false},
+		{".", "", false},
+		{"../a.tar.gz", "", false},
+		{"SOURCES/../../a.tar.gz", "", false},
+		{"SOURCES/..", "", false},
+		{"/etc/passwd", "", false},
+	}
+
+	for _, tt := range tests {
+		cleaned, err := SanitizeSourcePath(tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.path, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%q: expected an error, got %q", tt.path, cleaned)
+		}
+		if cleaned != tt.cleaned {
+			t.Errorf("%q: expected %q, got %q", tt.path, tt.cleaned, cleaned)
+		}
+	}
+}
+
+func TestCheckSourcePathInFs(t *testing.T) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "SOURCES/a.tar.gz", []byte("a"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("/etc", "escape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.Symlink("/etc/passwd", "SOURCES/link.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{"SOURCES/a.tar.gz", ""},
+		{"SOURCES/new/b.tar.gz", ""},
+		{"missing/b.tar.gz", ""},
+		{"escape/passwd", "goes through symlink escape"},
+		{"escape/nested/passwd", "goes through symlink escape"},
+		{"SOURCES/link.tar.gz", "is a symlink"},
+	}
+
+	for _, tt := range tests {
+		err := CheckSourcePathInFs(fs, tt.path)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.path, tt.err, err)
+		}
+	}
+}
+
+func TestParseMetadataLines(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	content := "# sources\n\n" + hash + " SOURCES/a.tar.gz\n" + hash + " b.tar.gz\n"
+
+	sources, err := ParseMetadata([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("expected 2 sources, got %d", len(sources))
+	}
+	for i, line := range []int{3, 4} {
+		if sources[i].Line != line {
+			t.Errorf("%s: expected line %d, got %d", sources[i].Path, line, sources[i].Line)
+		}
+	}
+
+	_, err = ParseMetadata([]byte(hash + " SOURCES/a.tar.gz\n" + hash + " ../b.tar.gz\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming line 2, got %v", err)
+	}
+}
+
+func TestIsEmptyHash(t *testing.T) {
+	emptySha256 := sha256.Sum256(nil)
+	emptySha512 := sha512.Sum512(nil)
+	emptyMd5 := md5.Sum(nil)
+	content := sha256.Sum256([]byte("content"))
+
+	tests := []struct {
+		name     string
+		checksum string
+		empty    bool
+	}{
+		{"sha256", hex.EncodeToString(emptySha256[:]), true},
+		{"sha512", hex.EncodeToString(emptySha512[:]), true},
+		{"md5", hex.EncodeToString(emptyMd5[:]), true},
+		{"prefixed", "sha256:" + hex.EncodeToString(emptySha256[:]), true},
+		{"not empty", hex.EncodeToString(content[:]), false},
+		{"invalid", "not a hash", false},
+		{"empty string", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEmptyHash(tt.checksum); got != tt.empty {
+				t.Errorf("expected %v, got %v", tt.empty, got)
+			}
+		})
+	}
+}
+
+func TestCompareHashEmpty(t *testing.T) {
+	pd := &ProcessData{Log: NewLogger(ioutil.Discard, LevelInfo)}
+	empty := sha256.Sum256(nil)
+
+	if pd.CompareHash([]byte{}, hex.EncodeToString(empty[:])) == nil {
+		t.Error("expected empty content to match the sha256 of empty input")
+	}
+	if pd.CompareHash([]byte("x"), hex.EncodeToString(empty[:])) != nil {
+		t.Error("expected content to not match the sha256 of empty input")
+	}
+}
+
+func TestNormalizeHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	digest := hex.EncodeToString(sum[:])
+	md5Sum := md5.Sum([]byte("content"))
+	md5Digest := hex.EncodeToString(md5Sum[:])
+
+	tests := []struct {
+		name      string
+		checksum  string
+		algorithm string
+		hash      string
+		ok        bool
+	}{
+		{"plain", digest, "sha256", digest, true},
+		{"uppercase", strings.ToUpper(digest), "sha256", digest, true},
+		{"prefixed", "sha256:" + digest, "sha256", digest, true},
+		{"uppercase prefix", "SHA256:" + strings.ToUpper(digest), "sha256", digest, true},
+		{"equals prefix", "SHA256=" + digest, "sha256", digest, true},
+		{"padded", "  " + digest + "\t\n", "sha256", digest, true},
+		{"padded prefix", " sha256 : " + digest + " ", "sha256", digest, true},
+		{"md5 by length", md5Digest, "md5", md5Digest, true},
+		{"prefix not matching the length", "sha512:" + digest, "", "", false},
+		{"unsupported algorithm", "crc32:" + digest, "", "", false},
+		{"unknown length", digest[:10], "", "", false},
+		{"not hex", strings.Repeat("z", 64), "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, hash, err := NormalizeHash(tt.checksum)
+			if !tt.ok {
+				if err == nil {
+					t.Errorf("expected an error, got %s %s", algorithm, hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if algorithm != tt.algorithm || hash != tt.hash {
+				t.Errorf("expected %s %s, got %s %s", tt.algorithm, tt.hash, algorithm, hash)
+			}
+		})
+	}
+}
+
+func TestCompareHashNormalized(t *testing.T) {
+	pd := &ProcessData{Log: NewLogger(ioutil.Discard, LevelInfo)}
+	content := []byte("content")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	for _, checksum := range []string{
+		digest,
+		strings.ToUpper(digest),
+		"sha256:" + digest,
+		"SHA256=" + strings.ToUpper(digest),
+		" " + digest + "\n",
+	} {
+		if pd.CompareHash(content, checksum) == nil {
+			t.Errorf("expected %q to match", checksum)
+		}
+		if pd.CompareHash([]byte("other"), checksum) != nil {
+			t.Errorf("expected %q to not match other content", checksum)
+		}
+	}
+}
+
+func TestCompareHashReaderContext(t *testing.T) {
+	pd := &ProcessData{Log: NewLogger(ioutil.Discard, LevelInfo)}
+	content := []byte("content")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+
+	_, err := pd.CompareHashReader(context.Background(), bytes.NewReader(content), digest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pd.CompareHashReader(ctx, bytes.NewReader(content), digest)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestParseMetadataNormalizesHashes(t *testing.T) {
+	sum := sha256.Sum256([]byte("content"))
+	digest := hex.EncodeToString(sum[:])
+	content := strings.ToUpper(digest) + " SOURCES/a.tar.gz\n" +
+		"SHA256:" + digest + " SOURCES/b.tar.gz\n" +
+		"  " + digest + "   SOURCES/c.tar.gz  \n"
+
+	sources, err := ParseMetadata([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 3 {
+		t.Fatalf("expected 3 sources, got %d", len(sources))
+	}
+	for _, source := range sources {
+		if source.Algorithm != "sha256" || source.Hash != digest {
+			t.Errorf("%s: expected sha256 %s, got %s %s", source.Path, digest, source.Algorithm, source.Hash)
+		}
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		pkg      string
+	}{
+		{"plain", "bash", "bash"},
+		{"path", "/srv/rpms/bash", "bash"},
+		{"trailing slash", "/srv/rpms/bash/", "bash"},
+		{"source rpm", "/srv/srpms/bash-4.4.19-14.el8.src.rpm", "bash-4.4.19-14.el8"},
+		{"ssh", "git@git.example.com:rpms/bash.git", "bash"},
+		{"ssh without path", "git@git.example.com:bash.git", "bash"},
+		{"ssh url", "ssh://git@git.example.com/rpms/bash.git", "bash"},
+		{"https", "https://git.example.com/rpms/bash.git", "bash"},
+		{"https without suffix", "https://git.example.com/rpms/bash", "bash"},
+		{"query", "https://git.example.com/rpms/bash.git?ref=c8", "bash"},
+		{"fragment", "https://git.example.com/rpms/bash.git#c8", "bash"},
+		{"source rpm url", "https://cdn.example.com/bash-4.4.19-14.el8.src.rpm?token=x", "bash-4.4.19-14.el8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PackageName(tt.location); got != tt.pkg {
+				t.Errorf("expected %q, got %q", tt.pkg, got)
+			}
+		})
+	}
+}
+
+func TestMetadataFileName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		location string
+		file     string
+	}{
+		{"", "bash", ".bash.metadata"},
+		{"", "git@git.example.com:rpms/bash.git", ".bash.metadata"},
+		{"", "https://git.example.com/rpms/bash.git", ".bash.metadata"},
+		{"%s.sources", "https://git.example.com/rpms/bash.git", "bash.sources"},
+		{"sources", "git@git.example.com:rpms/bash.git", "sources"},
+	}
+
+	for _, tt := range tests {
+		pd := &ProcessData{MetadataFilePattern: tt.pattern}
+		if got := pd.MetadataFileName(tt.location); got != tt.file {
+			t.Errorf("%q with pattern %q: expected %q, got %q", tt.location, tt.pattern, tt.file, got)
+		}
+	}
+}
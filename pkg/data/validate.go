@@ -0,0 +1,232 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// ValidationError lists every problem Validate found with a ProcessData
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid process data: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidProcessData
+}
+
+// TagImportPattern returns the regular expression import tags of the
+// package are matched against. The first group is the tag name without
+// refs/tags/, the second the import branch and the third the nvr.
+func (pd *ProcessData) TagImportPattern() string {
+	branchRegex := regexp.QuoteMeta(fmt.Sprintf("%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix))
+	if pd.LatestVersion {
+		branchRegex = regexp.QuoteMeta(pd.ImportBranchPrefix) + `\d+` + regexp.QuoteMeta(pd.BranchSuffix)
+	}
+	if !pd.StrictBranchMode {
+		branchRegex += "(?:.+|)"
+	} else {
+		branchRegex += "(?:-stream-.+|)"
+	}
+
+	initialVerRegex := regexp.QuoteMeta(PackageName(pd.RpmLocation)) + "-"
+	if pd.PackageVersion != "" {
+		initialVerRegex += regexp.QuoteMeta(pd.PackageVersion) + "-"
+	} else {
+		initialVerRegex += ".+-"
+	}
+	if pd.PackageRelease != "" {
+		initialVerRegex += regexp.QuoteMeta(pd.PackageRelease)
+	} else {
+		initialVerRegex += ".+"
+	}
+
+	return fmt.Sprintf("(?i)refs/tags/(imports/(%s)/(%s))", branchRegex, initialVerRegex)
+}
+
+// Validate checks that every field required for an import is set and
+// usable, and normalizes URL prefixes by trimming trailing slashes.
+// The returned *ValidationError lists every missing or invalid field at once.
+func (pd *ProcessData) Validate() error {
+	var problems []string
+
+	if pd.RpmLocation == "" {
+		problems = append(problems, "RpmLocation is required")
+	}
+	if pd.BareTarget != nil && pd.TaglessMode {
+		problems = append(problems, "a bare target is not supported in tagless mode")
+	}
+	if pd.LatestVersion && pd.TaglessMode {
+		problems = append(problems, "LatestVersion is not supported in tagless mode")
+	} else if pd.Version <= 0 && !pd.LatestVersion {
+		problems = append(problems, fmt.Sprintf("Version must be positive, got %d", pd.Version))
+	}
+	if pd.ImportBranchPrefix == "" {
+		problems = append(problems, "ImportBranchPrefix is required")
+	}
+	if pd.BlobStorage == nil {
+		problems = append(problems, "BlobStorage is required")
+	}
+	if pd.Importer == nil {
+		problems = append(problems, "Importer is required")
+	}
+	if pd.FsCreator == nil {
+		problems = append(problems, "FsCreator is required")
+	}
+	if pd.Log == nil {
+		problems = append(problems, "Log is required")
+	}
+
+	// these end up in the import tag names the tag regex matches against
+	for name, value := range map[string]string{
+		"ImportBranchPrefix": pd.ImportBranchPrefix,
+		"BranchPrefix":       pd.BranchPrefix,
+		"BranchSuffix":       pd.BranchSuffix,
+	} {
+		if strings.ContainsAny(value, "/ \t\n") {
+			problems = append(problems, fmt.Sprintf("%s %q must not contain slashes or whitespace", name, value))
+		}
+	}
+
+	for phase := range pd.Hooks {
+		if !StrContains(HookPhases, phase) {
+			problems = append(problems, fmt.Sprintf("unknown hook phase %q, expected one of %s", phase, strings.Join(HookPhases, ", ")))
+		}
+	}
+
+	for _, glob := range append(append([]string{}, pd.IncludeSources...), pd.ExcludeSources...) {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid source glob %q: %v", glob, err))
+		}
+	}
+
+	if pd.SourceSubdir != "" {
+		subdir, err := SanitizeSourcePath(pd.SourceSubdir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid SourceSubdir: %v", err))
+		} else {
+			pd.SourceSubdir = subdir
+		}
+	}
+
+	pd.RpmLocation = strings.TrimSuffix(pd.RpmLocation, "/")
+	pd.UpstreamPrefix = strings.TrimSuffix(pd.UpstreamPrefix, "/")
+	pd.CdnUrl = strings.TrimSuffix(pd.CdnUrl, "/")
+	// SourceCacheURLs may be shared with a copy of pd, so
+	// the trimmed URLs go into a new slice
+	sourceCacheURLs := make([]string, len(pd.SourceCacheURLs))
+	for i, sourceCacheUrl := range pd.SourceCacheURLs {
+		sourceCacheURLs[i] = strings.TrimSuffix(sourceCacheUrl, "/")
+	}
+	if pd.SourceCacheURLs != nil {
+		pd.SourceCacheURLs = sourceCacheURLs
+	}
+
+	for _, rawUrl := range append([]string{pd.CdnUrl}, pd.SourceCacheURLs...) {
+		if rawUrl == "" {
+			continue
+		}
+		u, err := url.Parse(rawUrl)
+		if err != nil || u.Scheme == "" {
+			problems = append(problems, fmt.Sprintf("invalid lookaside URL %q", rawUrl))
+		}
+	}
+
+	if pd.MaxBlobSize < 0 {
+		problems = append(problems, "MaxBlobSize must not be negative")
+	}
+	if pd.SlowDownloadThreshold < 0 || pd.SlowDownloadDuration < 0 {
+		problems = append(problems, "slow download thresholds must not be negative")
+	}
+	if pd.MaxRunDuration < 0 {
+		problems = append(problems, "MaxRunDuration must not be negative")
+	}
+
+	for name, override := range pd.HashOverrides {
+		if _, _, err := NormalizeHash(override); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid hash override for %s: %v", name, err))
+		}
+	}
+
+	remoteNames := map[string]bool{"upstream": true}
+	for _, remote := range pd.ExtraRemotes {
+		if remote.Name == "" || remote.Url == "" {
+			problems = append(problems, "extra remotes need a name and an url")
+			continue
+		}
+		if remoteNames[remote.Name] {
+			problems = append(problems, fmt.Sprintf("duplicate remote name %s", remote.Name))
+		}
+		remoteNames[remote.Name] = true
+	}
+	for _, dependency := range pd.DependsOn {
+		if strings.TrimSpace(dependency) == "" {
+			problems = append(problems, "DependsOn contains an empty package name")
+		}
+	}
+
+	if strings.Count(pd.MetadataFilePattern, "%s") > 1 || strings.Contains(pd.MetadataFilePattern, "/") {
+		problems = append(problems, fmt.Sprintf("invalid metadata file pattern %q", pd.MetadataFilePattern))
+	}
+	switch pd.SourcesFileFormat {
+	case "", SourcesFormatBSD, SourcesFormatLegacy:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown sources file format %q", pd.SourcesFileFormat))
+	}
+
+	if _, err := regexp.Compile(pd.TagImportPattern()); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid import tag regex: %v", err))
+	}
+
+	if pd.CommitMessageTemplate != "" {
+		if _, err := template.New("commit").Parse(pd.CommitMessageTemplate); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid commit message template: %v", err))
+		}
+	}
+
+	for _, refspec := range pd.FetchRefSpecs {
+		if err := refspec.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid fetch refspec %q: %v", refspec, err))
+		}
+	}
+
+	if pd.SingleTag != "" && pd.ImportCommit != "" {
+		problems = append(problems, "SingleTag and ImportCommit are mutually exclusive")
+	}
+	if pd.SnapshotPath != "" && pd.SnapshotExportPath != "" {
+		problems = append(problems, "SnapshotPath and SnapshotExportPath are mutually exclusive")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
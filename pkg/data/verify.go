@@ -0,0 +1,67 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+// BlobStorageReport lists which sources blob storage holds. Corrupt
+// sources are stored with content that does not match their hash.
+type BlobStorageReport struct {
+	Present []LookasideSource `json:"present"`
+	Missing []LookasideSource `json:"missing"`
+	Corrupt []LookasideSource `json:"corrupt"`
+}
+
+// Complete reports whether blob storage holds every source intact
+func (r *BlobStorageReport) Complete() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0
+}
+
+// VerifyBlobStorage reads every source from blob storage and verifies it
+// against its hashes, without downloading or writing anything
+func (pd *ProcessData) VerifyBlobStorage(ctx context.Context, sources []LookasideSource) (*BlobStorageReport, error) {
+	report := &BlobStorageReport{}
+	for _, source := range sources {
+		// empty sources are never fetched from blob storage
+		if IsEmptyHash(source.Checksum()) {
+			report.Present = append(report.Present, source)
+			continue
+		}
+
+		content, err := pd.ReadBlob(ctx, source.Checksum())
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s from blob storage: %v", source.Hash, err)
+		}
+
+		if content == nil {
+			report.Missing = append(report.Missing, source)
+		} else if hasher, _ := pd.CompareHashes(content, source.Checksums(), true); hasher == nil {
+			report.Corrupt = append(report.Corrupt, source)
+		} else {
+			report.Present = append(report.Present, source)
+		}
+	}
+
+	return report, nil
+}
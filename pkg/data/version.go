@@ -0,0 +1,39 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package data
+
+// Version of srpmproc, set at build time with
+// -ldflags "-X github.com/rocky-linux/srpmproc/pkg/data.Version=..."
+var Version = "dev"
+
+// DefaultUserAgent identifies srpmproc to lookaside caches and git servers
+func DefaultUserAgent() string {
+	return "srpmproc/" + Version
+}
+
+// HTTPUserAgent returns UserAgent, or DefaultUserAgent if it is not set
+func (pd *ProcessData) HTTPUserAgent() string {
+	if pd.UserAgent == "" {
+		return DefaultUserAgent()
+	}
+
+	return pd.UserAgent
+}
@@ -21,6 +21,8 @@
 package directives
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -29,6 +31,7 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"github.com/rocky-linux/srpmproc/pkg/blob"
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
@@ -41,7 +44,7 @@ func eitherString(left string, right string) string {
 	return left
 }
 
-func add(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func add(ctx context.Context, cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	for _, add := range cfg.Add {
 		var replacingBytes []byte
 		var filePath string
@@ -63,7 +66,7 @@ func add(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree
 		case *srpmprocpb.Add_Lookaside:
 			filePath = checkAddPrefix(eitherString(filepath.Base(addType.Lookaside), add.Name))
 			var err error
-			replacingBytes, err = pd.BlobStorage.Read(addType.Lookaside)
+			replacingBytes, err = blob.ReadContext(ctx, pd.BlobStorage, addType.Lookaside)
 			if err != nil {
 				return err
 			}
@@ -76,6 +79,8 @@ func add(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree
 			md.SourcesToIgnore = append(md.SourcesToIgnore, &data.IgnoredSource{
 				Name:         filePath,
 				HashFunction: hashFunction,
+				Checksum:     hex.EncodeToString(hashFunction.Sum(nil)),
+				Algorithm:    data.HashName(hashFunction),
 			})
 			break
 		}
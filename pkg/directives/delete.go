@@ -21,6 +21,7 @@
 package directives
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -29,7 +30,7 @@ import (
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
-func del(cfg *srpmprocpb.Cfg, _ *data.ProcessData, _ *data.ModeData, _ *git.Worktree, pushTree *git.Worktree) error {
+func del(_ context.Context, cfg *srpmprocpb.Cfg, _ *data.ProcessData, _ *data.ModeData, _ *git.Worktree, pushTree *git.Worktree) error {
 	for _, del := range cfg.Delete {
 		filePath := del.File
 		_, err := pushTree.Filesystem.Stat(filePath)
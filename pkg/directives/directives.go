@@ -21,6 +21,7 @@
 package directives
 
 import (
+	"context"
 	"path/filepath"
 	"strings"
 
@@ -38,10 +39,10 @@ func checkAddPrefix(file string) string {
 	return filepath.Join("SOURCES", file)
 }
 
-func Apply(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) []error {
+func Apply(ctx context.Context, cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) []error {
 	var errs []error
 
-	directives := []func(*srpmprocpb.Cfg, *data.ProcessData, *data.ModeData, *git.Worktree, *git.Worktree) error{
+	directives := []func(context.Context, *srpmprocpb.Cfg, *data.ProcessData, *data.ModeData, *git.Worktree, *git.Worktree) error{
 		replace,
 		del,
 		add,
@@ -51,7 +52,7 @@ func Apply(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, patchTr
 	}
 
 	for _, directive := range directives {
-		err := directive(cfg, pd, md, patchTree, pushTree)
+		err := directive(ctx, cfg, pd, md, patchTree, pushTree)
 		if err != nil {
 			errs = append(errs, err)
 		}
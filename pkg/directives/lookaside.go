@@ -24,6 +24,7 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -37,7 +38,7 @@ import (
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
-func lookaside(cfg *srpmprocpb.Cfg, _ *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func lookaside(_ context.Context, cfg *srpmprocpb.Cfg, _ *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	for _, directive := range cfg.Lookaside {
 		var buf bytes.Buffer
 		writer := tar.NewWriter(&buf)
@@ -103,6 +104,8 @@ func lookaside(cfg *srpmprocpb.Cfg, _ *data.ProcessData, md *data.ModeData, patc
 				md.SourcesToIgnore = append(md.SourcesToIgnore, &data.IgnoredSource{
 					Name:         filepath.Join("SOURCES", file),
 					HashFunction: sha256.New(),
+					Checksum:     fmt.Sprintf("%x", sha256.Sum256(bts)),
+					Algorithm:    "sha256",
 				})
 			}
 		}
@@ -141,6 +144,8 @@ func lookaside(cfg *srpmprocpb.Cfg, _ *data.ProcessData, md *data.ModeData, patc
 			md.SourcesToIgnore = append(md.SourcesToIgnore, &data.IgnoredSource{
 				Name:         path,
 				HashFunction: sha256.New(),
+				Checksum:     fmt.Sprintf("%x", sha256.Sum256(gbuf.Bytes())),
+				Algorithm:    "sha256",
 			})
 		}
 	}
@@ -22,6 +22,7 @@ package directives
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/bluekeyes/go-gitdiff/gitdiff"
@@ -30,7 +31,7 @@ import (
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
-func patch(cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func patch(_ context.Context, cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	for _, patch := range cfg.Patch {
 		patchFile, err := patchTree.Filesystem.Open(patch.File)
 		if err != nil {
@@ -38,7 +39,7 @@ func patch(cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTre
 		}
 		files, _, err := gitdiff.Parse(patchFile)
 		if err != nil {
-			pd.Log.Printf("could not parse patch file: %v", err)
+			pd.Log.Warn("could not parse patch file", "error", err)
 			return errors.New(fmt.Sprintf("COULD_NOT_PARSE_PATCH_FILE:%s", patch.File))
 		}
 
@@ -56,7 +57,7 @@ func patch(cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTre
 
 				err = gitdiff.NewApplier(patchSubjectFile).ApplyFile(&output, patchedFile)
 				if err != nil {
-					pd.Log.Printf("could not apply patch: %v", err)
+					pd.Log.Warn("could not apply patch", "error", err)
 					return errors.New(fmt.Sprintf("COULD_NOT_APPLY_PATCH_WITH_SUBJECT:%s", srcPath))
 				}
 			}
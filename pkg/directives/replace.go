@@ -21,6 +21,7 @@
 package directives
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -28,10 +29,11 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"github.com/rocky-linux/srpmproc/pkg/blob"
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
-func replace(cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func replace(ctx context.Context, cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	for _, replace := range cfg.Replace {
 		filePath := checkAddPrefix(replace.File)
 		stat, err := pushTree.Filesystem.Stat(filePath)
@@ -73,7 +75,7 @@ func replace(cfg *srpmprocpb.Cfg, pd *data.ProcessData, _ *data.ModeData, patchT
 			}
 			break
 		case *srpmprocpb.Replace_WithLookaside:
-			bts, err := pd.BlobStorage.Read(replacing.WithLookaside)
+			bts, err := blob.ReadContext(ctx, pd.BlobStorage, replacing.WithLookaside)
 			if err != nil {
 				return err
 			}
@@ -21,6 +21,7 @@
 package directives
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -190,7 +191,7 @@ func strSliceContains(slice []string, str string) bool {
 	return false
 }
 
-func specChange(cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, _ *git.Worktree, pushTree *git.Worktree) error {
+func specChange(_ context.Context, cfg *srpmprocpb.Cfg, pd *data.ProcessData, md *data.ModeData, _ *git.Worktree, pushTree *git.Worktree) error {
 	// no spec change operations present
 	// skip parsing spec
 	if cfg.SpecChange == nil {
@@ -3,40 +3,88 @@ package misc
 import (
 	"fmt"
 	"github.com/rocky-linux/srpmproc/pkg/data"
-	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 func GetTagImportRegex(pd *data.ProcessData) *regexp.Regexp {
-	branchRegex := regexp.QuoteMeta(fmt.Sprintf("%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix))
-	if !pd.StrictBranchMode {
-		branchRegex += "(?:.+|)"
-	} else {
-		branchRegex += "(?:-stream-.+|)"
+	return regexp.MustCompile(pd.TagImportPattern())
+}
+
+// LegacyTagPrefixes are the prefixes of older import tag schemes, tried in
+// order when a tag does not match the imports/<branch>/<nvr> scheme
+var LegacyTagPrefixes = []string{"import/", ""}
+
+// MatchImportTag matches ref against the import tag regex, falling back to
+// the legacy tag schemes. Legacy matches are normalized to the current
+// scheme, so match[1] is always imports/<branch>/<nvr>, match[2] the
+// branch and match[3] the nvr. Returns nil if ref is no import tag.
+func MatchImportTag(pd *data.ProcessData, ref string) []string {
+	ref = NormalizeRef(ref)
+	regex := GetTagImportRegex(pd)
+	if match := regex.FindStringSubmatch(ref); match != nil {
+		return match
 	}
 
-	initialVerRegex := regexp.QuoteMeta(filepath.Base(pd.RpmLocation)) + "-"
-	if pd.PackageVersion != "" {
-		initialVerRegex += regexp.QuoteMeta(pd.PackageVersion) + "-"
-	} else {
-		initialVerRegex += ".+-"
+	tag := strings.TrimPrefix(ref, "refs/tags/")
+	if tag == ref || strings.HasPrefix(tag, "imports/") {
+		return nil
 	}
-	if pd.PackageRelease != "" {
-		initialVerRegex += regexp.QuoteMeta(pd.PackageRelease)
-	} else {
-		initialVerRegex += ".+"
+	for _, prefix := range LegacyTagPrefixes {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		match := regex.FindStringSubmatch("refs/tags/imports/" + strings.TrimPrefix(tag, prefix))
+		if match != nil {
+			match[0] = ref
+			return match
+		}
 	}
 
-	regex := fmt.Sprintf("(?i)refs/tags/(imports/(%s)/(%s))", branchRegex, initialVerRegex)
+	return nil
+}
 
-	return regexp.MustCompile(regex)
+// BranchVersion returns the major version of an import branch like c8
+// or c8-stream-1.0, or 0 if branch does not start with a version
+func BranchVersion(pd *data.ProcessData, branch string) int {
+	version, _ := splitBranchVersion(pd, branch)
+	return version
+}
+
+// StripBranchVersion removes the major version from an import branch,
+// so c8-stream-1.0 and c9-stream-1.0 both become c-stream-1.0
+func StripBranchVersion(pd *data.ProcessData, branch string) string {
+	_, rest := splitBranchVersion(pd, branch)
+	return pd.ImportBranchPrefix + rest
+}
+
+func splitBranchVersion(pd *data.ProcessData, branch string) (int, string) {
+	rest := strings.TrimPrefix(branch, pd.ImportBranchPrefix)
+	i := 0
+	for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+		i++
+	}
+	version, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return 0, rest
+	}
+
+	return version, rest[i:]
+}
+
+var commitHashRegex = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// IsCommitHash reports whether ref is a full commit SHA instead of a branch or tag
+func IsCommitHash(ref string) bool {
+	return commitHashRegex.MatchString(strings.TrimSpace(ref))
 }
 
 // Given a git reference in tagless mode (like "refs/heads/c9s", or "refs/heads/stream-httpd-2.4-rhel-9.1.0"), determine
 // if we are ok with importing that reference.  We are looking for the traditional <prefix><version><suffix> pattern, like "c9s", and also the
 // modular "stream-<NAME>-<VERSION>-rhel-<VERSION> branch pattern as well
 func TaglessRefOk(tag string, pd *data.ProcessData) bool {
+	tag = NormalizeRef(tag)
 
 	// First case is very easy: if we are "refs/heads/<prefix><version><suffix>" , then this is def. a branch we should import
 	if strings.HasPrefix(tag, fmt.Sprintf("refs/heads/%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix)) {
@@ -51,3 +99,35 @@ func TaglessRefOk(tag string, pd *data.ProcessData) bool {
 
 	return false
 }
+
+// refPrefixes are matched case-insensitively and rewritten to this form
+var refPrefixes = []string{"refs/heads/", "refs/tags/", "refs/remotes/"}
+
+// NormalizeRef trims ref, collapses repeated slashes, drops a trailing
+// slash and lowercases well-known prefixes, so "refs/Heads//c9s/" and
+// "refs/heads/c9s" compare equal. Commit hashes are returned trimmed.
+func NormalizeRef(ref string) string {
+	ref = strings.TrimSpace(ref)
+	for strings.Contains(ref, "//") {
+		ref = strings.Replace(ref, "//", "/", -1)
+	}
+	ref = strings.TrimSuffix(ref, "/")
+
+	for _, prefix := range refPrefixes {
+		if len(ref) >= len(prefix) && strings.EqualFold(ref[:len(prefix)], prefix) {
+			return prefix + ref[len(prefix):]
+		}
+	}
+
+	return ref
+}
+
+// HeadsBranch returns the branch name of a refs/heads/ reference
+func HeadsBranch(ref string) (string, bool) {
+	ref = NormalizeRef(ref)
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ref, "refs/heads/"), true
+}
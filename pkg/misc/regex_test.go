@@ -0,0 +1,168 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package misc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+func TestMatchImportTag(t *testing.T) {
+	pd := &data.ProcessData{
+		RpmLocation:        "bash",
+		ImportBranchPrefix: "c",
+		Version:            8,
+	}
+
+	tests := []struct {
+		name  string
+		ref   string
+		match []string
+	}{
+		{
+			name:  "imports",
+			ref:   "refs/tags/imports/c8/bash-4.4.19-14.el8",
+			match: []string{"refs/tags/imports/c8/bash-4.4.19-14.el8", "imports/c8/bash-4.4.19-14.el8", "c8", "bash-4.4.19-14.el8"},
+		},
+		{
+			name:  "legacy import prefix",
+			ref:   "refs/tags/import/c8/bash-4.4.19-10.el8",
+			match: []string{"refs/tags/import/c8/bash-4.4.19-10.el8", "imports/c8/bash-4.4.19-10.el8", "c8", "bash-4.4.19-10.el8"},
+		},
+		{
+			name:  "legacy without prefix",
+			ref:   "refs/tags/c8/bash-4.4.19-7.el8",
+			match: []string{"refs/tags/c8/bash-4.4.19-7.el8", "imports/c8/bash-4.4.19-7.el8", "c8", "bash-4.4.19-7.el8"},
+		},
+		{
+			name:  "legacy stream branch",
+			ref:   "refs/tags/import/c8-stream-1.0/bash-4.4.19-7.el8",
+			match: []string{"refs/tags/import/c8-stream-1.0/bash-4.4.19-7.el8", "imports/c8-stream-1.0/bash-4.4.19-7.el8", "c8-stream-1.0", "bash-4.4.19-7.el8"},
+		},
+		{
+			name:  "legacy with a malformed ref",
+			ref:   " refs/Tags//import/c8/bash-4.4.19-7.el8/",
+			match: []string{"refs/tags/import/c8/bash-4.4.19-7.el8", "imports/c8/bash-4.4.19-7.el8", "c8", "bash-4.4.19-7.el8"},
+		},
+		{
+			name: "other version",
+			ref:  "refs/tags/import/c7/bash-4.2.46-34.el7",
+		},
+		{
+			name: "other package",
+			ref:  "refs/tags/import/c8/zsh-5.5.1-6.el8",
+		},
+		{
+			name: "imports not matching",
+			ref:  "refs/tags/imports/c7/bash-4.2.46-34.el7",
+		},
+		{
+			name: "branch",
+			ref:  "refs/heads/c8/bash-4.4.19-7.el8",
+		},
+		{
+			name: "unknown prefix",
+			ref:  "refs/tags/archive/c8/bash-4.4.19-7.el8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchImportTag(pd, tt.ref); !reflect.DeepEqual(got, tt.match) {
+				t.Errorf("expected %q, got %q", tt.match, got)
+			}
+		})
+	}
+}
+
+func TestNormalizeRef(t *testing.T) {
+	tests := []struct {
+		ref        string
+		normalized string
+	}{
+		{"refs/heads/c9s", "refs/heads/c9s"},
+		{"refs/heads//c9s", "refs/heads/c9s"},
+		{"refs//heads///c9s", "refs/heads/c9s"},
+		{"refs/heads/c9s/", "refs/heads/c9s"},
+		{" refs/heads/c9s\n", "refs/heads/c9s"},
+		{"refs/Heads/c9s", "refs/heads/c9s"},
+		{"REFS/TAGS/imports/c8/Bash-1-1", "refs/tags/imports/c8/Bash-1-1"},
+		{"refs/remotes/origin/c9s", "refs/remotes/origin/c9s"},
+		{"refs/heads/stream-httpd-2.4-rhel-9.1.0", "refs/heads/stream-httpd-2.4-rhel-9.1.0"},
+		{"c9s", "c9s"},
+		{" " + strings.Repeat("a", 40) + " ", strings.Repeat("a", 40)},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeRef(tt.ref); got != tt.normalized {
+			t.Errorf("%q: expected %q, got %q", tt.ref, tt.normalized, got)
+		}
+	}
+}
+
+func TestHeadsBranch(t *testing.T) {
+	tests := []struct {
+		ref    string
+		branch string
+		ok     bool
+	}{
+		{"refs/heads/c9s", "c9s", true},
+		{"refs/heads//c9s", "c9s", true},
+		{"refs/heads/c9s/", "c9s", true},
+		{"REFS/heads/c9s", "c9s", true},
+		{"refs/heads/stream-httpd-2.4-rhel-9.1.0", "stream-httpd-2.4-rhel-9.1.0", true},
+		{"refs/tags/imports/c9s/bash-5.1.8-2.el9", "", false},
+		{"refs/headsc9s", "", false},
+		{"c9s", "", false},
+	}
+
+	for _, tt := range tests {
+		branch, ok := HeadsBranch(tt.ref)
+		if branch != tt.branch || ok != tt.ok {
+			t.Errorf("%q: expected %q %v, got %q %v", tt.ref, tt.branch, tt.ok, branch, ok)
+		}
+	}
+}
+
+func TestTaglessRefOkMalformed(t *testing.T) {
+	pd := &data.ProcessData{ImportBranchPrefix: "c", Version: 9, BranchSuffix: "s"}
+
+	tests := []struct {
+		ref string
+		ok  bool
+	}{
+		{"refs/heads/c9s", true},
+		{"refs/heads//c9s", true},
+		{"refs/Heads/c9s/", true},
+		{" refs/heads/stream-httpd-2.4-rhel-9.1.0 ", true},
+		{"refs//heads/stream-httpd-2.4-rhel-8.6.0", false},
+		{"refs/heads//c8s", false},
+	}
+
+	for _, tt := range tests {
+		if got := TaglessRefOk(tt.ref, pd); got != tt.ok {
+			t.Errorf("%q: expected %v, got %v", tt.ref, tt.ok, got)
+		}
+	}
+}
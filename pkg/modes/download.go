@@ -0,0 +1,577 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rocky-linux/srpmproc/pkg/blob"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// retrieveBlob returns the content of the blob for hash, trying the blob cache,
+// the offline snapshot, the local source directory, the checkpoint of an
+// interrupted import, blob storage and finally the lookaside cache in that order. The origin of the blob is returned
+// along with it, the url that served a download is in md.BlobSources. Downloads also return the ETag the lookaside
+// sent, which the caller records with writeETag once the blob is verified.
+func retrieveBlob(ctx context.Context, pd *data.ProcessData, md *data.ModeData, fetcher blobFetcher, branchName string, hash string, path string) ([]byte, string, string, error) {
+	if cached := md.BlobCache.Get(hash); cached != nil {
+		pd.Metrics().BlobCacheHit()
+		pd.Log.Info("retrieving blob from cache", "hash", hash)
+		return cached, data.OriginCache, "", nil
+	}
+	pd.Metrics().BlobCacheMiss()
+
+	body, err := readSnapshotBlob(md, hash)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if body != nil {
+		pd.Log.Info("retrieving blob from snapshot", "hash", hash)
+		md.BlobCache.Set(hash, body)
+		return body, data.OriginSnapshot, "", nil
+	}
+
+	body, err = readLocalSource(pd, hash)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if body != nil {
+		pd.Log.Info("retrieving blob from local source directory", "hash", hash, "dir", pd.LocalSourceDir)
+		md.BlobCache.Set(hash, body)
+		return body, data.OriginLocal, "", nil
+	}
+
+	body, err = readCheckpointBlob(pd, md, hash)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if body != nil {
+		pd.Log.Info("retrieving blob from checkpoint", "hash", hash)
+		md.BlobCache.Set(hash, body)
+		return body, data.OriginCheckpoint, "", nil
+	}
+
+	fromBlobStorage, err := pd.ReadBlob(ctx, hash)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if fromBlobStorage != nil && !pd.NoStorageDownload {
+		pd.Log.Info("downloading blob from blob storage", "hash", hash)
+		md.BlobCache.Set(hash, fromBlobStorage)
+		return fromBlobStorage, data.OriginBlobStorage, "", nil
+	}
+
+	if pd.SnapshotPath != "" {
+		return nil, "", "", &data.BlobNotFoundError{Hash: hash, Err: fmt.Errorf("neither in the snapshot nor in blob storage")}
+	}
+
+	// a blob downloaded before is only downloaded again if the
+	// lookaside copy changed since its ETag was recorded
+	var recorded *blobETag
+	if pd.LookasideETags {
+		recorded = readETag(ctx, pd, hash)
+	}
+
+	urls := blobUrls(pd, md, branchName, hash, path)
+	pd.Log.Info("downloading blob", "hash", hash, "url", urls[0])
+
+	body, etag, servedBy, err := downloadBlob(ctx, pd, fetcher, urls, recorded)
+	origin := data.OriginLookaside
+	if err == errNotModified && fromBlobStorage != nil {
+		pd.Log.Info("blob not modified, using blob storage", "hash", hash)
+		body = fromBlobStorage
+		origin = data.OriginBlobStorage
+		etag = ""
+		err = nil
+	} else if err == errNotModified {
+		pd.Log.Warn("blob not modified, but missing in blob storage, downloading it again", "hash", hash)
+		body, etag, servedBy, err = downloadBlob(ctx, pd, fetcher, urls, nil)
+	}
+	if err != nil {
+		return nil, "", "", &data.BlobNotFoundError{Hash: hash, Url: urls[len(urls)-1], Err: err}
+	}
+	if servedBy != "" {
+		if md.BlobSources == nil {
+			md.BlobSources = map[string]string{}
+		}
+		md.BlobSources[hash] = servedBy
+	}
+	md.BlobCache.Set(hash, body)
+
+	return body, origin, etag, nil
+}
+
+// readCheckpointBlob reads the blob for hash saved by an interrupted
+// import of md. Returns nil if there is none or it does not match hash.
+func readCheckpointBlob(pd *data.ProcessData, md *data.ModeData, hash string) ([]byte, error) {
+	body, err := pd.CheckpointBlob(md.Name, hash)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	if pd.CompareHash(body, hash) == nil {
+		pd.Log.Warn("ignoring checkpoint blob, its content does not match", "hash", hash)
+		return nil, nil
+	}
+
+	return body, nil
+}
+
+// readLocalSource reads the file named after hash from LocalSourceDir.
+// Returns nil if there is no such file or it does not match hash.
+func readLocalSource(pd *data.ProcessData, hash string) ([]byte, error) {
+	if pd.LocalSourceDir == "" {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(pd.LocalSourceDir, hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read local source %s: %v", hash, err)
+	}
+	if pd.CompareHash(body, hash) == nil {
+		pd.Log.Warn("ignoring local source, its content does not match", "hash", hash)
+		return nil, nil
+	}
+
+	return body, nil
+}
+
+// decompressBlob decompresses a zstd compressed blob whose hash was
+// calculated over the decompressed content. Blobs matching their hash
+// as they are, like sources that are .zst files themselves, are kept.
+// MaxBlobSize applies to the decompressed content as well.
+func decompressBlob(ctx context.Context, pd *data.ProcessData, source data.LookasideSource, body []byte) ([]byte, error) {
+	if data.CompressionFormat(body) != "zstd" {
+		return body, nil
+	}
+	if _, err := pd.CompareHashReader(ctx, bytes.NewReader(body), source.Checksum()); err == nil {
+		return body, nil
+	}
+
+	decompressed, err := data.DecompressLimit(body, pd.MaxBlobSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress blob %s: %w", source.Hash, err)
+	}
+	pd.Log.Info("decompressed zstd blob", "hash", source.Hash)
+
+	return decompressed, nil
+}
+
+func etagKey(hash string) string {
+	return hash + ".etag"
+}
+
+// blobETag is the ETag a lookaside url sent along with a blob
+type blobETag struct {
+	url  string
+	etag string
+}
+
+// readETag returns the ETag recorded for hash in blob storage,
+// or nil if there is none
+func readETag(ctx context.Context, pd *data.ProcessData, hash string) *blobETag {
+	content, err := blob.ReadContext(ctx, pd.BlobStorage, etagKey(hash))
+	if err != nil || content == nil {
+		return nil
+	}
+
+	parts := strings.SplitN(string(content), "\n", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+
+	return &blobETag{url: parts[0], etag: parts[1]}
+}
+
+// writeETag records the ETag url sent for the blob of hash in blob
+// storage. It must only be called once the blob is verified, so a
+// corrupt download is not considered current by later imports.
+func writeETag(ctx context.Context, pd *data.ProcessData, hash string, url string, etag string) error {
+	if !pd.LookasideETags || pd.NoStorageUpload || url == "" || etag == "" {
+		return nil
+	}
+
+	err := pd.WriteBlob(ctx, etagKey(hash), []byte(url+"\n"+etag))
+	if err != nil {
+		return fmt.Errorf("could not store ETag for %s: %v", hash, err)
+	}
+
+	return nil
+}
+
+// blobUrls lists the locations of a blob in the order they are tried:
+// the lookaside cache followed by every fallback source cache, each
+// with the package layout first and the plain hash layout second
+func blobUrls(pd *data.ProcessData, md *data.ModeData, branchName string, hash string, path string) []string {
+	var urls []string
+	for _, cdnUrl := range append([]string{pd.CdnUrl}, pd.SourceCacheURLs...) {
+		urls = append(urls, lookasideUrl(pd, cdnUrl, md, branchName, hash, path))
+		urls = append(urls, fmt.Sprintf("%s/%s", cdnUrl, hash))
+	}
+
+	return urls
+}
+
+func lookasideUrl(pd *data.ProcessData, cdnUrl string, md *data.ModeData, branchName string, hash string, path string) string {
+	// Alternate lookaside logic:  if enabled, we pull from a new URL pattern
+	if !pd.AltLookAside {
+		return fmt.Sprintf("%s/%s/%s/%s", cdnUrl, md.Name, branchName, hash)
+	}
+
+	// We first need the hash algorithm based on length of hash:
+	hashType, _, err := data.NormalizeHash(hash)
+	if err != nil {
+		hashType = "sha512"
+	}
+
+	// need the name of the file without "SOURCES/", Stream
+	// repositories keep their sources at the root instead
+	fileName := filepath.Base(path)
+
+	// Alt. lookaside url is of the form: <cdn> / <name> / <filename> / <hashtype> / <hash> / <filename>
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s", cdnUrl, md.Name, fileName, hashType, hash, fileName)
+}
+
+// blobFetcher fetches a single blob from url. It is the seam between
+// the lookaside logic and the network, so downloads can be replaced
+// by fixtures without running a server.
+type blobFetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// etagFetcher is implemented by fetchers that support conditional
+// requests. FetchIfNoneMatch returns errNotModified if etag still
+// matches, else the content along with its new ETag.
+type etagFetcher interface {
+	FetchIfNoneMatch(ctx context.Context, url string, etag string) ([]byte, string, error)
+}
+
+// newBlobFetcher returns the fetcher WriteSource downloads blobs with
+var newBlobFetcher = func(pd *data.ProcessData) blobFetcher {
+	return &httpFetcher{pd: pd, client: lookasideClient(pd)}
+}
+
+// httpFetcher downloads blobs from the lookaside cache over HTTP
+type httpFetcher struct {
+	pd     *data.ProcessData
+	client *http.Client
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	body, _, err := f.FetchIfNoneMatch(ctx, url, "")
+	return body, err
+}
+
+func (f *httpFetcher) FetchIfNoneMatch(ctx context.Context, url string, etag string) ([]byte, string, error) {
+	return fetchBlob(ctx, f.pd, f.client, url, etag)
+}
+
+// fetchWithETag fetches url with fetcher, conditionally if it supports
+// ETags. Other fetchers always download and never return an ETag.
+func fetchWithETag(ctx context.Context, fetcher blobFetcher, url string, etag string) ([]byte, string, error) {
+	if ef, ok := fetcher.(etagFetcher); ok {
+		return ef.FetchIfNoneMatch(ctx, url, etag)
+	}
+
+	body, err := fetcher.Fetch(ctx, url)
+	return body, "", err
+}
+
+// lookasideClient builds the HTTP client used to download blobs
+// from the lookaside cache, unless the caller provided its own.
+// A provided client is used as is, so its transport and redirect
+// policy replace TlsConfig, MaxRedirects and SameHostRedirects.
+func lookasideClient(pd *data.ProcessData) *http.Client {
+	if pd.HTTPClient != nil {
+		return pd.HTTPClient
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DisableCompression: false,
+			TLSClientConfig:    pd.TlsConfig,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return checkRedirect(pd, req, via)
+		},
+	}
+}
+
+// checkRedirect enforces the redirect policy of pd for lookaside downloads
+func checkRedirect(pd *data.ProcessData, req *http.Request, via []*http.Request) error {
+	maxRedirects := pd.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+	if maxRedirects < 0 {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	from := data.HostOf(via[len(via)-1].URL.String())
+	to := data.HostOf(req.URL.String())
+	if pd.SameHostRedirects && from != to {
+		return fmt.Errorf("refusing to follow redirect from %s to %s", from, to)
+	}
+
+	// credentials are resolved for the host redirected to, so
+	// those of the previous host never leak to another one
+	req.Header.Del("Authorization")
+	setLookasideAuth(pd, req)
+	pd.Log.Debug("following redirect", "url", req.URL)
+
+	return nil
+}
+
+// setLookasideAuth adds the credentials for the host of req
+func setLookasideAuth(pd *data.ProcessData, req *http.Request) {
+	auth := pd.AuthForHost(data.HostOf(req.URL.String()))
+	if auth == nil {
+		return
+	}
+
+	if auth.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+	} else if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+}
+
+// errNotModified is returned by downloadBlob when the lookaside
+// answered a conditional request with 304 Not Modified
+var errNotModified = errors.New("blob not modified")
+
+// downloadBlob downloads a blob from the first of urls that serves it,
+// moving on to the next one on errors. The request to the url recorded
+// is conditional, errNotModified is returned when its ETag still matches.
+// The ETag of the downloaded blob and the url that served it are
+// returned along with its content.
+func downloadBlob(ctx context.Context, pd *data.ProcessData, fetcher blobFetcher, urls []string, recorded *blobETag) ([]byte, string, string, error) {
+	start := time.Now()
+
+	var lastErr error
+	for _, url := range urls {
+		urlStart := time.Now()
+		// an ETag is only meaningful to the url that sent it
+		var etag string
+		if recorded != nil && recorded.url == url {
+			etag = recorded.etag
+		}
+		body, newEtag, err := fetchWithETag(ctx, fetcher, url, etag)
+		if err == errNotModified {
+			return nil, etag, url, err
+		}
+		if err != nil {
+			pd.Log.Warn("could not download blob", "url", url, "error", err)
+			lastErr = err
+			continue
+		}
+
+		pd.Metrics().BlobDownloaded(int64(len(body)), time.Since(start))
+		warnSlowDownload(pd, url, int64(len(body)), time.Since(urlStart))
+
+		return body, newEtag, url, nil
+	}
+
+	pd.Metrics().DownloadFailed()
+	return nil, "", "", lastErr
+}
+
+// warnSlowDownload logs downloads below SlowDownloadThreshold
+// bytes per second or above SlowDownloadDuration
+func warnSlowDownload(pd *data.ProcessData, url string, size int64, elapsed time.Duration) {
+	slow := pd.SlowDownloadDuration > 0 && elapsed > pd.SlowDownloadDuration
+	if pd.SlowDownloadThreshold > 0 && elapsed > 0 {
+		bytesPerSecond := float64(size) / elapsed.Seconds()
+		slow = slow || bytesPerSecond < float64(pd.SlowDownloadThreshold)
+	}
+	if slow {
+		pd.Log.Warn("slow download", "url", url, "bytes", size, "elapsed", elapsed)
+	}
+}
+
+func fetchBlob(ctx context.Context, pd *data.ProcessData, client *http.Client, url string, etag string) ([]byte, string, error) {
+	// a HEAD request finds missing blobs without transferring them
+	var expectedLength int64 = -1
+	if pd.PreflightHead {
+		resp, err := httpRequest(ctx, pd, client, "HEAD", url, etag)
+		if err != nil {
+			return nil, "", err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusNotModified {
+			return nil, "", errNotModified
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("could not find dist-git file (status code %d)", resp.StatusCode)
+		}
+		expectedLength = resp.ContentLength
+		err = checkBlobSize(pd, expectedLength)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	resp, err := httpGet(ctx, pd, client, url, etag)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		return nil, "", errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, "", fmt.Errorf("could not download dist-git file (status code %d)", resp.StatusCode)
+	}
+
+	err = checkBlobSize(pd, resp.ContentLength)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, "", err
+	}
+
+	// the limit is enforced while reading as well, as
+	// Content-Length may be missing or wrong
+	var reader io.Reader = resp.Body
+	if pd.MaxBlobSize > 0 {
+		reader = io.LimitReader(resp.Body, pd.MaxBlobSize+1)
+	}
+	reader = pd.ProgressReader(reader, url, resp.ContentLength)
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, "", fmt.Errorf("could not read the whole dist-git file: %v", err)
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not close body handle: %v", err)
+	}
+	err = checkBlobSize(pd, int64(len(body)))
+	if err != nil {
+		return nil, "", err
+	}
+	if expectedLength >= 0 && int64(len(body)) != expectedLength {
+		return nil, "", fmt.Errorf("dist-git file is %d bytes, but HEAD announced %d", len(body), expectedLength)
+	}
+
+	return body, resp.Header.Get("ETag"), nil
+}
+
+// checkBlobSize returns an error if size exceeds MaxBlobSize,
+// a negative size is unknown and always accepted
+func checkBlobSize(pd *data.ProcessData, size int64) error {
+	if pd.MaxBlobSize > 0 && size > pd.MaxBlobSize {
+		return fmt.Errorf("dist-git file exceeds the maximum blob size of %d bytes", pd.MaxBlobSize)
+	}
+
+	return nil
+}
+
+// maxThrottledRetries is how often a request answered
+// with 429 Too Many Requests is retried
+const maxThrottledRetries = 5
+
+func httpGet(ctx context.Context, pd *data.ProcessData, client *http.Client, url string, etag string) (*http.Response, error) {
+	return httpRequest(ctx, pd, client, "GET", url, etag)
+}
+
+// httpRequest requests url within the rate limit of its host. Requests
+// the server throttles are retried after the delay it asks for.
+func httpRequest(ctx context.Context, pd *data.ProcessData, client *http.Client, method string, url string, etag string) (*http.Response, error) {
+	host := data.HostOf(url)
+
+	for i := 0; ; i++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create new http request: %v", err)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept-Encoding", "*")
+		req.Header.Set("User-Agent", pd.HTTPUserAgent())
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		setLookasideAuth(pd, req)
+
+		err = pd.HostLimiter.Wait(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not download dist-git file: %v", err)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || i == maxThrottledRetries {
+			return resp, nil
+		}
+		_ = resp.Body.Close()
+
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		pd.Log.Warn("host is throttling requests", "host", host, "retry_after", retryAfter)
+		err = pd.HostLimiter.Delay(ctx, host, retryAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxRetryAfter caps the delay a throttling server can ask for
+const maxRetryAfter = 5 * time.Minute
+
+// parseRetryAfter reads a Retry-After header given either in seconds
+// or as an HTTP date, falling back to a short default. The delay is
+// capped at maxRetryAfter.
+func parseRetryAfter(value string) time.Duration {
+	d := 5 * time.Second
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		d = maxRetryAfter
+		if seconds < int64(maxRetryAfter/time.Second) {
+			d = time.Duration(seconds) * time.Second
+		}
+	} else if date, err := http.ParseTime(value); err == nil {
+		d = time.Until(date)
+		if d < 0 {
+			d = 0
+		}
+	}
+
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
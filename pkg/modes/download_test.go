@@ -0,0 +1,368 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rocky-linux/srpmproc/pkg/blob/file"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// fakeFetcher serves its blobs by the last element of the url
+// and records every url it was asked for
+type fakeFetcher struct {
+	blobs   map[string][]byte
+	fetched []string
+}
+
+func (f *fakeFetcher) Fetch(_ context.Context, url string) ([]byte, error) {
+	f.fetched = append(f.fetched, url)
+	body, ok := f.blobs[path.Base(url)]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", url)
+	}
+
+	return body, nil
+}
+
+// useFetcher makes WriteSource download blobs with fetcher for the rest of the test
+func useFetcher(t *testing.T, fetcher blobFetcher) {
+	orig := newBlobFetcher
+	newBlobFetcher = func(*data.ProcessData) blobFetcher {
+		return fetcher
+	}
+	t.Cleanup(func() {
+		newBlobFetcher = orig
+	})
+}
+
+// newTestImport returns the process and mode data of an import of the
+// package pkg whose worktree holds files. The import runs in tagless mode,
+// so WriteSource reads the worktree as it is instead of checking it out.
+func newTestImport(t *testing.T, files map[string][]byte) (*data.ProcessData, *data.ModeData) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		err := util.WriteFile(w.Filesystem, path, content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pd := &data.ProcessData{
+		Log:                data.NewLogger(ioutil.Discard, data.LevelInfo),
+		BlobStorage:        file.New(t.TempDir()),
+		CdnUrl:             "https://lookaside.example.com",
+		TaglessMode:        true,
+		ImportBranchPrefix: "c",
+		Version:            8,
+	}
+	md := &data.ModeData{
+		Name:      "pkg",
+		Repo:      repo,
+		Worktree:  w,
+		BlobCache: pd.NewBlobCache(),
+	}
+
+	return pd, md
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func readWorktreeFile(t *testing.T, md *data.ModeData, path string) []byte {
+	f, err := md.Worktree.Filesystem.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", path, err)
+	}
+
+	return content
+}
+
+func TestWriteSourceFetcher(t *testing.T) {
+	content := []byte("source tarball")
+	hash := sha256Hex(content)
+
+	tests := []struct {
+		name    string
+		blobs   map[string][]byte
+		cached  []byte
+		err     error
+		fetched int
+	}{
+		{
+			name:    "download",
+			blobs:   map[string][]byte{hash: content},
+			fetched: 1,
+		},
+		{
+			name:    "cached",
+			cached:  content,
+			fetched: 0,
+		},
+		{
+			name:    "checksum mismatch",
+			blobs:   map[string][]byte{hash: []byte("tampered")},
+			err:     data.ErrChecksumMismatch,
+			fetched: 1,
+		},
+		{
+			name:    "not found",
+			blobs:   map[string][]byte{},
+			err:     data.ErrBlobNotFound,
+			fetched: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{blobs: tt.blobs}
+			useFetcher(t, fetcher)
+			pd, md := newTestImport(t, map[string][]byte{
+				".pkg.metadata": []byte(hash + " SOURCES/a.tar.gz\n"),
+			})
+			if tt.cached != nil {
+				md.BlobCache.Set(hash, tt.cached)
+			}
+
+			err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if got := readWorktreeFile(t, md, "SOURCES/a.tar.gz"); string(got) != string(content) {
+				t.Errorf("expected %q to be written, got %q", content, got)
+			}
+			if len(fetcher.fetched) != tt.fetched {
+				t.Errorf("expected %d fetches, got %v", tt.fetched, fetcher.fetched)
+			}
+		})
+	}
+}
+
+func TestWriteSourceOverwriteExisting(t *testing.T) {
+	content := []byte("source tarball")
+	hash := sha256Hex(content)
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		existing  []byte
+		fetched   int
+	}{
+		{
+			name:      "overwrite",
+			overwrite: true,
+			existing:  content,
+			fetched:   1,
+		},
+		{
+			name:     "keep matching",
+			existing: content,
+			fetched:  0,
+		},
+		{
+			name:     "replace mismatching",
+			existing: []byte("stale"),
+			fetched:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{blobs: map[string][]byte{hash: content}}
+			useFetcher(t, fetcher)
+			pd, md := newTestImport(t, map[string][]byte{
+				".pkg.metadata":    []byte(hash + " SOURCES/a.tar.gz\n"),
+				"SOURCES/a.tar.gz": tt.existing,
+			})
+			pd.OverwriteExisting = tt.overwrite
+
+			err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readWorktreeFile(t, md, "SOURCES/a.tar.gz"); string(got) != string(content) {
+				t.Errorf("expected %q, got %q", content, got)
+			}
+			if len(fetcher.fetched) != tt.fetched {
+				t.Errorf("expected %d fetches, got %v", tt.fetched, fetcher.fetched)
+			}
+		})
+	}
+}
+
+func TestDownloadBlobFallback(t *testing.T) {
+	fetcher := &fakeFetcher{blobs: map[string][]byte{"b": []byte("content")}}
+	pd, _ := newTestImport(t, nil)
+
+	body, _, servedBy, err := downloadBlob(context.Background(), pd, fetcher, []string{"https://one/a", "https://two/b"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "content" || servedBy != "https://two/b" {
+		t.Errorf("expected content from https://two/b, got %q from %s", body, servedBy)
+	}
+	if len(fetcher.fetched) != 2 {
+		t.Errorf("expected both urls to be tried, got %v", fetcher.fetched)
+	}
+}
+
+func zstdCompressed(t *testing.T, content []byte) []byte {
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	return w.EncodeAll(content, nil)
+}
+
+func TestWriteSourceZstd(t *testing.T) {
+	content := bytes.Repeat([]byte("source tarball "), 1024)
+	compressed := zstdCompressed(t, content)
+
+	tests := []struct {
+		name        string
+		hash        string
+		decompress  bool
+		maxBlobSize int64
+		written     []byte
+		err         error
+	}{
+		{
+			name:       "hashed compressed",
+			hash:       sha256Hex(compressed),
+			decompress: true,
+			written:    compressed,
+		},
+		{
+			name:       "hashed decompressed",
+			hash:       sha256Hex(content),
+			decompress: true,
+			written:    content,
+		},
+		{
+			name:    "hashed compressed without decompression",
+			hash:    sha256Hex(compressed),
+			written: compressed,
+		},
+		{
+			name: "hashed decompressed without decompression",
+			hash: sha256Hex(content),
+			err:  data.ErrChecksumMismatch,
+		},
+		{
+			name:        "decompressed within the maximum blob size",
+			hash:        sha256Hex(content),
+			decompress:  true,
+			maxBlobSize: int64(len(content)),
+			written:     content,
+		},
+		{
+			name:        "decompressed over the maximum blob size",
+			hash:        sha256Hex(content),
+			decompress:  true,
+			maxBlobSize: int64(len(content)) - 1,
+			err:         data.ErrTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFetcher(t, &fakeFetcher{blobs: map[string][]byte{tt.hash: compressed}})
+			pd, md := newTestImport(t, map[string][]byte{
+				".pkg.metadata": []byte(tt.hash + " SOURCES/a.tar.zst\n"),
+			})
+			pd.DecompressZstdBlobs = tt.decompress
+			pd.MaxBlobSize = tt.maxBlobSize
+
+			err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readWorktreeFile(t, md, "SOURCES/a.tar.zst"); !bytes.Equal(got, tt.written) {
+				t.Errorf("expected %q to be written, got %q", tt.written, got)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "seconds", value: "10", want: 10 * time.Second},
+		{name: "seconds over the cap", value: "86400", want: maxRetryAfter},
+		{name: "overflowing seconds", value: "99999999999999999", want: maxRetryAfter},
+		{name: "past date", value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), want: 0},
+		{name: "date over the cap", value: time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), want: maxRetryAfter},
+		{name: "invalid", value: "soon", want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -21,28 +21,37 @@
 package modes
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/rocky-linux/srpmproc/pkg/misc"
+	"io"
 	"io/ioutil"
-	"net/http"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/rocky-linux/srpmproc/pkg/data"
 )
 
 type remoteTarget struct {
-	remote string
-	when   time.Time
+	remote  string
+	when    time.Time
+	commit  plumbing.Hash
+	version int
+	tagger  object.Signature
+	// source is the name of the remote the tag was found in
+	source string
 }
 
 type remoteTargetSlice []remoteTarget
@@ -59,12 +68,42 @@ func (p remoteTargetSlice) Swap(i, j int) {
 	p[i], p[j] = p[j], p[i]
 }
 
+// GitModeName is the name GitMode is registered under
+const GitModeName = "git"
+
+func init() {
+	data.RegisterMode(GitModeName, func() data.ImportMode {
+		return &GitMode{}
+	})
+}
+
 type GitMode struct{}
 
-func (g *GitMode) RetrieveSource(pd *data.ProcessData) (*data.ModeData, error) {
-	repo, err := git.Init(memory.NewStorage(), memfs.New())
+func (g *GitMode) RetrieveSource(ctx context.Context, pd *data.ProcessData) (*data.ModeData, error) {
+	// the extra remotes are fetched while upstream is
+	var extras []*extraRemote
+	var extrasErr error
+	var wg sync.WaitGroup
+	if len(pd.ExtraRemotes) > 0 {
+		if pd.SnapshotPath != "" {
+			pd.Log.Warn("ignoring extra remotes, importing from a snapshot")
+		} else {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				extras, extrasErr = fetchExtraRemotes(ctx, pd)
+			}()
+		}
+	}
+
+	fetchedAt := time.Now().UTC()
+	repo, remote, snapshotBlobs, err := openUpstream(ctx, pd)
+	wg.Wait()
 	if err != nil {
-		return nil, fmt.Errorf("could not init git Repo: %v", err)
+		return nil, err
+	}
+	if extrasErr != nil {
+		return nil, extrasErr
 	}
 
 	w, err := repo.Worktree()
@@ -72,160 +111,242 @@ func (g *GitMode) RetrieveSource(pd *data.ProcessData) (*data.ModeData, error) {
 		return nil, fmt.Errorf("could not get Worktree: %v", err)
 	}
 
-	refspec := config.RefSpec("+refs/heads/*:refs/remotes/*")
-	remote, err := repo.CreateRemote(&config.RemoteConfig{
-		Name:  "upstream",
-		URLs:  []string{fmt.Sprintf("%s.git", pd.RpmLocation)},
-		Fetch: []config.RefSpec{refspec},
-	})
+	tagScanStart := time.Now()
+	var branches remoteTargetSlice
+
+	latestTags := map[string]*remoteTarget{}
+
+	listErr, err := scanTags(ctx, pd, repo, remote, "upstream", latestTags)
 	if err != nil {
-		return nil, fmt.Errorf("could not create remote: %v", err)
+		return nil, err
 	}
+	for _, extra := range extras {
+		extraListErr, err := scanTags(ctx, pd, extra.repo, extra.remote, extra.name, latestTags)
+		if err != nil {
+			return nil, err
+		}
+		if listErr == nil {
+			listErr = extraListErr
+		}
 
-	fetchOpts := &git.FetchOptions{
-		Auth:     pd.Authenticator,
-		RefSpecs: []config.RefSpec{refspec},
-		Tags:     git.AllTags,
-		Force:    true,
+		// branches of the extra remote are checked out from it later
+		_, err = repo.CreateRemote(extra.remote.Config())
+		if err != nil && err != git.ErrRemoteExists {
+			return nil, fmt.Errorf("could not add remote %s: %v", extra.name, err)
+		}
 	}
 
-	err = remote.Fetch(fetchOpts)
-	if err != nil {
-		if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
-			fetchOpts.Auth = nil
-			err = remote.Fetch(fetchOpts)
-			if err != nil {
-				return nil, fmt.Errorf("could not fetch upstream: %v", err)
-			}
-		} else {
-			return nil, fmt.Errorf("could not fetch upstream: %v", err)
+	pd.Metrics().TagScanDuration(time.Since(tagScanStart))
+
+	for _, branch := range latestTags {
+		pd.Log.Info("found import tag", "tag", strings.TrimPrefix(branch.remote, "refs/tags/"))
+		branches = append(branches, *branch)
+	}
+	sort.Sort(branches)
+
+	var sortedBranches []string
+	branchCommits := map[string]string{}
+	branchTaggers := map[string]string{}
+	branchRemotes := map[string]string{}
+	resolvedVersion := pd.Version
+	for _, branch := range branches {
+		sortedBranches = append(sortedBranches, branch.remote)
+		if !branch.commit.IsZero() {
+			branchCommits[branch.remote] = branch.commit.String()
+		}
+		if branch.tagger.Name != "" {
+			branchTaggers[branch.remote] = branch.tagger.String()
+		}
+		if branch.source != "" {
+			branchRemotes[branch.remote] = branch.source
+		}
+		// branches are sorted oldest first
+		if pd.LatestVersion {
+			resolvedVersion = branch.version
 		}
 	}
 
-	var branches remoteTargetSlice
+	return &data.ModeData{
+		Name:          data.PackageName(pd.RpmLocation),
+		Repo:          repo,
+		Worktree:      w,
+		FileWrites:    nil,
+		Branches:      sortedBranches,
+		BranchCommits: branchCommits,
+		BranchTaggers: branchTaggers,
+		BranchRemotes: branchRemotes,
+		Version:       resolvedVersion,
+		SnapshotBlobs: snapshotBlobs,
+		Provenance: &data.Provenance{
+			Package:   data.PackageName(pd.RpmLocation),
+			FetchedAt: fetchedAt,
+		},
+	}, listErr
+}
 
-	latestTags := map[string]*remoteTarget{}
+// scanTags adds the import tags of the remote name to latestTags,
+// keeping the preferred tag of every branch. A failed list is not
+// fatal and returned as listErr, the annotated tags are still
+// importable and the caller decides whether that is enough.
+func scanTags(ctx context.Context, pd *data.ProcessData, repo *git.Repository, remote *git.Remote, name string, latestTags map[string]*remoteTarget) (listErr error, err error) {
+	tagIter, err := newTagIter(ctx, pd, repo, remote)
+	if err != nil {
+		return nil, err
+	}
+	defer tagIter.Close()
 
-	tagAdd := func(tag *object.Tag) error {
-		if strings.HasPrefix(tag.Name, fmt.Sprintf("imports/%s%d", pd.ImportBranchPrefix, pd.Version)) {
-			refSpec := fmt.Sprintf("refs/tags/%s", tag.Name)
-			if misc.GetTagImportRegex(pd).MatchString(refSpec) {
-				match := misc.GetTagImportRegex(pd).FindStringSubmatch(refSpec)
+	for {
+		tag, err := tagIter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var le *data.ListError
+			if errors.As(err, &le) {
+				listErr = err
+				pd.Log.Warn("could not list upstream refs", "error", listErr)
+				continue
+			}
+			return nil, err
+		}
 
-				exists := latestTags[match[2]]
-				if exists != nil && exists.when.After(tag.Tagger.When) {
-					return nil
-				}
-				latestTags[match[2]] = &remoteTarget{
-					remote: refSpec,
-					when:   tag.Tagger.When,
-				}
+		if pd.TaglessMode {
+			exists := latestTags[tag.Branch]
+			if exists != nil && remoteRank(pd, exists.source) < remoteRank(pd, name) {
+				continue
+			}
+			pd.Log.Info("identified tagless commit for import", "ref", tag.Ref)
+			latestTags[tag.Branch] = &remoteTarget{
+				remote: tag.Ref,
+				when:   tag.When,
+				commit: tag.Commit,
+				source: name,
 			}
+			continue
+		}
+
+		// without a fixed version, the newest import of
+		// a branch wins no matter which version it is for
+		key := tag.Branch
+		if pd.LatestVersion {
+			key = misc.StripBranchVersion(pd, tag.Branch)
+		}
+
+		candidate := &remoteTarget{
+			remote:  tag.Ref,
+			when:    tag.When,
+			commit:  tag.Commit,
+			version: misc.BranchVersion(pd, tag.Branch),
+			tagger:  tag.Tagger,
+			source:  name,
+		}
+		if preferredTarget(pd, candidate, latestTags[key]) {
+			latestTags[key] = candidate
 		}
-		return nil
 	}
 
-	// In case of "tagless mode", we need to get the head ref of the branch instead
-	// This is a kind of alternative implementation of the above tagAdd assignment
-	refAdd := func(tag *object.Tag) error {
-		if misc.TaglessRefOk(tag.Name, pd) {
-			pd.Log.Printf("Tagless mode:  Identified tagless commit for import: %s\n", tag.Name)
-			refSpec := fmt.Sprintf(tag.Name)
+	return listErr, nil
+}
 
-			// We split the string by "/", the branch name we're looking for to pass to latestTags is always last
-			// (ex: "refs/heads/c9s" ---> we want latestTags[c9s]
-			tmpRef := strings.Split(refSpec, "/")
-			tmpBranchName := tmpRef[(len(tmpRef) - 1)]
+// openUpstream opens the snapshot, the already fetched repository or
+// fetches upstream, in that order of preference
+func openUpstream(ctx context.Context, pd *data.ProcessData) (*git.Repository, *git.Remote, billy.Filesystem, error) {
+	if pd.SnapshotPath != "" {
+		pd.Log.Info("using snapshot instead of upstream", "snapshot", pd.SnapshotPath)
+		repo, snapshotBlobs, err := openSnapshot(pd.SnapshotPath)
+		return repo, nil, snapshotBlobs, err
+	}
 
-			latestTags[tmpBranchName] = &remoteTarget{
-				remote: refSpec,
-				when:   tag.Tagger.When,
-			}
+	if pd.FetchedRepo != nil {
+		remote, err := pd.FetchedRepo.Remote("upstream")
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("could not get upstream remote of fetched repo: %v", err)
 		}
-		return nil
+		return pd.FetchedRepo, remote, nil, nil
 	}
 
-	tagIter, err := repo.TagObjects()
+	repo, remote, err := fetchUpstream(ctx, pd)
+	return repo, remote, nil, err
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("could not get tag objects: %v", err)
+// importableRef reports whether ref would be imported if its commit
+// resolved. Annotated tags are excluded, they are resolved as tag objects.
+func importableRef(pd *data.ProcessData, repo *git.Repository, ref *plumbing.Reference) bool {
+	if _, err := repo.TagObject(ref.Hash()); err == nil {
+		return false
 	}
-
-	// tagless mode means we use "refAdd" (add commit by reference)
-	// normal mode means we can rely on "tagAdd" (the tag should be present for us in the source repo)
 	if pd.TaglessMode {
-		_ = tagIter.ForEach(refAdd)
-	} else {
-		_ = tagIter.ForEach(tagAdd)
+		return misc.TaglessRefOk(string(ref.Name()), pd)
 	}
 
-	listOpts := &git.ListOptions{
-		Auth: pd.Authenticator,
+	return misc.MatchImportTag(pd, string(ref.Name())) != nil
+}
+
+// tagTime returns when tag was created. Tags without a tagger time
+// fall back to the committer time of the commit they point to.
+func tagTime(repo *git.Repository, tag *object.Tag) time.Time {
+	if !tag.Tagger.When.IsZero() {
+		return tag.Tagger.When
+	}
+
+	target := tag.Target
+	if nested, err := repo.TagObject(target); err == nil {
+		target = nested.Target
 	}
-	list, err := remote.List(listOpts)
+	commit, err := repo.CommitObject(target)
 	if err != nil {
-		if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
-			listOpts.Auth = nil
-			list, err = remote.List(listOpts)
-			if err != nil {
-				return nil, fmt.Errorf("could not list upstream: %v", err)
-			}
-		} else {
-			return nil, fmt.Errorf("could not list upstream: %v", err)
-		}
+		return tag.Tagger.When
 	}
 
-	for _, ref := range list {
-		if ref.Hash().IsZero() {
-			continue
-		}
-
-		commit, err := repo.CommitObject(ref.Hash())
-		if err != nil {
-			continue
-		}
+	return commit.Committer.When
+}
 
-		// Call refAdd instead of tagAdd in the case of TaglessMode enabled
-		if pd.TaglessMode {
-			_ = refAdd(&object.Tag{
-				Name:   string(ref.Name()),
-				Tagger: commit.Committer,
-			})
-		} else {
-			_ = tagAdd(&object.Tag{
-				Name:   strings.TrimPrefix(string(ref.Name()), "refs/tags/"),
-				Tagger: commit.Committer,
-			})
-		}
+func upstreamUrl(pd *data.ProcessData) string {
+	return fmt.Sprintf("%s.git", pd.RpmLocation)
+}
 
+// ListSources checks out md.TagBranch and returns every source its metadata
+// file references, without downloading any of them
+func (g *GitMode) ListSources(ctx context.Context, pd *data.ProcessData, md *data.ModeData) ([]data.LookasideSource, error) {
+	_, err := checkoutSource(ctx, pd, md)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, branch := range latestTags {
-		pd.Log.Printf("tag: %s", strings.TrimPrefix(branch.remote, "refs/tags/"))
-		branches = append(branches, *branch)
+	metadataPath, fileBytes, err := readMetadata(pd, md)
+	if err != nil || fileBytes == nil {
+		return nil, err
 	}
-	sort.Sort(branches)
 
-	var sortedBranches []string
-	for _, branch := range branches {
-		sortedBranches = append(sortedBranches, branch.remote)
-	}
+	return parseSources(pd, metadataPath, fileBytes)
+}
 
-	return &data.ModeData{
-		Name:       filepath.Base(pd.RpmLocation),
-		Repo:       repo,
-		Worktree:   w,
-		FileWrites: nil,
-		Branches:   sortedBranches,
-	}, nil
+// sourceRemoteName returns the remote md.TagBranch is fetched from,
+// branches found in an extra remote are fetched from there
+func sourceRemoteName(md *data.ModeData) string {
+	if name := md.BranchRemotes[md.TagBranch]; name != "" {
+		return name
+	}
+	return "upstream"
 }
 
-func (g *GitMode) WriteSource(pd *data.ProcessData, md *data.ModeData) error {
+// sourceRemoteUrl returns the url of the remote md.TagBranch
+// is fetched from, or "" if there is no such remote
+func sourceRemoteUrl(md *data.ModeData) string {
+	remote, err := md.Repo.Remote(sourceRemoteName(md))
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
 
-	remote, err := md.Repo.Remote("upstream")
+// checkoutSource checks out md.TagBranch from upstream into md.Worktree
+// and returns the name of the upstream branch it was imported from
+func checkoutSource(ctx context.Context, pd *data.ProcessData, md *data.ModeData) (string, error) {
+	remote, err := md.Repo.Remote(sourceRemoteName(md))
 
-	if err != nil && !pd.TaglessMode {
-		return fmt.Errorf("could not get upstream remote: %v", err)
+	if err != nil && !pd.TaglessMode && pd.SnapshotPath == "" {
+		return "", fmt.Errorf("could not get upstream remote: %v", err)
 	}
 
 	var refspec config.RefSpec
@@ -233,49 +354,48 @@ func (g *GitMode) WriteSource(pd *data.ProcessData, md *data.ModeData) error {
 
 	// In the case of tagless mode, we already have the transformed repo sitting in the worktree,
 	// and don't need to perform any checkout or fetch operations
-	if !pd.TaglessMode {
-		if strings.HasPrefix(md.TagBranch, "refs/heads") {
-			refspec = config.RefSpec(fmt.Sprintf("+%s:%s", md.TagBranch, md.TagBranch))
-			branchName = strings.TrimPrefix(md.TagBranch, "refs/heads/")
+	if !pd.TaglessMode && misc.IsCommitHash(md.TagBranch) {
+		branchName = fmt.Sprintf("%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix)
+		err = checkoutCommit(ctx, pd, md, remote, plumbing.NewHash(strings.TrimSpace(md.TagBranch)))
+		if err != nil {
+			return "", err
+		}
+	} else if !pd.TaglessMode {
+		// the ref is normalized for matching and naming the local ref,
+		// upstream only has it under its original name
+		tagBranch := misc.NormalizeRef(md.TagBranch)
+		if heads, ok := misc.HeadsBranch(tagBranch); ok {
+			refspec = config.RefSpec(fmt.Sprintf("+%s:%s", strings.TrimSpace(md.TagBranch), tagBranch))
+			branchName = heads
 		} else {
-			match := misc.GetTagImportRegex(pd).FindStringSubmatch(md.TagBranch)
-			branchName = match[2]
-			refspec = config.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", branchName, md.TagBranch))
-			fmt.Println("Found branchname that does not start w/ refs/heads :: ", branchName)
-		}
-		pd.Log.Printf("checking out upstream refspec %s", refspec)
-
-		fetchOpts := &git.FetchOptions{
-			Auth:       pd.Authenticator,
-			RemoteName: "upstream",
-			RefSpecs:   []config.RefSpec{refspec},
-			Tags:       git.AllTags,
-			Force:      true,
-		}
-		err = remote.Fetch(fetchOpts)
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
-				fetchOpts.Auth = nil
-				err = remote.Fetch(fetchOpts)
-				if err != nil && err != git.NoErrAlreadyUpToDate {
-					return fmt.Errorf("could not fetch upstream: %v", err)
-				}
-			} else {
-				return fmt.Errorf("could not fetch upstream: %v", err)
+			match := misc.MatchImportTag(pd, tagBranch)
+			if match == nil {
+				return "", fmt.Errorf("could not determine branch of %s", md.TagBranch)
 			}
+			branchName = match[2]
+			refspec = config.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", branchName, tagBranch))
+			pd.Log.Warn("found branch name that does not start with refs/heads", "branch", branchName)
+		}
+		if pd.SnapshotPath != "" {
+			err = ensureSnapshotRef(md.Repo, tagBranch)
+		} else {
+			pd.Log.Info("checking out upstream refspec", "refspec", refspec)
+			err = fetchTagBranch(ctx, pd, remote, refspec)
+		}
+		if err != nil {
+			return "", err
 		}
 
-		err = md.Worktree.Checkout(&git.CheckoutOptions{
-			Branch: plumbing.ReferenceName(md.TagBranch),
-			Force:  true,
+		err = pd.Checkout(md.Worktree, &git.CheckoutOptions{
+			Branch: plumbing.ReferenceName(tagBranch),
 		})
 		if err != nil {
-			return fmt.Errorf("could not checkout source from git: %v", err)
+			return "", fmt.Errorf("could not checkout source from git: %v", err)
 		}
 
 		_, err = md.Worktree.Add(".")
 		if err != nil {
-			return fmt.Errorf("could not add Worktree: %v", err)
+			return "", fmt.Errorf("could not add Worktree: %v", err)
 		}
 	}
 
@@ -283,166 +403,571 @@ func (g *GitMode) WriteSource(pd *data.ProcessData, md *data.ModeData) error {
 		branchName = fmt.Sprintf("%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix)
 	}
 
-	metadataPath := ""
-	ls, err := md.Worktree.Filesystem.ReadDir(".")
+	return branchName, nil
+}
+
+// readMetadata returns the path and decompressed content of the metadata
+// file in md.Worktree, the content is nil if there is no metadata file
+func readMetadata(pd *data.ProcessData, md *data.ModeData) (string, []byte, error) {
+	metadataPath, err := pd.FindMetadataFile(md.Worktree.Filesystem, md.Name)
 	if err != nil {
-		return fmt.Errorf("could not read directory: %v", err)
+		return "", nil, err
 	}
-	for _, f := range ls {
-		if strings.HasSuffix(f.Name(), ".metadata") {
-			if metadataPath != "" {
-				return fmt.Errorf("multiple metadata files found")
+
+	metadataFile, err := md.Worktree.Filesystem.Open(metadataPath)
+	if os.IsNotExist(err) {
+		pd.Log.Warn("could not open metadata file, so skipping", "path", metadataPath, "error", err)
+		return metadataPath, nil, nil
+	}
+	if err != nil {
+		return "", nil, &data.MetadataMissingError{Path: metadataPath, Err: err}
+	}
+	defer metadataFile.Close()
+
+	fileBytes, err := ioutil.ReadAll(metadataFile)
+	if err != nil {
+		return "", nil, &data.MetadataMissingError{Path: metadataPath, Err: err}
+	}
+	if format := data.CompressionFormat(fileBytes); format != "" {
+		pd.Log.Info("decompressing metadata file", "format", format, "path", metadataPath)
+		fileBytes, err = data.Decompress(fileBytes)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	return metadataPath, fileBytes, nil
+}
+
+// parseSources parses a metadata file with MetadataParser or the
+// built-in line parser, applying hash overrides and dropping
+// duplicate entries
+func parseSources(pd *data.ProcessData, metadataPath string, fileBytes []byte) ([]data.LookasideSource, error) {
+	parser := pd.MetadataParser
+	if parser == nil {
+		parser = data.ParseMetadata
+	}
+	parsed, err := parser(fileBytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", metadataPath, err)
+	}
+
+	var sources []data.LookasideSource
+	seen := map[string]data.LookasideSource{}
+	for _, source := range parsed {
+		location := sourceLocation(metadataPath, source)
+		// parsers written against SourceRef only set the name
+		if source.Path == "" {
+			source.Path = source.Name
+		}
+		source.Path, err = data.SanitizeSourcePath(source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path in %s: %v", location, err)
+		}
+		source.Name = filepath.Base(source.Path)
+		path := source.Path
+
+		if override, ok := data.HashOverride(pd, path); ok {
+			pd.Log.Warn("overriding source hash", "path", path, "hash", source.Hash, "override", override, "metadata", location)
+			source.Algorithm, source.Hash, err = data.NormalizeHash(override)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hash override for %s listed in %s: %v", path, location, err)
 			}
-			metadataPath = f.Name()
+			source.ExtraChecksums = nil
 		}
+
+		if first, ok := seen[path]; ok {
+			firstLocation := sourceLocation(metadataPath, first)
+			if first.Hash == source.Hash {
+				pd.Log.Info("skipping duplicate metadata entry", "path", path, "metadata", location, "first", firstLocation)
+				continue
+			}
+			if !pd.AllowDuplicates {
+				return nil, fmt.Errorf("%s is listed twice with different hashes, %s in %s and %s in %s", path, first.Hash, firstLocation, source.Hash, location)
+			}
+			pd.Log.Warn("source listed twice with different hashes, using the last one", "path", path, "first", first.Hash, "first_metadata", firstLocation, "last", source.Hash, "last_metadata", location)
+		}
+		seen[path] = source
+
+		sources = append(sources, source)
 	}
-	if metadataPath == "" {
-		metadataPath = fmt.Sprintf(".%s.metadata", md.Name)
+
+	return sources, nil
+}
+
+// sourceLocation names the metadata file and, if known, the line source is listed on
+func sourceLocation(metadataPath string, source data.LookasideSource) string {
+	if source.Line == 0 {
+		return metadataPath
 	}
+	return fmt.Sprintf("%s:%d", metadataPath, source.Line)
+}
 
-	metadataFile, err := md.Worktree.Filesystem.Open(metadataPath)
+// Fetch fetches the upstream package repository without scanning it, the
+// repository can be passed to RetrieveSource through pd.FetchedRepo
+func Fetch(ctx context.Context, pd *data.ProcessData) (*git.Repository, error) {
+	repo, _, err := fetchUpstream(ctx, pd)
 	if err != nil {
-		pd.Log.Printf("warn: could not open metadata file, so skipping: %v", err)
-		return nil
+		return nil, err
 	}
 
-	fileBytes, err := ioutil.ReadAll(metadataFile)
+	return repo, nil
+}
+
+// fetchUpstream creates an in-memory repository and fetches the import tags
+// (see importTagRefSpecs) and the branches selected by FetchRefSpecs (all by default) of the upstream
+// package repository into it
+func fetchUpstream(ctx context.Context, pd *data.ProcessData) (*git.Repository, *git.Remote, error) {
+	return fetchRemote(ctx, pd, "upstream", upstreamUrl(pd))
+}
+
+// fetchRemote fetches url like fetchUpstream, as the remote name
+func fetchRemote(ctx context.Context, pd *data.ProcessData, name string, url string) (*git.Repository, *git.Remote, error) {
+	repo, err := openRemoteRepo(pd, name, url)
 	if err != nil {
-		return fmt.Errorf("could not read metadata file: %v", err)
+		return nil, nil, err
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			DisableCompression: false,
-		},
+	refspecs := pd.FetchRefSpecs
+	if len(refspecs) == 0 {
+		refspecs = []config.RefSpec{"+refs/heads/*:refs/remotes/*"}
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name:  name,
+		URLs:  []string{url},
+		Fetch: refspecs,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create remote: %v", err)
 	}
-	fileContent := strings.Split(string(fileBytes), "\n")
-	for _, line := range fileContent {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
 
-		lineInfo := strings.SplitN(line, " ", 2)
-		hash := strings.TrimSpace(lineInfo[0])
-		path := strings.TrimSpace(lineInfo[1])
+	fetchRefSpecs := append(append([]config.RefSpec{}, refspecs...), importTagRefSpecs(pd)...)
+	host := data.HostOf(url)
+	if pd.GitProtocolV2 {
+		err = pd.HostLimiter.Wait(ctx, host)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = fetchProtocolV2(ctx, pd, repo, url, fetchRefSpecs)
+		if err == nil {
+			return repo, remote, nil
+		}
+		pd.Log.Warn("protocol v2 fetch failed, falling back to go-git", "error", err)
+	}
 
-		var body []byte
+	fetchOpts := &git.FetchOptions{
+		Auth:     pd.Authenticator,
+		RefSpecs: fetchRefSpecs,
+		Tags:     git.NoTags,
+		Force:    true,
+		Progress: pd.FetchProgressWriter(url),
+	}
 
-		if md.BlobCache[hash] != nil {
-			body = md.BlobCache[hash]
-			pd.Log.Printf("retrieving %s from cache", hash)
-		} else {
-			fromBlobStorage, err := pd.BlobStorage.Read(hash)
+	err = pd.HostLimiter.Wait(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = remote.FetchContext(ctx, fetchOpts)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
+			fetchOpts.Auth = nil
+			err = pd.HostLimiter.Wait(ctx, host)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			if fromBlobStorage != nil && !pd.NoStorageDownload {
-				body = fromBlobStorage
-				pd.Log.Printf("downloading %s from blob storage", hash)
-			} else {
-
-				url := ""
-				// Alternate lookaside logic:  if enabled, we pull from a new URL pattern
-				if !pd.AltLookAside {
-					url = fmt.Sprintf("%s/%s/%s/%s", pd.CdnUrl, md.Name, branchName, hash)
-				} else {
-					// We first need the hash algorithm based on length of hash:
-					hashType := "sha512"
-					switch len(hash) {
-					case 128:
-						hashType = "sha512"
-					case 64:
-						hashType = "sha256"
-					case 40:
-						hashType = "sha1"
-					case 32:
-						hashType = "md5"
-					}
+			err = remote.FetchContext(ctx, fetchOpts)
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				pd.Metrics().FetchFailed()
+				return nil, nil, &data.FetchError{Url: url, Err: err}
+			}
+		} else {
+			pd.Metrics().FetchFailed()
+			return nil, nil, &data.FetchError{Url: url, Err: err}
+		}
+	}
 
-					// need the name of the file without "SOURCES/":
-					fileName := strings.Split(path, "/")[1]
+	return repo, remote, nil
+}
 
-					// Alt. lookaside url is of the form: <cdn> / <name> / <filename> / <hashtype> / <hash> / <filename>
-					url = fmt.Sprintf("%s/%s/%s/%s/%s/%s", pd.CdnUrl, md.Name, fileName, hashType, hash, fileName)
-				}
+// importTagRefSpecs fetches the tags that can be import tags of pd in
+// the current and the legacy schemes instead of every upstream tag
+func importTagRefSpecs(pd *data.ProcessData) []config.RefSpec {
+	var refspecs []config.RefSpec
+	for _, prefix := range append([]string{"imports/"}, misc.LegacyTagPrefixes...) {
+		ref := "refs/tags/" + prefix + pd.ImportBranchPrefix + "*"
+		refspecs = append(refspecs, config.RefSpec("+"+ref+":"+ref))
+	}
 
-				pd.Log.Printf("downloading %s", url)
+	return refspecs
+}
 
-				req, err := http.NewRequest("GET", url, nil)
-				if err != nil {
-					return fmt.Errorf("could not create new http request: %v", err)
-				}
-				req.Header.Set("Accept-Encoding", "*")
+func (g *GitMode) WriteSource(ctx context.Context, pd *data.ProcessData, md *data.ModeData) error {
+	branchName, err := checkoutSource(ctx, pd, md)
+	if err != nil {
+		return err
+	}
 
-				resp, err := client.Do(req)
-				if err != nil {
-					return fmt.Errorf("could not download dist-git file: %v", err)
-				}
-				if resp.StatusCode != http.StatusOK {
-					url = fmt.Sprintf("%s/%s", pd.CdnUrl, hash)
-					req, err = http.NewRequest("GET", url, nil)
-					if err != nil {
-						return fmt.Errorf("could not create new http request: %v", err)
-					}
-					req.Header.Set("Accept-Encoding", "*")
-					resp, err = client.Do(req)
-					if err != nil {
-						return fmt.Errorf("could not download dist-git file: %v", err)
-					}
-					if resp.StatusCode != http.StatusOK {
-						return fmt.Errorf("could not download dist-git file (status code %d): %v", resp.StatusCode, err)
-					}
-				}
+	metadataPath, fileBytes, err := readMetadata(pd, md)
+	if err != nil || fileBytes == nil {
+		return err
+	}
 
-				body, err = ioutil.ReadAll(resp.Body)
-				if err != nil {
-					return fmt.Errorf("could not read the whole dist-git file: %v", err)
-				}
-				err = resp.Body.Close()
-				if err != nil {
-					return fmt.Errorf("could not close body handle: %v", err)
+	sources, err := parseSources(pd, metadataPath, fileBytes)
+	if err != nil {
+		return err
+	}
+
+	prov := data.BranchProvenance(md, sourceRemoteUrl(md), branchName)
+	// the lookaside cache may key sources by another branch name
+	lookasideBranch := branchName
+	if pd.LookasideBranchFunc != nil {
+		lookasideBranch = pd.LookasideBranchFunc(branchName)
+		if lookasideBranch != branchName {
+			pd.Log.Info("using lookaside branch", "branch", branchName, "lookaside_branch", lookasideBranch)
+		}
+	}
+	fetcher := newBlobFetcher(pd)
+	md.UnchangedSources = 0
+	md.SkippedSources = nil
+	if md.ResolvedBlobs == nil {
+		md.ResolvedBlobs = map[string]string{}
+	}
+	// metadata paths of the sources in the worktree mapped to where they are
+	written := map[string]string{}
+	for _, source := range sources {
+		path := source.Path
+		hash := source.Hash
+		checksum := source.Checksum()
+
+		if !pd.SourceAllowed(path) {
+			pd.Log.Info("skipping source, excluded by source filters", "path", path)
+			md.SkippedSources = append(md.SkippedSources, path)
+			continue
+		}
+
+		targetPath := path
+		if pd.PathRewriter != nil {
+			rewritten, ok := pd.PathRewriter(path)
+			if !ok {
+				pd.Log.Info("skipping source, dropped by path rewriter", "path", path)
+				continue
+			}
+			targetPath, err = data.SanitizeSourcePath(rewritten)
+			if err != nil {
+				return fmt.Errorf("invalid rewritten path for %s: %v", path, err)
+			}
+		}
+		if pd.SourceSubdir != "" {
+			targetPath = filepath.Join(pd.SourceSubdir, targetPath)
+		}
+
+		// sources with the same hash as in the previous import are
+		// taken from disk or blob storage instead of being downloaded
+		unchanged := pd.PreviousSources != nil && pd.PreviousSources[path] == hash
+
+		if !pd.OverwriteExisting || unchanged {
+			existing, err := readExistingSource(md.Worktree.Filesystem, targetPath)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				if hasher, _ := pd.CompareHashes(existing, source.Checksums(), true); hasher != nil {
+					pd.Log.Info("source already matches, skipping download", "path", targetPath, "hash", hash)
+					md.SourcesToIgnore = append(md.SourcesToIgnore, &data.IgnoredSource{
+						Name:         targetPath,
+						HashFunction: hasher,
+						Checksum:     hex.EncodeToString(hasher.Sum(nil)),
+						Algorithm:    data.HashName(hasher),
+					})
+					if unchanged {
+						md.UnchangedSources++
+					}
+					md.ResolvedBlobs[hash] = data.HashName(hasher) + ":" + hex.EncodeToString(hasher.Sum(nil))
+					prov.AddSource(source, targetPath, data.OriginWorktree, "")
+					written[path] = targetPath
+					continue
 				}
 			}
+		}
 
-			md.BlobCache[hash] = body
+		var body []byte
+		var origin string
+		var etag string
+		if data.IsEmptyHash(checksum) {
+			// zero-byte placeholders don't have to be fetched from anywhere
+			pd.Log.Info("source is empty, skipping download", "path", path)
+			body = []byte{}
+			origin = data.OriginEmpty
+		} else if unchanged {
+			body, err = pd.ReadBlob(ctx, checksum)
+			if err != nil {
+				return err
+			}
+			if body != nil {
+				md.UnchangedSources++
+				origin = data.OriginBlobStorage
+			}
+		}
+		if body == nil {
+			body, origin, etag, err = retrieveBlob(ctx, pd, md, fetcher, lookasideBranch, hash, path)
+			if err != nil {
+				return err
+			}
+		}
+		if pd.DecompressZstdBlobs {
+			body, err = decompressBlob(ctx, pd, source, body)
+			if err != nil {
+				return err
+			}
 		}
 
-		f, err := md.Worktree.Filesystem.Create(path)
+		err = data.CheckSourcePathInFs(md.Worktree.Filesystem, targetPath)
 		if err != nil {
-			return fmt.Errorf("could not open file pointer: %v", err)
+			return fmt.Errorf("refusing to write %s from %s: %v", targetPath, sourceLocation(metadataPath, source), err)
 		}
 
-		hasher := pd.CompareHash(body, hash)
+		// the final path is only touched once the content is verified
+		hasher, failed := pd.CompareHashes(body, source.Checksums(), true)
 		if hasher == nil {
-			return fmt.Errorf("checksum in metadata does not match dist-git file")
+			return &data.ChecksumMismatchError{Path: targetPath, Hash: strings.Join(failed, ", ")}
+		}
+		if origin == data.OriginLookaside {
+			err = pd.SaveCheckpointBlob(md.Name, hash, body)
+			if err != nil {
+				return err
+			}
+			err = writeETag(ctx, pd, hash, md.BlobSources[hash], etag)
+			if err != nil {
+				return err
+			}
+		}
+
+		mode := sourceFileMode(pd, md, targetPath)
+		err = data.WriteFileAtomic(md.Worktree.Filesystem, targetPath, body, mode)
+		if err != nil {
+			return fmt.Errorf("could not copy dist-git file to in-tree: %v", err)
 		}
 
 		md.SourcesToIgnore = append(md.SourcesToIgnore, &data.IgnoredSource{
-			Name:         path,
+			Name:         targetPath,
 			HashFunction: hasher,
+			Checksum:     hex.EncodeToString(hasher.Sum(nil)),
+			Algorithm:    data.HashName(hasher),
 		})
 
-		_, err = f.Write(body)
+		err = setSourceAttributes(pd, md.Worktree.Filesystem, targetPath, mode)
 		if err != nil {
-			return fmt.Errorf("could not copy dist-git file to in-tree: %v", err)
+			return err
+		}
+
+		if origin != data.OriginEmpty {
+			md.ResolvedBlobs[hash] = data.HashName(hasher) + ":" + hex.EncodeToString(hasher.Sum(nil))
+		}
+		if pd.OnSourceWritten != nil {
+			pd.OnSourceWritten(targetPath, hash, int64(len(body)))
+		}
+		var blobUrl string
+		if origin == data.OriginLookaside || origin == data.OriginCache {
+			blobUrl = md.BlobSources[hash]
+		}
+		prov.AddSource(source, targetPath, origin, blobUrl)
+		written[path] = targetPath
+	}
+	md.Provenance = prov
+
+	if pd.PreviousSources != nil {
+		pd.Log.Info("skipped downloading sources unchanged since the previous import", "branch", md.TagBranch, "count", md.UnchangedSources)
+	}
+
+	return verifySignatures(pd, md.Worktree.Filesystem, written)
+}
+
+// sourceFileMode returns the mode of path in the checked out upstream
+// tree or worktree if file modes are preserved, else the default mode
+func sourceFileMode(pd *data.ProcessData, md *data.ModeData, path string) os.FileMode {
+	if !pd.PreserveFileModes {
+		return 0644
+	}
+
+	head, err := md.Repo.Head()
+	if err == nil {
+		commit, err := md.Repo.CommitObject(head.Hash())
+		if err == nil {
+			file, err := commit.File(path)
+			if err == nil {
+				mode, err := file.Mode.ToOSFileMode()
+				if err == nil {
+					return mode.Perm()
+				}
+			}
+		}
+	}
+
+	fi, err := md.Worktree.Filesystem.Lstat(path)
+	if err == nil && fi.Mode().IsRegular() {
+		return fi.Mode().Perm()
+	}
+
+	return 0644
+}
+
+// setSourceAttributes applies the preserved mode and the fixed
+// modification time to a written source, if enabled
+func setSourceAttributes(pd *data.ProcessData, fs billy.Filesystem, path string, mode os.FileMode) error {
+	if !pd.PreserveFileModes && pd.SourceMtime.IsZero() {
+		return nil
+	}
+
+	change, ok := fs.(billy.Change)
+	if !ok {
+		pd.Log.Warn("filesystem does not support changing file attributes", "path", path)
+		return nil
+	}
+
+	if pd.PreserveFileModes {
+		err := change.Chmod(path, mode)
+		if err != nil {
+			return fmt.Errorf("could not set mode of %s: %v", path, err)
+		}
+	}
+	if !pd.SourceMtime.IsZero() {
+		err := change.Chtimes(path, pd.SourceMtime, pd.SourceMtime)
+		if err != nil {
+			return fmt.Errorf("could not set modification time of %s: %v", path, err)
 		}
-		_ = f.Close()
 	}
 
 	return nil
 }
 
-func (g *GitMode) PostProcess(md *data.ModeData) error {
-	for _, source := range md.SourcesToIgnore {
-		_, err := md.Worktree.Filesystem.Stat(source.Name)
+// checkoutCommit checks out a detached worktree at commit, fetching it
+// from upstream first if it is not part of the already fetched history
+func checkoutCommit(ctx context.Context, pd *data.ProcessData, md *data.ModeData, remote *git.Remote, commit plumbing.Hash) error {
+	_, err := md.Repo.CommitObject(commit)
+	if err != nil && remote != nil {
+		pd.Log.Info("fetching upstream commit", "commit", commit)
+		refspec := config.RefSpec(fmt.Sprintf("%s:refs/commits/%s", commit, commit))
+		err = fetchTagBranch(ctx, pd, remote, refspec)
 		if err == nil {
-			err := md.Worktree.Filesystem.Remove(source.Name)
+			_, err = md.Repo.CommitObject(commit)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not find upstream commit %s: %v", commit, err)
+	}
+
+	err = pd.Checkout(md.Worktree, &git.CheckoutOptions{
+		Hash: commit,
+	})
+	if err != nil {
+		return fmt.Errorf("could not checkout source from git: %v", err)
+	}
+
+	_, err = md.Worktree.Add(".")
+	if err != nil {
+		return fmt.Errorf("could not add Worktree: %v", err)
+	}
+
+	return nil
+}
+
+// fetchTagBranch fetches the upstream branch behind refspec together with its tags
+func fetchTagBranch(ctx context.Context, pd *data.ProcessData, remote *git.Remote, refspec config.RefSpec) error {
+	url := remote.Config().URLs[0]
+	fetchOpts := &git.FetchOptions{
+		Auth:       pd.Authenticator,
+		RemoteName: remote.Config().Name,
+		RefSpecs:   []config.RefSpec{refspec},
+		Tags:       git.AllTags,
+		Force:      true,
+		Progress:   pd.FetchProgressWriter(url),
+	}
+	host := data.HostOf(url)
+	err := pd.HostLimiter.Wait(ctx, host)
+	if err != nil {
+		return err
+	}
+	err = remote.FetchContext(ctx, fetchOpts)
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
+			fetchOpts.Auth = nil
+			err = pd.HostLimiter.Wait(ctx, host)
 			if err != nil {
-				return fmt.Errorf("could not remove dist-git file: %v", err)
+				return err
+			}
+			err = remote.FetchContext(ctx, fetchOpts)
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				pd.Metrics().FetchFailed()
+				return &data.FetchError{Url: url, Err: err}
+			}
+		} else {
+			pd.Metrics().FetchFailed()
+			return &data.FetchError{Url: url, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// readExistingSource returns the content of path in fs, or nil if
+// there is no regular file there yet
+func readExistingSource(fs billy.Filesystem, path string) ([]byte, error) {
+	fi, err := fs.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open existing file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read existing file %s: %v", path, err)
+	}
+
+	return content, nil
+}
+
+func (g *GitMode) PostProcess(ctx context.Context, pd *data.ProcessData, md *data.ModeData) error {
+	// a fat repo keeps the sources next to the metadata file
+	if !pd.KeepLookasideSources {
+		for _, source := range md.SourcesToIgnore {
+			_, err := md.Worktree.Filesystem.Stat(source.Name)
+			if err == nil {
+				if pd.VerifyBeforeStrip {
+					err := verifyIgnoredSource(ctx, pd, md.Worktree.Filesystem, source)
+					if err != nil {
+						return err
+					}
+				}
+				err := md.Worktree.Filesystem.Remove(source.Name)
+				if err != nil {
+					return fmt.Errorf("could not remove dist-git file: %v", err)
+				}
 			}
 		}
 	}
 
+	if pd.SourcesManifestPath != "" {
+		err := writeSourcesManifest(md, pd.SourcesManifestPath)
+		if err != nil {
+			return err
+		}
+	}
+	if pd.ProvenancePath != "" && md.Provenance != nil {
+		content, err := md.Provenance.JSON()
+		if err != nil {
+			return err
+		}
+		err = data.WriteFileAtomic(md.Worktree.Filesystem, pd.ProvenancePath, content, 0644)
+		if err != nil {
+			return fmt.Errorf("could not write provenance: %v", err)
+		}
+	}
+
 	_, err := md.Worktree.Add(".")
 	if err != nil {
 		return fmt.Errorf("could not add git sources: %v", err)
@@ -451,11 +976,74 @@ func (g *GitMode) PostProcess(md *data.ModeData) error {
 	return nil
 }
 
+// verifyIgnoredSource makes sure source still has the content it was
+// externalized with, so removing it does not lose a later change
+func verifyIgnoredSource(ctx context.Context, pd *data.ProcessData, fs billy.Filesystem, source *data.IgnoredSource) error {
+	if source.Checksum == "" {
+		return nil
+	}
+
+	f, err := fs.Open(source.Name)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", source.Name, err)
+	}
+	defer f.Close()
+
+	_, err = pd.CompareHashReader(ctx, f, source.Checksum)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &data.ModifiedSourceError{Path: source.Name, Hash: source.Checksum}
+	}
+
+	return nil
+}
+
+// writeSourcesManifest records every externalized source together with
+// the name of its hash function, one "<algorithm> <path>" pair per line
+func writeSourcesManifest(md *data.ModeData, path string) error {
+	f, err := md.Worktree.Filesystem.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create sources manifest: %v", err)
+	}
+	defer f.Close()
+
+	for _, source := range md.SourcesToIgnore {
+		if source.Expired {
+			continue
+		}
+
+		_, err := fmt.Fprintf(f, "%s %s\n", source.HashName(), source.Name)
+		if err != nil {
+			return fmt.Errorf("could not write to sources manifest: %v", err)
+		}
+	}
+
+	return nil
+}
+
 func (g *GitMode) ImportName(pd *data.ProcessData, md *data.ModeData) string {
-	if misc.GetTagImportRegex(pd).MatchString(md.TagBranch) {
-		match := misc.GetTagImportRegex(pd).FindStringSubmatch(md.TagBranch)
+	if pd.ImportNameFunc != nil {
+		return pd.ImportNameFunc(md)
+	}
+
+	return DefaultImportName(pd, md)
+}
+
+// DefaultImportName derives the import name from md.TagBranch: the nvr of
+// an import tag, the name and SHA of a commit or the name of a branch
+func DefaultImportName(pd *data.ProcessData, md *data.ModeData) string {
+	if misc.IsCommitHash(md.TagBranch) {
+		return fmt.Sprintf("%s-%s", md.Name, md.TagBranch)
+	}
+	if match := misc.MatchImportTag(pd, md.TagBranch); match != nil {
 		return match[3]
 	}
 
-	return strings.Replace(strings.TrimPrefix(md.TagBranch, "refs/heads/"), "%", "_", -1)
+	name := misc.NormalizeRef(md.TagBranch)
+	if heads, ok := misc.HeadsBranch(name); ok {
+		name = heads
+	}
+	return strings.Replace(name, "%", "_", -1)
 }
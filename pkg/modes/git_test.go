@@ -0,0 +1,562 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+var (
+	hashA = strings.Repeat("a", 64)
+	hashB = strings.Repeat("b", 64)
+)
+
+func TestParseSources(t *testing.T) {
+	tests := []struct {
+		name            string
+		metadata        string
+		parser          func([]byte) ([]data.LookasideSource, error)
+		allowDuplicates bool
+		overrides       map[string]string
+		paths           []string
+		hashes          []string
+		err             string
+	}{
+		{
+			name:     "valid",
+			metadata: hashA + " SOURCES/a.tar.gz\n" + hashB + " SOURCES/b.tar.gz\n",
+			paths:    []string{"SOURCES/a.tar.gz", "SOURCES/b.tar.gz"},
+			hashes:   []string{hashA, hashB},
+		},
+		{
+			name:     "parent directory",
+			metadata: hashA + " SOURCES/a.tar.gz\n" + hashB + " ../../etc/passwd\n",
+			err:      "line 2",
+		},
+		{
+			name:     "absolute path",
+			metadata: "\n" + hashA + " /etc/passwd\n",
+			err:      "line 2",
+		},
+		{
+			name: "parent directory from custom parser",
+			parser: func([]byte) ([]data.LookasideSource, error) {
+				return []data.LookasideSource{{Path: "SOURCES/../../x", Hash: hashA, Line: 3}}, nil
+			},
+			err: "invalid path in .pkg.metadata:3",
+		},
+		{
+			name: "custom parser without lines",
+			parser: func([]byte) ([]data.LookasideSource, error) {
+				return []data.LookasideSource{{Name: "/x", Hash: hashA}}, nil
+			},
+			err: "invalid path in .pkg.metadata: ",
+		},
+		{
+			name:     "duplicate with the same hash",
+			metadata: hashA + " SOURCES/a.tar.gz\n" + hashA + " SOURCES/a.tar.gz\n",
+			paths:    []string{"SOURCES/a.tar.gz"},
+			hashes:   []string{hashA},
+		},
+		{
+			name:     "duplicate with different hashes",
+			metadata: hashA + " SOURCES/a.tar.gz\n# comment\n" + hashB + " SOURCES/a.tar.gz\n",
+			err:      "SOURCES/a.tar.gz is listed twice with different hashes, " + hashA + " in .pkg.metadata:1 and " + hashB + " in .pkg.metadata:3",
+		},
+		{
+			name:            "allowed duplicate with different hashes",
+			metadata:        hashA + " SOURCES/a.tar.gz\n" + hashB + " SOURCES/a.tar.gz\n",
+			allowDuplicates: true,
+			paths:           []string{"SOURCES/a.tar.gz", "SOURCES/a.tar.gz"},
+			hashes:          []string{hashA, hashB},
+		},
+		{
+			name:      "hash override",
+			metadata:  hashA + " SOURCES/a.tar.gz\n",
+			overrides: map[string]string{"a.tar.gz": "sha256:" + hashB},
+			paths:     []string{"SOURCES/a.tar.gz"},
+			hashes:    []string{hashB},
+		},
+		{
+			name:      "invalid hash override",
+			metadata:  "\n\n" + hashA + " SOURCES/a.tar.gz\n",
+			overrides: map[string]string{"SOURCES/a.tar.gz": "nothex"},
+			err:       "invalid hash override for SOURCES/a.tar.gz listed in .pkg.metadata:3",
+		},
+		{
+			name:     "malformed line",
+			metadata: hashA + " SOURCES/a.tar.gz\nbroken\n",
+			err:      "malformed line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd := &data.ProcessData{
+				Log:             data.NewLogger(ioutil.Discard, data.LevelInfo),
+				MetadataParser:  tt.parser,
+				AllowDuplicates: tt.allowDuplicates,
+				HashOverrides:   tt.overrides,
+			}
+
+			sources, err := parseSources(pd, ".pkg.metadata", []byte(tt.metadata))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var paths, hashes []string
+			for _, source := range sources {
+				paths = append(paths, source.Path)
+				hashes = append(hashes, source.Hash)
+			}
+			if !reflect.DeepEqual(paths, tt.paths) {
+				t.Errorf("expected paths %v, got %v", tt.paths, paths)
+			}
+			if !reflect.DeepEqual(hashes, tt.hashes) {
+				t.Errorf("expected hashes %v, got %v", tt.hashes, hashes)
+			}
+		})
+	}
+}
+
+func TestWriteSourceEmptySource(t *testing.T) {
+	fetcher := &fakeFetcher{blobs: map[string][]byte{}}
+	useFetcher(t, fetcher)
+	empty := sha256Hex(nil)
+	pd, md := newTestImport(t, map[string][]byte{
+		".pkg.metadata": []byte(empty + " SOURCES/placeholder\n"),
+	})
+
+	err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content := readWorktreeFile(t, md, "SOURCES/placeholder"); len(content) != 0 {
+		t.Errorf("expected an empty file, got %q", content)
+	}
+	if len(fetcher.fetched) != 0 {
+		t.Errorf("expected no downloads, got %v", fetcher.fetched)
+	}
+	if len(md.SourcesToIgnore) != 1 || md.SourcesToIgnore[0].Checksum != empty {
+		t.Errorf("expected the empty source to be ignored with checksum %s", empty)
+	}
+}
+
+func TestReadMetadataCompressed(t *testing.T) {
+	metadata := []byte(hashA + " SOURCES/a.tar.gz\n" + hashB + " SOURCES/b.tar.gz\n")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gz.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed [][]data.LookasideSource
+	for _, content := range [][]byte{metadata, compressed.Bytes()} {
+		pd, md := newTestImport(t, map[string][]byte{".pkg.metadata": content})
+
+		path, fileBytes, err := readMetadata(pd, md)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != ".pkg.metadata" {
+			t.Errorf("expected .pkg.metadata, got %s", path)
+		}
+		sources, err := parseSources(pd, path, fileBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, sources)
+	}
+
+	if !reflect.DeepEqual(parsed[0], parsed[1]) {
+		t.Errorf("expected the compressed metadata to parse like the plain one, got %v and %v", parsed[0], parsed[1])
+	}
+}
+
+// storeObject encodes obj into the storage of repo and returns its hash
+func storeObject(t *testing.T, repo *git.Repository, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	encoded := repo.Storer.NewEncodedObject()
+	err := obj.Encode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestTagTime(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	tagged := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	emptyTree := storeObject(t, repo, &object.Tree{})
+	commit := storeObject(t, repo, &object.Commit{
+		Author:    object.Signature{Name: "a", Email: "a@example.com", When: committed},
+		Committer: object.Signature{Name: "a", Email: "a@example.com", When: committed},
+		Message:   "import",
+		TreeHash:  emptyTree,
+	})
+	nested := storeObject(t, repo, &object.Tag{
+		Name:       "imports/c8/pkg-1.0-1.el8",
+		Tagger:     object.Signature{Name: "a", Email: "a@example.com", When: tagged},
+		Message:    "nested",
+		TargetType: plumbing.CommitObject,
+		Target:     commit,
+	})
+
+	tests := []struct {
+		name string
+		tag  *object.Tag
+		when time.Time
+	}{
+		{
+			name: "tagger time",
+			tag:  &object.Tag{Tagger: object.Signature{When: tagged}, Target: commit},
+			when: tagged,
+		},
+		{
+			name: "no tagger time",
+			tag:  &object.Tag{Target: commit},
+			when: committed,
+		},
+		{
+			name: "no tagger time on a tag of a tag",
+			tag:  &object.Tag{Target: nested},
+			when: committed,
+		},
+		{
+			name: "unresolvable target",
+			tag:  &object.Tag{Target: plumbing.NewHash(strings.Repeat("1", 40))},
+			when: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagTime(repo, tt.tag); !got.Equal(tt.when) {
+				t.Errorf("expected %s, got %s", tt.when, got)
+			}
+		})
+	}
+}
+
+func TestRemoteTargetSliceZeroTagger(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyTree := storeObject(t, repo, &object.Tree{})
+
+	var targets remoteTargetSlice
+	for i, day := range []int{3, 1, 2} {
+		when := time.Date(2021, 1, day, 0, 0, 0, 0, time.UTC)
+		commit := storeObject(t, repo, &object.Commit{
+			Committer: object.Signature{Name: "a", Email: "a@example.com", When: when},
+			Message:   fmt.Sprintf("import %d", i),
+			TreeHash:  emptyTree,
+		})
+		// the tags carry no tagger time, only their commits order them
+		tag := &object.Tag{Target: commit}
+		targets = append(targets, remoteTarget{remote: fmt.Sprintf("day-%d", day), when: tagTime(repo, tag)})
+	}
+	sort.Sort(targets)
+
+	var order []string
+	for _, target := range targets {
+		order = append(order, target.remote)
+	}
+	if want := []string{"day-1", "day-2", "day-3"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+}
+
+func TestWriteSourceNormalizedHash(t *testing.T) {
+	content := []byte("source tarball")
+	hash := sha256Hex(content)
+
+	for _, listed := range []string{strings.ToUpper(hash), "SHA256:" + hash, "sha256=" + strings.ToUpper(hash)} {
+		t.Run(listed[:7], func(t *testing.T) {
+			fetcher := &fakeFetcher{blobs: map[string][]byte{hash: content}}
+			useFetcher(t, fetcher)
+			pd, md := newTestImport(t, map[string][]byte{
+				".pkg.metadata": []byte(listed + " SOURCES/a.tar.gz\n"),
+			})
+
+			err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readWorktreeFile(t, md, "SOURCES/a.tar.gz"); string(got) != string(content) {
+				t.Errorf("expected %q to be written, got %q", content, got)
+			}
+		})
+	}
+}
+
+func TestWriteSourceDuplicatePath(t *testing.T) {
+	first := []byte("first tarball")
+	last := []byte("last tarball")
+	firstHash := sha256Hex(first)
+	lastHash := sha256Hex(last)
+	metadata := firstHash + " SOURCES/a.tar.gz\n" + lastHash + " SOURCES/a.tar.gz\n"
+
+	tests := []struct {
+		name            string
+		allowDuplicates bool
+		err             string
+		written         []byte
+	}{
+		{
+			name: "rejected",
+			err:  "SOURCES/a.tar.gz is listed twice with different hashes, " + firstHash + " in .pkg.metadata:1 and " + lastHash + " in .pkg.metadata:2",
+		},
+		{
+			name:            "allowed",
+			allowDuplicates: true,
+			written:         last,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{blobs: map[string][]byte{firstHash: first, lastHash: last}}
+			useFetcher(t, fetcher)
+			pd, md := newTestImport(t, map[string][]byte{".pkg.metadata": []byte(metadata)})
+			pd.AllowDuplicates = tt.allowDuplicates
+
+			err := (&GitMode{}).WriteSource(context.Background(), pd, md)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if len(fetcher.fetched) != 0 {
+					t.Errorf("expected no downloads, got %v", fetcher.fetched)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := readWorktreeFile(t, md, "SOURCES/a.tar.gz"); string(got) != string(tt.written) {
+				t.Errorf("expected %q to be written, got %q", tt.written, got)
+			}
+		})
+	}
+}
+
+// newListRemote returns a repository with a remote named upstream. If
+// withBranch is set the remote is a local repository with a single
+// branch and no import tags, otherwise listing it fails.
+func newListRemote(t *testing.T, withBranch bool) (*git.Repository, *git.Remote) {
+	url := "file://" + t.TempDir() + "/missing"
+	if withBranch {
+		dir := t.TempDir()
+		upstream, err := git.PlainInit(dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := upstream.Worktree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Commit("initial", &git.CommitOptions{
+			Author: &object.Signature{Name: "a", Email: "a@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		url = "file://" + dir
+	}
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{Name: "upstream", URLs: []string{url}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo, remote
+}
+
+func TestScanTagsList(t *testing.T) {
+	tests := []struct {
+		name       string
+		withBranch bool
+		annotated  bool
+		listErr    bool
+		branches   []string
+	}{
+		{
+			name:       "no import tags",
+			withBranch: true,
+		},
+		{
+			name:    "list failed",
+			listErr: true,
+		},
+		{
+			name:      "list failed with annotated tags",
+			annotated: true,
+			listErr:   true,
+			branches:  []string{"c8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, remote := newListRemote(t, tt.withBranch)
+			if tt.annotated {
+				emptyTree := storeObject(t, repo, &object.Tree{})
+				signature := object.Signature{Name: "a", Email: "a@example.com", When: time.Now()}
+				commit := storeObject(t, repo, &object.Commit{
+					Author:    signature,
+					Committer: signature,
+					Message:   "import",
+					TreeHash:  emptyTree,
+				})
+				tag := storeObject(t, repo, &object.Tag{
+					Name:       "imports/c8/pkg-1.0-1.el8",
+					Tagger:     signature,
+					Message:    "import",
+					TargetType: plumbing.CommitObject,
+					Target:     commit,
+				})
+				err := repo.Storer.SetReference(plumbing.NewHashReference("refs/tags/imports/c8/pkg-1.0-1.el8", tag))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			pd := &data.ProcessData{
+				Log:                data.NewLogger(ioutil.Discard, data.LevelInfo),
+				RpmLocation:        "pkg",
+				ImportBranchPrefix: "c",
+				Version:            8,
+			}
+
+			latestTags := map[string]*remoteTarget{}
+			listErr, err := scanTags(context.Background(), pd, repo, remote, "upstream", latestTags)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.listErr && !errors.Is(listErr, data.ErrListFailed) {
+				t.Errorf("expected a list error, got %v", listErr)
+			}
+			if !tt.listErr && listErr != nil {
+				t.Errorf("expected no list error, got %v", listErr)
+			}
+
+			var branches []string
+			for branch := range latestTags {
+				branches = append(branches, branch)
+			}
+			if !reflect.DeepEqual(branches, tt.branches) {
+				t.Errorf("expected branches %v, got %v", tt.branches, branches)
+			}
+		})
+	}
+}
+
+func TestCheckoutSourceMalformedRef(t *testing.T) {
+	url := newUpstreamRepo(t, []string{"c9s"}, 1, 16)
+	upstream, err := git.PlainOpen(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := upstream.Reference(plumbing.NewBranchReferenceName("c9s"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the branch is only advertised under a name that is not normalized
+	err = upstream.Storer.SetReference(plumbing.NewHashReference("refs/Heads/c9s", head.Hash()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = upstream.Storer.RemoveReference(head.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pd := &data.ProcessData{Log: data.NewLogger(ioutil.Discard, data.LevelInfo)}
+	repo, _, err := fetchRemote(context.Background(), pd, "upstream", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := &data.ModeData{Repo: repo, Worktree: w, TagBranch: "refs/Heads/c9s"}
+
+	branchName, err := checkoutSource(context.Background(), pd, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branchName != "c9s" {
+		t.Errorf("expected branch c9s, got %s", branchName)
+	}
+	ref, err := repo.Reference("refs/heads/c9s", true)
+	if err != nil {
+		t.Fatalf("normalized ref was not fetched: %v", err)
+	}
+	if ref.Hash() != head.Hash() {
+		t.Errorf("expected %s, got %s", head.Hash(), ref.Hash())
+	}
+}
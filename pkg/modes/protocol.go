@@ -0,0 +1,124 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// fetchProtocolV2 fetches refspecs from url with the git binary,
+// as go-git only speaks protocol v0/v1, and copies the objects and refs
+// into repo. Tags are only fetched through refspecs, like the go-git
+// fetch. git itself negotiates down to v0 if the server does not
+// support v2. The fetch is anonymous, any error is returned so the
+// caller can fall back to go-git.
+func fetchProtocolV2(ctx context.Context, pd *data.ProcessData, repo *git.Repository, url string, refspecs []config.RefSpec) error {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("could not find git: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "srpmproc-fetch-")
+	if err != nil {
+		return fmt.Errorf("could not create fetch directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = runGit(ctx, gitPath, "init", "--bare", "--quiet", dir)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-c", "protocol.version=2",
+		"-c", "http.userAgent=" + pd.HTTPUserAgent(),
+		// keep small fetches packed as well
+		"-c", "fetch.unpackLimit=1",
+		"--git-dir", dir,
+		"fetch", "--quiet", "--force", "--no-tags", url,
+	}
+	for _, refspec := range refspecs {
+		args = append(args, refspec.String())
+	}
+	err = runGit(ctx, gitPath, args...)
+	if err != nil {
+		return err
+	}
+
+	fetched, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("could not open fetched repo: %v", err)
+	}
+
+	objects, err := fetched.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return fmt.Errorf("could not read fetched objects: %v", err)
+	}
+	err = objects.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := repo.Storer.SetEncodedObject(obj)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy fetched objects: %v", err)
+	}
+
+	refs, err := fetched.References()
+	if err != nil {
+		return fmt.Errorf("could not read fetched refs: %v", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || ref.Name() == plumbing.HEAD {
+			return nil
+		}
+		return repo.Storer.SetReference(ref)
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy fetched refs: %v", err)
+	}
+
+	return nil
+}
+
+func runGit(ctx context.Context, gitPath string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, gitPath, args...)
+	cmd.Stderr = &stderr
+	// never block on a credential prompt
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("git failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
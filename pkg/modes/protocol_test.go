@@ -0,0 +1,211 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// newUpstreamRepo creates a repository in a temporary directory with
+// commits on each of branches and an import tag of every branch, and
+// returns its file url
+func newUpstreamRepo(tb testing.TB, branches []string, commits int, fileSize int) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		tb.Skip("git is not installed")
+	}
+
+	dir := tb.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	signature := &object.Signature{Name: "a", Email: "a@example.com", When: time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)}
+	// the branches are created from an initial commit
+	_, err = w.Commit("initial", &git.CommitOptions{Author: signature})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("x"), fileSize)
+	for _, branch := range branches {
+		err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: true, Force: true})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		var head plumbing.Hash
+		for i := 0; i < commits; i++ {
+			path := fmt.Sprintf("SPECS/%s-%d.spec", branch, i)
+			err = util.WriteFile(w.Filesystem, path, append(content, []byte(path)...), 0644)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			_, err = w.Add(path)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			head, err = w.Commit(path, &git.CommitOptions{Author: signature})
+			if err != nil {
+				tb.Fatal(err)
+			}
+		}
+		_, err = repo.CreateTag(fmt.Sprintf("imports/%s/pkg-1.0-1", branch), head, &git.CreateTagOptions{Tagger: signature, Message: "import"})
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return "file://" + dir
+}
+
+func TestFetchRemoteProtocolV2(t *testing.T) {
+	url := newUpstreamRepo(t, []string{"c8", "c9"}, 2, 16)
+	upstream, err := git.PlainOpen(strings.TrimPrefix(url, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := upstream.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tags that can't be import tags are not fetched
+	_, err = upstream.CreateTag("release-1", head.Hash(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		v2       bool
+		fallback bool
+		err      error
+	}{
+		{
+			name: "go-git",
+			url:  url,
+		},
+		{
+			name: "protocol v2",
+			url:  url,
+			v2:   true,
+		},
+		{
+			name:     "fallback to go-git",
+			url:      url + "/missing",
+			v2:       true,
+			fallback: true,
+			err:      data.ErrFetchFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			pd := &data.ProcessData{
+				Log:                data.NewLogger(&logs, data.LevelInfo),
+				GitProtocolV2:      tt.v2,
+				ImportBranchPrefix: "c",
+			}
+
+			repo, _, err := fetchRemote(context.Background(), pd, "upstream", tt.url)
+			if fallback := strings.Contains(logs.String(), "protocol v2 fetch failed"); fallback != tt.fallback {
+				t.Errorf("expected fallback %v, got %v: %s", tt.fallback, fallback, logs.String())
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, name := range []string{"refs/remotes/c8", "refs/remotes/c9", "refs/tags/imports/c8/pkg-1.0-1", "refs/tags/imports/c9/pkg-1.0-1"} {
+				ref, err := repo.Reference(plumbing.ReferenceName(name), true)
+				if err != nil {
+					t.Errorf("%s was not fetched: %v", name, err)
+					continue
+				}
+				hash := ref.Hash()
+				if tag, err := repo.TagObject(hash); err == nil {
+					hash = tag.Target
+				}
+				if _, err := repo.CommitObject(hash); err != nil {
+					t.Errorf("commit of %s was not fetched: %v", name, err)
+				}
+			}
+			if _, err := repo.Reference("refs/tags/release-1", true); err == nil {
+				t.Errorf("refs/tags/release-1 was fetched")
+			}
+		})
+	}
+}
+
+// BenchmarkFetchRemote compares a go-git fetch with a protocol v2 fetch
+// of a local repository with many branches, of which only one is fetched
+func BenchmarkFetchRemote(b *testing.B) {
+	var branches []string
+	for i := 0; i < 20; i++ {
+		branches = append(branches, fmt.Sprintf("c8-stream-%d", i))
+	}
+	url := newUpstreamRepo(b, branches, 10, 64*1024)
+
+	for _, v2 := range []bool{false, true} {
+		name := "go-git"
+		if v2 {
+			name = "protocol v2"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			pd := &data.ProcessData{
+				Log:           data.NewLogger(ioutil.Discard, data.LevelInfo),
+				GitProtocolV2: v2,
+				FetchRefSpecs: []config.RefSpec{"+refs/heads/c8-stream-0:refs/remotes/c8-stream-0"},
+			}
+			for i := 0; i < b.N; i++ {
+				_, _, err := fetchRemote(context.Background(), pd, "upstream", url)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
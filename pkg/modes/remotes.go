@@ -0,0 +1,100 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// extraRemote is a fetched ExtraRemotes entry
+type extraRemote struct {
+	name   string
+	repo   *git.Repository
+	remote *git.Remote
+}
+
+// fetchExtraRemotes fetches every ExtraRemotes entry in parallel, each
+// into its own repository so its tags can be told apart from upstream
+func fetchExtraRemotes(ctx context.Context, pd *data.ProcessData) ([]*extraRemote, error) {
+	extras := make([]*extraRemote, len(pd.ExtraRemotes))
+	errs := make([]error, len(pd.ExtraRemotes))
+
+	var wg sync.WaitGroup
+	for i, upstream := range pd.ExtraRemotes {
+		wg.Add(1)
+		go func(i int, upstream data.UpstreamRemote) {
+			defer wg.Done()
+			pd.Log.Info("fetching remote", "remote", upstream.Name, "url", upstream.Url)
+			repo, remote, err := fetchRemote(ctx, pd, upstream.Name, upstream.Url)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			extras[i] = &extraRemote{
+				name:   upstream.Name,
+				repo:   repo,
+				remote: remote,
+			}
+		}(i, upstream)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return extras, nil
+}
+
+// remoteRank returns the position of the remote name in RemotePrecedence,
+// remotes not listed rank after all listed ones
+func remoteRank(pd *data.ProcessData, name string) int {
+	for i, remote := range pd.RemotePrecedence {
+		if remote == name {
+			return i
+		}
+	}
+
+	return len(pd.RemotePrecedence)
+}
+
+// preferredTarget reports whether candidate replaces existing as the tag
+// of a branch. A remote ranked higher in RemotePrecedence wins, between
+// equally ranked remotes the newer tag does.
+func preferredTarget(pd *data.ProcessData, candidate *remoteTarget, existing *remoteTarget) bool {
+	if existing == nil {
+		return true
+	}
+
+	candidateRank := remoteRank(pd, candidate.source)
+	existingRank := remoteRank(pd, existing.source)
+	if candidateRank != existingRank {
+		return candidateRank < existingRank
+	}
+
+	return !existing.when.After(candidate.when)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	"golang.org/x/crypto/openpgp"
+)
+
+// signatureSuffixes are the extensions of detached signatures
+var signatureSuffixes = []string{".sig", ".sign", ".asc"}
+
+// verifySignatures verifies every source that has a detached signature
+// among the sources in written, which maps metadata paths to the paths
+// the sources were written to. Nothing is verified without a keyring.
+func verifySignatures(pd *data.ProcessData, fs billy.Filesystem, written map[string]string) error {
+	if pd.SourceKeyring == nil {
+		return nil
+	}
+
+	var signatures []string
+	for path := range written {
+		signatures = append(signatures, path)
+	}
+	sort.Strings(signatures)
+
+	for _, signaturePath := range signatures {
+		signedPath := signedSource(signaturePath)
+		if signedPath == "" {
+			continue
+		}
+		signedTarget, ok := written[signedPath]
+		if !ok {
+			continue
+		}
+
+		err := verifySignature(pd.SourceKeyring, fs, signedTarget, written[signaturePath])
+		if err != nil {
+			if !pd.SignatureWarnOnly {
+				return err
+			}
+			pd.Log.Warn("bad source signature", "error", err)
+			continue
+		}
+		pd.Log.Info("verified source signature", "path", signedTarget)
+	}
+
+	return nil
+}
+
+// signedSource returns the path of the source signed by the detached
+// signature at path, or an empty string if path is no signature
+func signedSource(path string) string {
+	for _, suffix := range signatureSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return strings.TrimSuffix(path, suffix)
+		}
+	}
+
+	return ""
+}
+
+func verifySignature(keyring openpgp.EntityList, fs billy.Filesystem, signedPath string, signaturePath string) error {
+	signed, err := readFile(fs, signedPath)
+	if err != nil {
+		return err
+	}
+	signature, err := readFile(fs, signaturePath)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(signature))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(signature))
+	}
+	if err != nil {
+		return fmt.Errorf("bad signature %s for %s: %v", signaturePath, signedPath, err)
+	}
+
+	return nil
+}
+
+func readFile(fs billy.Filesystem, path string) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	return content, nil
+}
@@ -0,0 +1,297 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// A snapshot is a gzipped tarball holding the fetched upstream repository
+// under repo/ (in the layout of a bare repository) and the lookaside blobs
+// under blobs/<hash>. It lets an import run without any network access.
+const (
+	snapshotRepoDir  = "repo"
+	snapshotBlobsDir = "blobs"
+)
+
+// openSnapshot loads the snapshot at path into memory and returns the
+// repository it contains together with the filesystem holding its blobs
+func openSnapshot(path string) (*git.Repository, billy.Filesystem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open snapshot: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read snapshot: %v", err)
+	}
+
+	fs := memfs.New()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read snapshot: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name, err := data.SanitizeSourcePath(hdr.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid entry in snapshot: %v", err)
+		}
+		out, err := fs.Create(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not create %s: %v", name, err)
+		}
+		_, err = io.Copy(out, tr)
+		_ = out.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not extract %s: %v", name, err)
+		}
+	}
+
+	repoFs, err := fs.Chroot(snapshotRepoDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	blobFs, err := fs.Chroot(snapshotBlobsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := git.Open(filesystem.NewStorage(repoFs, cache.NewObjectLRUDefault()), memfs.New())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open snapshot repo: %v", err)
+	}
+
+	return repo, blobFs, nil
+}
+
+// readSnapshotBlob returns the blob for hash from the snapshot,
+// or nil if there is no snapshot or it does not contain the blob
+func readSnapshotBlob(md *data.ModeData, hash string) ([]byte, error) {
+	if md.SnapshotBlobs == nil {
+		return nil, nil
+	}
+
+	f, err := md.SnapshotBlobs.Open(hash)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not open snapshot blob %s: %v", hash, err)
+	}
+	defer f.Close()
+
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot blob %s: %v", hash, err)
+	}
+
+	return body, nil
+}
+
+// snapshotRefs lists the references of a snapshot repository,
+// standing in for listing the upstream remote
+func snapshotRefs(repo *git.Repository) ([]*plumbing.Reference, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshot references: %v", err)
+	}
+
+	var refs []*plumbing.Reference
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list snapshot references: %v", err)
+	}
+
+	return refs, nil
+}
+
+// ensureSnapshotRef makes sure tagBranch can be checked out from a snapshot.
+// Upstream branches are stored as refs/remotes/<branch> by RetrieveSource,
+// so refs/heads/<branch> is created from there if missing.
+func ensureSnapshotRef(repo *git.Repository, tagBranch string) error {
+	name := plumbing.ReferenceName(tagBranch)
+	_, err := repo.Reference(name, true)
+	if err == nil || !strings.HasPrefix(tagBranch, "refs/heads/") {
+		return nil
+	}
+
+	remoteRef, err := repo.Reference(plumbing.ReferenceName("refs/remotes/"+strings.TrimPrefix(tagBranch, "refs/heads/")), true)
+	if err != nil {
+		return fmt.Errorf("could not find %s in snapshot: %v", tagBranch, err)
+	}
+
+	return repo.Storer.SetReference(plumbing.NewHashReference(name, remoteRef.Hash()))
+}
+
+// ExportSnapshot writes a snapshot of the upstream repository in md.Repo and
+// every blob in md.ResolvedBlobs to w. It should be called after all branches
+// have been written, so the snapshot contains every blob an offline import
+// will need. Blobs are taken from md.BlobCache or else from blob storage,
+// the export fails if a blob is in neither.
+func ExportSnapshot(ctx context.Context, pd *data.ProcessData, md *data.ModeData, w io.Writer) error {
+	fs := memfs.New()
+	repoFs, err := fs.Chroot(snapshotRepoDir)
+	if err != nil {
+		return err
+	}
+	storage := filesystem.NewStorage(repoFs, cache.NewObjectLRUDefault())
+
+	objects, err := md.Repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return fmt.Errorf("could not iterate objects: %v", err)
+	}
+	err = objects.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := storage.SetEncodedObject(obj)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy objects: %v", err)
+	}
+
+	refs, err := md.Repo.Storer.IterReferences()
+	if err != nil {
+		return fmt.Errorf("could not iterate references: %v", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		return storage.SetReference(ref)
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy references: %v", err)
+	}
+	if _, err := storage.Reference(plumbing.HEAD); err != nil {
+		err := storage.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+		if err != nil {
+			return fmt.Errorf("could not set HEAD: %v", err)
+		}
+	}
+
+	err = fs.MkdirAll(snapshotBlobsDir, 0755)
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(md.ResolvedBlobs))
+	for hash := range md.ResolvedBlobs {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		body := md.BlobCache.Get(hash)
+		if body == nil {
+			body, err = pd.ReadBlob(ctx, md.ResolvedBlobs[hash])
+			if err != nil {
+				return fmt.Errorf("could not read blob %s: %v", hash, err)
+			}
+		}
+		if body == nil {
+			return &data.BlobNotFoundError{Hash: hash, Err: fmt.Errorf("neither in the blob cache nor in blob storage")}
+		}
+
+		err := data.WriteFileAtomic(fs, filepath.Join(snapshotBlobsDir, hash), body, 0644)
+		if err != nil {
+			return fmt.Errorf("could not write blob %s: %v", hash, err)
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = tarFs(tw, fs, ".")
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close snapshot tar: %v", err)
+	}
+
+	return gz.Close()
+}
+
+func tarFs(tw *tar.Writer, fs billy.Filesystem, dir string) error {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read dir: %v", err)
+	}
+
+	for _, fi := range infos {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() {
+			err := tarFs(tw, fs, path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := tw.WriteHeader(&tar.Header{
+			Name:     path,
+			Mode:     0644,
+			Size:     fi.Size(),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return fmt.Errorf("could not write tar header for %s: %v", path, err)
+		}
+
+		f, err := fs.Open(path)
+		if err != nil {
+			return fmt.Errorf("could not open %s: %v", path, err)
+		}
+		_, err = io.Copy(tw, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("could not write %s to snapshot: %v", path, err)
+		}
+	}
+
+	return nil
+}
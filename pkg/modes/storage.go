@@ -0,0 +1,121 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// openRemoteRepo returns the repository to fetch url into as the remote
+// name. Without UpstreamCacheDir it is a fresh in-memory repository.
+// Otherwise it is the one cached on disk for url, created on first use.
+// A cached repository has to have been fetched from url before and is
+// reset to a clean state, so only the fetched refs and objects remain.
+func openRemoteRepo(pd *data.ProcessData, name string, url string) (*git.Repository, error) {
+	if pd.UpstreamCacheDir == "" {
+		repo, err := git.Init(memory.NewStorage(), memfs.New())
+		if err != nil {
+			return nil, fmt.Errorf("could not init git Repo: %v", err)
+		}
+		return repo, nil
+	}
+
+	sum := sha256.Sum256([]byte(url))
+	dir := filepath.Join(pd.UpstreamCacheDir, fmt.Sprintf("%s-%x.git", data.PackageName(url), sum[:6]))
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+
+	repo, err := git.Open(storage, memfs.New())
+	if err == git.ErrRepositoryNotExists {
+		pd.Log.Info("caching upstream repository", "url", url, "dir", dir)
+		repo, err = git.Init(storage, memfs.New())
+		if err != nil {
+			return nil, fmt.Errorf("could not init cached repo %s: %v", dir, err)
+		}
+		return repo, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open cached repo %s: %v", dir, err)
+	}
+
+	remote, err := repo.Remote(name)
+	if err != nil {
+		return nil, fmt.Errorf("cached repo %s has no remote %s: %v", dir, name, err)
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != url {
+		return nil, fmt.Errorf("cached repo %s was fetched from %s, not %s", dir, strings.Join(urls, ", "), url)
+	}
+
+	pd.Log.Info("reusing cached upstream repository", "dir", dir)
+	err = resetCachedRepo(repo)
+	if err != nil {
+		return nil, fmt.Errorf("could not reset cached repo %s: %v", dir, err)
+	}
+
+	// recreated by the caller with the current refspecs
+	err = repo.DeleteRemote(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not reset remote of cached repo %s: %v", dir, err)
+	}
+
+	return repo, nil
+}
+
+// resetCachedRepo drops everything a previous run left behind except the
+// remote tracking refs, which keep the next fetch incremental. Tags are
+// fetched again, checkouts of a previous run may have moved them.
+func resetCachedRepo(repo *git.Repository) error {
+	refs, err := repo.References()
+	if err != nil {
+		return err
+	}
+
+	var stale []plumbing.ReferenceName
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD && !ref.Name().IsRemote() {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		err := repo.Storer.RemoveReference(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return repo.Storer.SetIndex(&index.Index{Version: 2})
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package modes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	"github.com/rocky-linux/srpmproc/pkg/misc"
+)
+
+// TagIterator returns an iterator over the upstream tags matching the
+// import pattern (or the importable heads in tagless mode). Annotated
+// tags are yielded first, the remote is only listed once they run out.
+func (g *GitMode) TagIterator(ctx context.Context, pd *data.ProcessData) (data.TagIter, error) {
+	repo, remote, _, err := openUpstream(ctx, pd)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTagIter(ctx, pd, repo, remote)
+}
+
+type gitTagIter struct {
+	// ctx bounds the rate limited wait before listing the remote
+	ctx    context.Context
+	pd     *data.ProcessData
+	repo   *git.Repository
+	remote *git.Remote
+
+	tags   *object.TagIter
+	list   []*plumbing.Reference
+	listed bool
+}
+
+func newTagIter(ctx context.Context, pd *data.ProcessData, repo *git.Repository, remote *git.Remote) (*gitTagIter, error) {
+	tags, err := repo.TagObjects()
+	if err != nil {
+		return nil, fmt.Errorf("could not get tag objects: %v", err)
+	}
+
+	return &gitTagIter{
+		ctx:    ctx,
+		pd:     pd,
+		repo:   repo,
+		remote: remote,
+		tags:   tags,
+	}, nil
+}
+
+func (it *gitTagIter) Next() (*data.ImportTag, error) {
+	for it.tags != nil {
+		tag, err := it.tags.Next()
+		if err != nil {
+			if err != io.EOF {
+				it.pd.Log.Warn("could not read tag objects", "error", err)
+			}
+			it.tags.Close()
+			it.tags = nil
+			break
+		}
+		if importTag := it.match(tag); importTag != nil {
+			return importTag, nil
+		}
+	}
+
+	if !it.listed {
+		it.listed = true
+		err := it.listRefs()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for len(it.list) > 0 {
+		ref := it.list[0]
+		it.list = it.list[1:]
+		if ref.Hash().IsZero() {
+			continue
+		}
+
+		commit, err := it.repo.CommitObject(ref.Hash())
+		if err != nil {
+			if !importableRef(it.pd, it.repo, ref) {
+				continue
+			}
+			if it.pd.StrictTagResolution {
+				return nil, fmt.Errorf("could not resolve commit of %s: %v", ref.Name(), err)
+			}
+			it.pd.Log.Warn("skipping ref, could not resolve its commit", "ref", ref.Name(), "error", err)
+			continue
+		}
+
+		name := misc.NormalizeRef(string(ref.Name()))
+		if !it.pd.TaglessMode {
+			name = strings.TrimPrefix(name, "refs/tags/")
+		}
+		importTag := it.match(&object.Tag{
+			Name:   name,
+			Tagger: commit.Committer,
+			Target: commit.Hash,
+		})
+		if importTag != nil {
+			return importTag, nil
+		}
+	}
+
+	return nil, io.EOF
+}
+
+func (it *gitTagIter) Close() {
+	if it.tags != nil {
+		it.tags.Close()
+		it.tags = nil
+	}
+	it.list = nil
+	it.listed = true
+}
+
+// match returns the import tag for tag or nil if it should not be imported
+func (it *gitTagIter) match(tag *object.Tag) *data.ImportTag {
+	// In case of "tagless mode", we need to get the head ref of the branch instead
+	if it.pd.TaglessMode {
+		if !misc.TaglessRefOk(tag.Name, it.pd) {
+			return nil
+		}
+
+		// the branch name is always last
+		// (ex: "refs/heads/c9s" ---> c9s)
+		ref := misc.NormalizeRef(tag.Name)
+		tmpRef := strings.Split(ref, "/")
+		return &data.ImportTag{
+			Ref:    ref,
+			Branch: tmpRef[len(tmpRef)-1],
+			Commit: tag.Target,
+			When:   tagTime(it.repo, tag),
+			Tagger: tag.Tagger,
+		}
+	}
+
+	refSpec := misc.NormalizeRef(fmt.Sprintf("refs/tags/%s", tag.Name))
+	match := misc.MatchImportTag(it.pd, refSpec)
+	if match == nil {
+		return nil
+	}
+
+	return &data.ImportTag{
+		Ref:    refSpec,
+		Branch: match[2],
+		Commit: tag.Target,
+		When:   tagTime(it.repo, tag),
+		Tagger: tag.Tagger,
+	}
+}
+
+// listRefs lists the refs of the remote, or of the snapshot if there is
+// no remote. A failed list is returned as a *data.ListError, the
+// annotated tags yielded before are still importable and the caller
+// decides whether that is enough
+func (it *gitTagIter) listRefs() error {
+	if it.remote == nil {
+		list, err := snapshotRefs(it.repo)
+		if err != nil {
+			return err
+		}
+		it.list = list
+		return nil
+	}
+
+	listOpts := &git.ListOptions{
+		Auth: it.pd.Authenticator,
+	}
+	url := it.remote.Config().URLs[0]
+	host := data.HostOf(url)
+	err := it.pd.HostLimiter.Wait(it.ctx, host)
+	if err != nil {
+		return err
+	}
+	list, err := it.remote.List(listOpts)
+	if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
+		listOpts.Auth = nil
+		err = it.pd.HostLimiter.Wait(it.ctx, host)
+		if err != nil {
+			return err
+		}
+		list, err = it.remote.List(listOpts)
+	}
+	if err != nil {
+		return &data.ListError{Url: url, Err: err}
+	}
+
+	it.list = list
+	return nil
+}
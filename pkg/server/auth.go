@@ -0,0 +1,61 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized is returned for requests without a valid
+// bearer token or verified client certificate
+var ErrUnauthorized = errors.New("unauthorized")
+
+// authorize checks the Authorization header of a request against
+// the token of the server. Clients that presented a certificate
+// verified against the client CA of the listener are authorized
+// without a token. If neither is configured, nothing is authorized.
+func (s *Server) authorize(header string, state *tls.ConnectionState) error {
+	if state != nil && len(state.VerifiedChains) > 0 {
+		return nil
+	}
+	if s.Token == "" {
+		return ErrUnauthorized
+	}
+
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ErrUnauthorized
+	}
+	token := strings.TrimSpace(header[len(prefix):])
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+func (s *Server) authorizeHTTP(r *http.Request) error {
+	return s.authorize(r.Header.Get("Authorization"), r.TLS)
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var jobStatuses = map[JobStatus]srpmprocpb.ImportStatus{
+	JobQueued:    srpmprocpb.ImportStatus_IMPORT_STATUS_QUEUED,
+	JobRunning:   srpmprocpb.ImportStatus_IMPORT_STATUS_RUNNING,
+	JobSucceeded: srpmprocpb.ImportStatus_IMPORT_STATUS_SUCCEEDED,
+	JobFailed:    srpmprocpb.ImportStatus_IMPORT_STATUS_FAILED,
+}
+
+// importService implements the gRPC ImportService with the jobs of a server
+type importService struct {
+	srpmprocpb.UnimplementedImportServiceServer
+	s *Server
+}
+
+// ImportService returns the gRPC ImportService backed by s. Imports
+// requested through it share the queue and the jobs of the HTTP API.
+func (s *Server) ImportService() srpmprocpb.ImportServiceServer {
+	return &importService{s: s}
+}
+
+// UnaryAuthInterceptor rejects unary calls that don't pass the token
+// check of the HTTP API, with the token sent as authorization metadata
+func (s *Server) UnaryAuthInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.authorizeGRPC(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor is the UnaryAuthInterceptor of streaming calls
+func (s *Server) StreamAuthInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.authorizeGRPC(stream.Context()); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func (s *Server) authorizeGRPC(ctx context.Context) error {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+
+	if err := s.authorize(header, state); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+func (i *importService) ImportPackage(_ context.Context, req *srpmprocpb.ImportPackageRequest) (*srpmprocpb.ImportJob, error) {
+	job, err := i.s.Submit(ImportRequest{
+		Package: req.Package,
+		Version: int(req.Version),
+		Branch:  req.Branch,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidRequest):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, ErrQueueFull):
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, ErrStopped):
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return jobProto(job), nil
+}
+
+func (i *importService) GetImportStatus(_ context.Context, req *srpmprocpb.GetImportStatusRequest) (*srpmprocpb.ImportJob, error) {
+	job, ok := i.s.Lookup(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no job %s", req.Id)
+	}
+
+	return jobProto(job), nil
+}
+
+func (i *importService) StreamLogs(req *srpmprocpb.StreamLogsRequest, stream srpmprocpb.ImportService_StreamLogsServer) error {
+	if _, ok := i.s.Lookup(req.Id); !ok {
+		return status.Errorf(codes.NotFound, "no job %s", req.Id)
+	}
+
+	return i.s.FollowLog(stream.Context(), req.Id, func(line string) error {
+		return stream.Send(&srpmprocpb.LogLine{Line: line})
+	})
+}
+
+func jobProto(job Job) *srpmprocpb.ImportJob {
+	res := &srpmprocpb.ImportJob{
+		Id: job.ID,
+		Request: &srpmprocpb.ImportPackageRequest{
+			Package: job.Request.Package,
+			Version: int32(job.Request.Version),
+			Branch:  job.Request.Branch,
+		},
+		Status:   jobStatuses[job.Status],
+		Error:    job.Error,
+		Created:  timestamp(&job.Created),
+		Started:  timestamp(job.Started),
+		Finished: timestamp(job.Finished),
+	}
+	if job.Result != nil {
+		res.Response = job.Result.Response
+	}
+
+	return res
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
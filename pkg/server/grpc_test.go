@@ -0,0 +1,97 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/rocky-linux/srpmproc/pkg/srpmproc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryAuthInterceptor(t *testing.T) {
+	verified := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}},
+	})
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		token string
+		code  codes.Code
+	}{
+		{
+			name:  "no metadata",
+			ctx:   context.Background(),
+			token: "secret",
+			code:  codes.Unauthenticated,
+		},
+		{
+			name:  "wrong token",
+			ctx:   metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer other")),
+			token: "secret",
+			code:  codes.Unauthenticated,
+		},
+		{
+			name:  "valid token",
+			ctx:   metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret")),
+			token: "secret",
+			code:  codes.OK,
+		},
+		{
+			name: "no token configured",
+			ctx:  metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer ")),
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "verified client certificate",
+			ctx:  verified,
+			code: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(&srpmproc.ProcessDataRequest{}, 1)
+			s.Token = tt.token
+
+			called := false
+			_, err := s.UnaryAuthInterceptor()(tt.ctx, nil, &grpc.UnaryServerInfo{}, func(context.Context, interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, err)
+			}
+			if called != (tt.code == codes.OK) {
+				t.Errorf("expected the handler to be called only when authorized, called %v", called)
+			}
+		})
+	}
+}
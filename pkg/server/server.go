@@ -0,0 +1,545 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package server runs srpmproc imports requested over HTTP
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	"github.com/rocky-linux/srpmproc/pkg/srpmproc"
+)
+
+var (
+	// ErrInvalidRequest is wrapped by the errors of invalid import requests
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrQueueFull is returned when an import can't be queued
+	ErrQueueFull = errors.New("import queue is full")
+	// ErrStopped is returned for imports submitted after Run returned
+	ErrStopped = errors.New("server is shutting down")
+)
+
+// ImportRequest is the body of POST /import
+type ImportRequest struct {
+	// Package is the name or location of the package to import
+	Package string `json:"package"`
+	// Version overrides the upstream version of the server
+	Version int `json:"version,omitempty"`
+	// Branch restricts the import to a single upstream branch,
+	// like c8 or c8s for version 8
+	Branch string `json:"branch,omitempty"`
+}
+
+// JobStatus is the state of an import job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+const (
+	// DefaultMaxJobs is the number of jobs kept by a new server
+	DefaultMaxJobs = 1000
+	// DefaultJobTTL is how long a new server keeps finished jobs
+	DefaultJobTTL = 24 * time.Hour
+	// DefaultMaxLogLines is the number of log lines kept per job by a new server
+	DefaultMaxLogLines = 10000
+
+	// maxLogLineBytes splits lines longer than this in the job log
+	maxLogLineBytes = 64 * 1024
+)
+
+// Job is an import requested through the server
+type Job struct {
+	ID       string           `json:"id"`
+	Request  ImportRequest    `json:"request"`
+	Status   JobStatus        `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	Result   *srpmproc.Result `json:"result,omitempty"`
+	Created  time.Time        `json:"created"`
+	Started  *time.Time       `json:"started,omitempty"`
+	Finished *time.Time       `json:"finished,omitempty"`
+
+	log *jobLog
+}
+
+// Server queues the imports requested with POST /import and runs them
+// with a pool of workers. GET /jobs lists the jobs and GET /jobs/<id>
+// returns a single one. Jobs and their logs are only kept in memory,
+// finished jobs are dropped after JobTTL or beyond MaxJobs.
+// Every request needs the bearer token of the server or a client
+// certificate verified by the TLS listener.
+type Server struct {
+	base  srpmproc.ProcessDataRequest
+	queue chan *Job
+
+	// Configure is called with the ProcessData of every import
+	// before it starts, e.g. to share an import state
+	Configure func(pd *data.ProcessData)
+
+	// Token is the bearer token requests have to present, unless
+	// they come with a verified client certificate. Without a token,
+	// only clients with a verified certificate are served.
+	Token string
+
+	// MaxJobs is the number of jobs kept, the oldest finished jobs
+	// are dropped beyond it. Queued and running jobs are always kept.
+	MaxJobs int
+	// JobTTL is how long finished jobs are kept
+	JobTTL time.Duration
+	// MaxLogLines is the number of lines kept of the log of a job,
+	// older lines are dropped first. Changing it only affects new jobs.
+	MaxLogLines int
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	ids     []string
+	stopped bool
+}
+
+// New returns a server importing packages with the options of base,
+// queueing up to queueSize imports
+func New(base *srpmproc.ProcessDataRequest, queueSize int) *Server {
+	return &Server{
+		base:        *base,
+		queue:       make(chan *Job, queueSize),
+		jobs:        map[string]*Job{},
+		MaxJobs:     DefaultMaxJobs,
+		JobTTL:      DefaultJobTTL,
+		MaxLogLines: DefaultMaxLogLines,
+	}
+}
+
+// Run imports queued jobs with workers imports running in parallel
+// until ctx is done, which aborts the imports in flight and fails
+// the jobs still queued
+func (s *Server) Run(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.queue:
+					s.run(ctx, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	for {
+		select {
+		case job := <-s.queue:
+			s.abort(job, ctx.Err())
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.authorizeHTTP(r); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="srpmproc"`)
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/import":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		s.handleImport(w, r)
+	case r.URL.Path == "/jobs" || strings.HasPrefix(r.URL.Path, "/jobs/"):
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		s.handleJobs(w, strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// Submit queues the import described by req and returns its job.
+// The error wraps ErrInvalidRequest if req is invalid and is
+// ErrQueueFull if too many imports are queued already or
+// ErrStopped once Run returned.
+func (s *Server) Submit(req ImportRequest) (Job, error) {
+	if req.Package == "" {
+		return Job{}, fmt.Errorf("%w: package is required", ErrInvalidRequest)
+	}
+	if _, err := s.request(&req); err != nil {
+		return Job{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	job := &Job{
+		ID:      newJobID(),
+		Request: req,
+		Status:  JobQueued,
+		Created: time.Now(),
+		log:     newJobLog(s.MaxLogLines),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return Job{}, ErrStopped
+	}
+	s.prune(job.Created)
+
+	select {
+	case s.queue <- job:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	s.jobs[job.ID] = job
+	s.ids = append(s.ids, job.ID)
+
+	return *job, nil
+}
+
+// Lookup returns the job with the given ID
+func (s *Server) Lookup(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Jobs returns every job in the order they were submitted
+func (s *Server) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []Job{}
+	for _, id := range s.ids {
+		jobs = append(jobs, *s.jobs[id])
+	}
+	return jobs
+}
+
+// FollowLog calls fn with every line logged by the import of job id,
+// waiting for new lines until the import finishes or ctx is done
+func (s *Server) FollowLog(ctx context.Context, id string, fn func(line string) error) error {
+	job, ok := s.Lookup(id)
+	if !ok {
+		return fmt.Errorf("no job %s", id)
+	}
+
+	next := 0
+	for {
+		lines, n, changed, closed := job.log.since(next)
+		for _, line := range lines {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		next = n
+		if closed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	var req ImportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%v: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	job, err := s.Submit(req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrStopped) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, id string) {
+	if id == "" {
+		writeJSON(w, http.StatusOK, s.Jobs())
+		return
+	}
+
+	job, ok := s.Lookup(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no job %s", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// request returns the ProcessDataRequest of an import request
+func (s *Server) request(req *ImportRequest) (*srpmproc.ProcessDataRequest, error) {
+	pdReq := s.base
+	pdReq.Package = req.Package
+	if req.Version != 0 {
+		pdReq.Version = req.Version
+	}
+
+	if req.Branch != "" {
+		// the branch is named <import branch prefix><version><suffix>
+		prefix := fmt.Sprintf("%s%d", pdReq.ImportBranchPrefix, pdReq.Version)
+		if !strings.HasPrefix(req.Branch, prefix) {
+			return nil, fmt.Errorf("branch %s is not a branch of version %d (%s...)", req.Branch, pdReq.Version, prefix)
+		}
+		pdReq.BranchSuffix = strings.TrimPrefix(req.Branch, prefix)
+		pdReq.StrictBranchMode = true
+	}
+
+	return &pdReq, nil
+}
+
+// run imports job and records the outcome
+func (s *Server) run(ctx context.Context, job *Job) {
+	s.update(job, func() {
+		now := time.Now()
+		job.Status = JobRunning
+		job.Started = &now
+	})
+
+	result, err := s.runImport(ctx, job)
+
+	s.update(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		if err != nil {
+			job.Status = JobFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = JobSucceeded
+		job.Result = result
+	})
+	job.log.close()
+}
+
+// abort fails job without running it
+func (s *Server) abort(job *Job, err error) {
+	s.update(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		job.Status = JobFailed
+		job.Error = err.Error()
+	})
+	job.log.close()
+}
+
+func (s *Server) runImport(ctx context.Context, job *Job) (*srpmproc.Result, error) {
+	req, err := s.request(&job.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	// the log of the job is kept for StreamLogs,
+	// next to the log of the server
+	var writer io.Writer = os.Stdout
+	if req.LogWriter != nil {
+		writer = req.LogWriter
+	}
+	req.LogWriter = io.MultiWriter(writer, job.log)
+
+	pd, err := srpmproc.NewProcessData(req)
+	if err != nil {
+		return nil, err
+	}
+	if s.Configure != nil {
+		s.Configure(pd)
+	}
+	pd.Log.Info("running import job", "job", job.ID)
+
+	return srpmproc.RunProcessData(ctx, pd)
+}
+
+func (s *Server) update(job *Job, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+	if job.Finished != nil {
+		s.prune(*job.Finished)
+	}
+}
+
+// prune drops finished jobs older than JobTTL and then the oldest
+// finished jobs beyond MaxJobs. s.mu must be held.
+func (s *Server) prune(now time.Time) {
+	excess := len(s.ids) - s.MaxJobs
+	if s.MaxJobs <= 0 {
+		excess = 0
+	}
+
+	ids := s.ids[:0]
+	for _, id := range s.ids {
+		job := s.jobs[id]
+		expired := s.JobTTL > 0 && job.Finished != nil && now.Sub(*job.Finished) > s.JobTTL
+		if expired || (excess > 0 && job.Finished != nil) {
+			delete(s.jobs, id)
+			excess--
+			continue
+		}
+		ids = append(ids, id)
+	}
+	// clear the dropped tail so the ids can be collected
+	for i := len(ids); i < len(s.ids); i++ {
+		s.ids[i] = ""
+	}
+	s.ids = ids
+}
+
+// jobLog keeps the last max lines logged by an import
+type jobLog struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	dropped int
+	partial []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newJobLog(max int) *jobLog {
+	return &jobLog{max: max, changed: make(chan struct{})}
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.append(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	for len(l.partial) > maxLogLineBytes {
+		l.append(string(l.partial[:maxLogLineBytes]))
+		l.partial = l.partial[maxLogLineBytes:]
+	}
+	l.notify()
+	return len(p), nil
+}
+
+// close marks the log as complete once the import finished
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.partial) > 0 {
+		l.append(string(l.partial))
+		l.partial = nil
+	}
+	l.closed = true
+	l.notify()
+}
+
+// append adds line, dropping the oldest line beyond max. l.mu must be held.
+func (l *jobLog) append(line string) {
+	l.lines = append(l.lines, line)
+	if l.max > 0 && len(l.lines) > l.max {
+		l.lines[0] = ""
+		l.lines = l.lines[1:]
+		l.dropped++
+	}
+}
+
+// since returns the lines still kept of those logged after the first
+// n ones, the number of lines logged so far, a channel closed on the
+// next change and whether the log is complete
+func (l *jobLog) since(n int) ([]string, int, <-chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start := n - l.dropped
+	if start < 0 {
+		start = 0
+	}
+	lines := append([]string{}, l.lines[start:]...)
+	return lines, l.dropped + len(l.lines), l.changed, l.closed
+}
+
+func (l *jobLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rocky-linux/srpmproc/pkg/srpmproc"
+)
+
+func TestServeHTTPAuthorization(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		verified bool
+		status   int
+	}{
+		{name: "no token configured", header: "Bearer secret", status: http.StatusUnauthorized},
+		{name: "missing header", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", header: "Bearer other", status: http.StatusUnauthorized},
+		{name: "not a bearer token", token: "secret", header: "Basic secret", status: http.StatusUnauthorized},
+		{name: "token prefix", token: "secret", header: "Bearer secre", status: http.StatusUnauthorized},
+		{name: "valid token", token: "secret", header: "Bearer secret", status: http.StatusOK},
+		{name: "lowercase scheme", token: "secret", header: "bearer secret", status: http.StatusOK},
+		{name: "verified client certificate", verified: true, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(&srpmproc.ProcessDataRequest{}, 1)
+			s.Token = tt.token
+
+			r := httptest.NewRequest(http.MethodGet, "/jobs", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.verified {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeHTTPImportUnauthorized(t *testing.T) {
+	s := New(&srpmproc.ProcessDataRequest{}, 1)
+	s.Token = "secret"
+
+	r := httptest.NewRequest(http.MethodPost, "/import", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if len(s.Jobs()) != 0 {
+		t.Errorf("expected no job to be queued, got %v", s.Jobs())
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	finished := func(ago time.Duration) *time.Time {
+		t := now.Add(-ago)
+		return &t
+	}
+
+	tests := []struct {
+		name    string
+		maxJobs int
+		ttl     time.Duration
+		jobs    []*Job
+		kept    []string
+	}{
+		{
+			name:    "within limits",
+			maxJobs: 3,
+			ttl:     time.Hour,
+			jobs:    []*Job{{ID: "a", Finished: finished(time.Minute)}, {ID: "b"}},
+			kept:    []string{"a", "b"},
+		},
+		{
+			name:    "expired",
+			maxJobs: 10,
+			ttl:     time.Hour,
+			jobs:    []*Job{{ID: "a", Finished: finished(2 * time.Hour)}, {ID: "b", Finished: finished(time.Minute)}, {ID: "c"}},
+			kept:    []string{"b", "c"},
+		},
+		{
+			name:    "oldest finished beyond max jobs",
+			maxJobs: 2,
+			jobs:    []*Job{{ID: "a"}, {ID: "b", Finished: finished(time.Minute)}, {ID: "c", Finished: finished(time.Minute)}, {ID: "d"}},
+			kept:    []string{"a", "d"},
+		},
+		{
+			name:    "running jobs are kept beyond max jobs",
+			maxJobs: 1,
+			jobs:    []*Job{{ID: "a"}, {ID: "b"}},
+			kept:    []string{"a", "b"},
+		},
+		{
+			name: "no limits",
+			jobs: []*Job{{ID: "a", Finished: finished(1000 * time.Hour)}},
+			kept: []string{"a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(&srpmproc.ProcessDataRequest{}, 1)
+			s.MaxJobs = tt.maxJobs
+			s.JobTTL = tt.ttl
+			for _, job := range tt.jobs {
+				s.jobs[job.ID] = job
+				s.ids = append(s.ids, job.ID)
+			}
+
+			s.prune(now)
+
+			var kept []string
+			for _, job := range s.Jobs() {
+				kept = append(kept, job.ID)
+			}
+			if !reflect.DeepEqual(kept, tt.kept) {
+				t.Errorf("expected %v, got %v", tt.kept, kept)
+			}
+			if len(s.jobs) != len(tt.kept) {
+				t.Errorf("expected %d jobs in the map, got %d", len(tt.kept), len(s.jobs))
+			}
+		})
+	}
+}
+
+func TestJobLogMaxLines(t *testing.T) {
+	l := newJobLog(3)
+	_, _ = l.Write([]byte("1\n2\n"))
+
+	lines, next, _, _ := l.since(0)
+	if !reflect.DeepEqual(lines, []string{"1", "2"}) || next != 2 {
+		t.Fatalf("expected [1 2] up to 2, got %v up to %d", lines, next)
+	}
+
+	_, _ = l.Write([]byte("3\n4\n5"))
+	l.close()
+
+	tests := []struct {
+		since int
+		lines []string
+	}{
+		{0, []string{"3", "4", "5"}},
+		{2, []string{"3", "4", "5"}},
+		{4, []string{"5"}},
+		{5, []string{}},
+	}
+	for _, tt := range tests {
+		lines, next, _, closed := l.since(tt.since)
+		if !reflect.DeepEqual(lines, tt.lines) || next != 5 || !closed {
+			t.Errorf("since %d: expected %v up to 5, got %v up to %d (closed %v)", tt.since, tt.lines, lines, next, closed)
+		}
+	}
+}
+
+func TestJobLogLongLine(t *testing.T) {
+	l := newJobLog(0)
+	_, _ = l.Write(bytes.Repeat([]byte("x"), 2*maxLogLineBytes+1))
+
+	lines, _, _, _ := l.since(0)
+	if len(lines) != 2 || len(l.partial) != 1 {
+		t.Errorf("expected 2 lines and 1 pending byte, got %d lines and %d bytes", len(lines), len(l.partial))
+	}
+}
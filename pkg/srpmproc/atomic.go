@@ -0,0 +1,252 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// rollbackTimeout limits the rollback of an atomic import, which
+// can't use the context of the import as it may be done already
+const rollbackTimeout = 5 * time.Minute
+
+// pendingBranch is a branch of an atomic import that was committed
+// locally but is only pushed once every branch was imported
+type pendingBranch struct {
+	md       *data.ModeData
+	repo     *git.Repository
+	newTag   string
+	refspecs []config.RefSpec
+	commit   string
+	tagHash  string
+	// previous is the commit the target branch pointed to before the
+	// import, zero if the import creates the branch
+	previous plumbing.Hash
+	// finalized is set once the branch was pushed or
+	// committed to the bare target
+	finalized bool
+	// target is the push to pd.PushTarget, if any, and
+	// targetPrevious the commit the branch pointed to there
+	target         *data.PushResult
+	targetPrevious plumbing.Hash
+}
+
+// pendingBlob is a source of an atomic import that is only
+// uploaded to blob storage once every branch was pushed
+type pendingBlob struct {
+	key     string
+	content []byte
+}
+
+// newPendingBranch buffers the import of md, committed to repo as
+// commit and tagged as newTag, until every branch is imported
+func newPendingBranch(md *data.ModeData, repo *git.Repository, newTag string, refspecs []config.RefSpec, commit string, tagHash string, previous plumbing.Hash) *pendingBranch {
+	// later branches expire the sources of md, so they are copied
+	branchMd := *md
+	branchMd.SourcesToIgnore = nil
+	for _, source := range md.SourcesToIgnore {
+		branchSource := *source
+		branchMd.SourcesToIgnore = append(branchMd.SourcesToIgnore, &branchSource)
+	}
+
+	return &pendingBranch{
+		md:       &branchMd,
+		repo:     repo,
+		newTag:   newTag,
+		refspecs: refspecs,
+		commit:   commit,
+		tagHash:  tagHash,
+		previous: previous,
+	}
+}
+
+// finalizeBranches pushes the branches buffered by an atomic import, or
+// commits them to the bare target, and pushes them to the push target.
+// If one of them fails, the branches finalized before it are rolled
+// back, so the target and the push target are left as they were.
+// The blobs are uploaded after every branch was pushed, failing to upload
+// them rolls back every branch as well. Branches are only reported and
+// checkpointed once all of them succeeded.
+func finalizeBranches(ctx context.Context, pd *data.ProcessData, pending []*pendingBranch, blobs []*pendingBlob, latestHashForBranch map[string]string) error {
+	var done []*pendingBranch
+	for _, branch := range pending {
+		err := branch.finalize(ctx, pd)
+		if err != nil {
+			// the failed branch itself may be pushed partly
+			rollbackBranches(pd, append(done, branch))
+			return err
+		}
+		done = append(done, branch)
+	}
+
+	for _, blob := range blobs {
+		err := pd.WriteBlob(ctx, blob.key, blob.content)
+		if err != nil {
+			rollbackBranches(pd, done)
+			return err
+		}
+		pd.Log.Info("wrote blob to blob storage", "hash", blob.key)
+	}
+
+	for _, branch := range pending {
+		latestHashForBranch[branch.md.PushBranch] = branch.commit
+		err := reportBranch(pd, branch.md, branch.commit, branch.newTag, branch.tagHash)
+		if err != nil {
+			return err
+		}
+
+		// RunHooks only logs failing post-push hooks, an
+		// error means the hooks could not be run at all
+		err = pushHook(ctx, pd, branch.md, data.HookPostPush, branch.commit, branch.newTag)
+		if err != nil {
+			return err
+		}
+
+		err = pd.SaveCheckpoint(branch.md.Name, branch.md.TagBranch, branch.commit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *pendingBranch) finalize(ctx context.Context, pd *data.ProcessData) error {
+	err := pushHook(ctx, pd, b.md, data.HookPrePush, b.commit, b.newTag)
+	if err != nil {
+		return err
+	}
+
+	if pd.BareTarget != nil {
+		ref, err := pd.BareTarget.Reference(plumbing.NewBranchReferenceName(b.md.PushBranch), true)
+		if err == nil {
+			b.previous = ref.Hash()
+		}
+
+		b.commit, b.tagHash, err = commitToBare(pd, b.md, b.repo, b.newTag)
+		if err != nil {
+			return err
+		}
+		b.finalized = true
+		return nil
+	}
+
+	pd.Log.Info("pushing buffered branch", "branch", b.md.PushBranch, "commit", b.commit)
+	err = b.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		Auth:       pd.Authenticator,
+		RefSpecs:   b.refspecs,
+		Force:      true,
+	})
+	if err != nil {
+		return fmt.Errorf("could not push to remote: %v", err)
+	}
+	b.finalized = true
+
+	if pd.PushTarget != nil {
+		b.targetPrevious, err = pd.BackupPushTarget(ctx, b.md)
+		if err != nil {
+			return err
+		}
+		b.target, err = pd.Push(ctx, b.md)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollbackBranches resets the target branches of done to the commit
+// they pointed to before the import and deletes their import tags,
+// in the target and the push target. Failures are only logged, as
+// the import failed already.
+func rollbackBranches(pd *data.ProcessData, done []*pendingBranch) {
+	// the import may have failed because its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	for i := len(done) - 1; i >= 0; i-- {
+		branch := done[i]
+		if !branch.finalized {
+			continue
+		}
+		pd.Log.Warn("rolling back branch", "branch", branch.md.PushBranch, "commit", branch.previous.String())
+
+		if branch.target != nil {
+			err := pd.RollbackPushTarget(ctx, branch.md, branch.target, branch.targetPrevious)
+			if err != nil {
+				pd.Log.Error("could not roll back push target", "branch", branch.md.PushBranch, "error", err)
+			}
+		}
+
+		err := branch.rollback(ctx, pd)
+		if err != nil {
+			pd.Log.Error("could not roll back branch", "branch", branch.md.PushBranch, "error", err)
+		}
+	}
+}
+
+func (b *pendingBranch) rollback(ctx context.Context, pd *data.ProcessData) error {
+	branchRef := plumbing.NewBranchReferenceName(b.md.PushBranch)
+	tagRef := plumbing.NewTagReferenceName(b.newTag)
+
+	if pd.BareTarget != nil {
+		var err error
+		if b.previous.IsZero() {
+			err = pd.BareTarget.Storer.RemoveReference(branchRef)
+		} else {
+			err = pd.BareTarget.Storer.SetReference(plumbing.NewHashReference(branchRef, b.previous))
+		}
+		if err != nil {
+			return fmt.Errorf("could not reset %s: %v", branchRef, err)
+		}
+
+		return pd.BareTarget.Storer.RemoveReference(tagRef)
+	}
+
+	refspecs := []config.RefSpec{config.RefSpec(":" + tagRef.String())}
+	if b.previous.IsZero() {
+		refspecs = append(refspecs, config.RefSpec(":"+branchRef.String()))
+	} else {
+		// the previous commit is pushed through a local ref
+		rollbackRef := plumbing.ReferenceName("refs/rollback/" + b.md.PushBranch)
+		err := b.repo.Storer.SetReference(plumbing.NewHashReference(rollbackRef, b.previous))
+		if err != nil {
+			return fmt.Errorf("could not create rollback ref: %v", err)
+		}
+		refspecs = append(refspecs, config.RefSpec("+"+rollbackRef.String()+":"+branchRef.String()))
+	}
+
+	return b.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		Auth:       pd.Authenticator,
+		RefSpecs:   refspecs,
+		Force:      true,
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rocky-linux/srpmproc/pkg/blob"
+	"github.com/rocky-linux/srpmproc/pkg/blob/file"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+func TestFinalizeBranchesUploadsBlobsAfterPush(t *testing.T) {
+	unreachable, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = unreachable.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{t.TempDir() + "/missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pending []*pendingBranch
+		wantErr bool
+	}{
+		{
+			name: "pushed",
+		},
+		{
+			name: "push failed",
+			pending: []*pendingBranch{
+				newPendingBranch(&data.ModeData{PushBranch: "r8"}, unreachable, "imports/r8/pkg-1-1.el8", []config.RefSpec{"HEAD:refs/heads/r8"}, "", "", plumbing.ZeroHash),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd := &data.ProcessData{
+				Log:         data.NewLogger(ioutil.Discard, data.LevelInfo),
+				BlobStorage: file.New(t.TempDir()),
+			}
+			blobs := []*pendingBlob{{key: "sha256/abc", content: []byte("source")}}
+
+			err := finalizeBranches(context.Background(), pd, tt.pending, blobs, map[string]string{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			content, err := blob.ReadContext(context.Background(), pd.BlobStorage, "sha256/abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uploaded := content != nil; uploaded == tt.wantErr {
+				t.Errorf("expected blob to be uploaded only after a successful push, uploaded: %v", uploaded)
+			}
+		})
+	}
+}
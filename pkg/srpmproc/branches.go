@@ -0,0 +1,305 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// BranchError records why a single branch failed in ProcessBranches
+type BranchError struct {
+	Branch string
+	Err    error
+}
+
+func (e *BranchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Branch, e.Err)
+}
+
+func (e *BranchError) Unwrap() error {
+	return e.Err
+}
+
+// BranchErrors aggregates every branch failure of a ProcessBranches run
+type BranchErrors []*BranchError
+
+func (e BranchErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return fmt.Sprintf("%d branch(es) failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// ProcessBranches runs WriteSource and PostProcess for every branch in md.Branches
+// using up to concurrency workers. Each branch gets its own copy of pd and its own
+// repository and worktree cloned from the already fetched md.Repo, while the objects
+// of md.Repo and md.BlobCache are shared between them.
+// The returned map holds the mode data of every successful branch keyed by branch.
+// Branches that a previous call completed from the same upstream commit
+// according to pd.Checkpoint are skipped, and every branch that succeeds is saved to it. As the branches
+// are not pushed, they are recorded apart from the branches ProcessRPM
+// imported and are still imported by it. The fetches and downloads of
+// every branch are cancelled once ctx is done.
+func ProcessBranches(ctx context.Context, pd *data.ProcessData, md *data.ModeData, concurrency int) (map[string]*data.ModeData, error) {
+	if pd.TaglessMode {
+		return nil, fmt.Errorf("processing branches concurrently is not supported in tagless mode")
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if md.BlobCache == nil {
+		md.BlobCache = pd.NewBlobCache()
+	}
+
+	base, err := newBranchBase(md.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := pd.ProcessedBranches(md.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var errs BranchErrors
+	results := map[string]*data.ModeData{}
+
+	branches := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for branch := range branches {
+				branchMd, err := processBranch(ctx, pd.Fork(), md, base, branch)
+				if err == nil {
+					err = pd.SaveProcessedBranch(md.Name, branch, md.BranchCommits[branch])
+				}
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, &BranchError{Branch: branch, Err: err})
+				} else {
+					results[branch] = branchMd
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, branch := range md.Branches {
+		// a branch is processed again once its upstream commit changed
+		if commit, ok := completed[branch]; ok && commit == md.BranchCommits[branch] {
+			pd.Log.Info("skipping branch, already processed according to checkpoint", "branch", branch)
+			continue
+		}
+		branches <- branch
+	}
+	close(branches)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+
+	return results, nil
+}
+
+func processBranch(ctx context.Context, pd *data.ProcessData, md *data.ModeData, base *branchBase, branch string) (*data.ModeData, error) {
+	repo, err := base.clone()
+	if err != nil {
+		return nil, err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("could not get Worktree: %v", err)
+	}
+
+	branchMd := &data.ModeData{
+		Name:          md.Name,
+		Repo:          repo,
+		Worktree:      w,
+		TagBranch:     branch,
+		Branches:      md.Branches,
+		BranchCommits: md.BranchCommits,
+		BranchTaggers: md.BranchTaggers,
+		BranchRemotes: md.BranchRemotes,
+		SnapshotBlobs: md.SnapshotBlobs,
+		Provenance:    md.Provenance,
+	}
+	branchMd.UseSharedBlobCache(md.BlobCache)
+
+	err = pd.Importer.WriteSource(ctx, pd, branchMd)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pd.Importer.PostProcess(ctx, pd, branchMd)
+	if err != nil {
+		return nil, err
+	}
+
+	return branchMd, nil
+}
+
+// branchBase is the already fetched upstream repository
+// the branches of ProcessBranches are cloned from
+type branchBase struct {
+	mu     sync.Mutex
+	repo   *git.Repository
+	config []byte
+}
+
+func newBranchBase(repo *git.Repository) (*branchBase, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, fmt.Errorf("could not get repo config: %v", err)
+	}
+	content, err := cfg.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("could not encode repo config: %v", err)
+	}
+
+	return &branchBase{repo: repo, config: content}, nil
+}
+
+// clone returns a repository with the references, remotes and objects
+// of the base repository and an empty in-memory worktree. Objects are
+// read from the base storage, new objects are kept in memory.
+func (b *branchBase) clone() (*git.Repository, error) {
+	storage := &branchStorage{Storage: memory.NewStorage(), base: b}
+
+	cfg := config.NewConfig()
+	err := cfg.Unmarshal(b.config)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode repo config: %v", err)
+	}
+	err = storage.SetConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not set repo config: %v", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	refs, err := b.repo.Storer.IterReferences()
+	if err != nil {
+		return nil, fmt.Errorf("could not list references: %v", err)
+	}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		return storage.SetReference(ref)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not copy references: %v", err)
+	}
+	if _, err := storage.Reference(plumbing.HEAD); err != nil {
+		err = storage.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+		if err != nil {
+			return nil, fmt.Errorf("could not set HEAD: %v", err)
+		}
+	}
+	shallow, err := b.repo.Storer.Shallow()
+	if err != nil {
+		return nil, fmt.Errorf("could not get shallow commits: %v", err)
+	}
+	err = storage.SetShallow(shallow)
+	if err != nil {
+		return nil, fmt.Errorf("could not set shallow commits: %v", err)
+	}
+
+	repo, err := git.Open(storage, memfs.New())
+	if err != nil {
+		return nil, fmt.Errorf("could not open branch repo: %v", err)
+	}
+
+	return repo, nil
+}
+
+// branchStorage keeps the references, index and new objects of a branch
+// in memory and reads the other objects from the base repository, which
+// the branches processed in parallel share without writing to it
+type branchStorage struct {
+	*memory.Storage
+	base *branchBase
+}
+
+func (s *branchStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.Storage.EncodedObject(t, h)
+	if err != plumbing.ErrObjectNotFound {
+		return obj, err
+	}
+
+	s.base.mu.Lock()
+	defer s.base.mu.Unlock()
+	return s.base.repo.Storer.EncodedObject(t, h)
+}
+
+func (s *branchStorage) HasEncodedObject(h plumbing.Hash) error {
+	err := s.Storage.HasEncodedObject(h)
+	if err != plumbing.ErrObjectNotFound {
+		return err
+	}
+
+	s.base.mu.Lock()
+	defer s.base.mu.Unlock()
+	return s.base.repo.Storer.HasEncodedObject(h)
+}
+
+func (s *branchStorage) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.Storage.EncodedObjectSize(h)
+	if err != plumbing.ErrObjectNotFound {
+		return size, err
+	}
+
+	s.base.mu.Lock()
+	defer s.base.mu.Unlock()
+	return s.base.repo.Storer.EncodedObjectSize(h)
+}
+
+func (s *branchStorage) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	own, err := s.Storage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s.base.mu.Lock()
+	defer s.base.mu.Unlock()
+	base, err := s.base.repo.Storer.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{own, base}), nil
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/rocky-linux/srpmproc/pkg/data"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -16,7 +15,7 @@ import (
 
 func Fetch(logger io.Writer, cdnUrl string, dir string, fs billy.Filesystem, storage blob.Storage) error {
 	pd := &data.ProcessData{
-		Log: log.New(logger, "", log.LstdFlags),
+		Log: data.NewLogger(logger, data.LevelInfo),
 	}
 
 	metadataPath := ""
@@ -51,21 +50,33 @@ func Fetch(logger io.Writer, cdnUrl string, dir string, fs billy.Filesystem, sto
 			DisableCompression: false,
 		},
 	}
+	root, err := fs.Chroot(dir)
+	if err != nil {
+		return fmt.Errorf("could not chroot to %s: %v", dir, err)
+	}
+
 	fileContent := strings.Split(string(fileBytes), "\n")
-	for _, line := range fileContent {
+	for i, line := range fileContent {
 		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
 		lineInfo := strings.SplitN(line, " ", 2)
 		hash := strings.TrimSpace(lineInfo[0])
-		path := strings.TrimSpace(lineInfo[1])
+		path, err := data.SanitizeSourcePath(strings.TrimSpace(lineInfo[1]))
+		if err != nil {
+			return fmt.Errorf("invalid path on line %d of %s (%q): %v", i+1, metadataPath, line, err)
+		}
+		err = data.CheckSourcePathInFs(root, path)
+		if err != nil {
+			return fmt.Errorf("refusing to write line %d of %s: %v", i+1, metadataPath, err)
+		}
 
 		url := fmt.Sprintf("%s/%s", cdnUrl, hash)
 		if storage != nil {
 			url = hash
 		}
-		pd.Log.Printf("downloading %s", url)
+		pd.Log.Info("downloading blob", "url", url)
 
 		var body []byte
 
@@ -80,6 +91,7 @@ func Fetch(logger io.Writer, cdnUrl string, dir string, fs billy.Filesystem, sto
 				return fmt.Errorf("could not create new http request: %v", err)
 			}
 			req.Header.Set("Accept-Encoding", "*")
+			req.Header.Set("User-Agent", pd.HTTPUserAgent())
 
 			resp, err := client.Do(req)
 			if err != nil {
@@ -0,0 +1,149 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// packageGraph holds the dependencies between the packages of a batch
+// by their index. Dependencies on packages outside of the batch are
+// considered satisfied.
+type packageGraph struct {
+	names      []string
+	dependents [][]int
+	pending    []int
+}
+
+func newPackageGraph(pds []*data.ProcessData) *packageGraph {
+	g := &packageGraph{
+		names:      make([]string, len(pds)),
+		dependents: make([][]int, len(pds)),
+		pending:    make([]int, len(pds)),
+	}
+
+	indexes := map[string][]int{}
+	for i, pd := range pds {
+		g.names[i] = data.PackageName(pd.RpmLocation)
+		indexes[g.names[i]] = append(indexes[g.names[i]], i)
+	}
+	for i, pd := range pds {
+		seen := map[int]bool{}
+		for _, dependency := range pd.DependsOn {
+			for _, j := range indexes[dependency] {
+				if seen[j] {
+					continue
+				}
+				seen[j] = true
+				g.dependents[j] = append(g.dependents[j], i)
+				g.pending[i]++
+			}
+		}
+	}
+
+	return g
+}
+
+// ready returns the packages without any dependencies
+func (g *packageGraph) ready() []int {
+	var ready []int
+	for i, pending := range g.pending {
+		if pending == 0 {
+			ready = append(ready, i)
+		}
+	}
+	return ready
+}
+
+// done marks package i as imported and returns the
+// packages that have no pending dependencies left
+func (g *packageGraph) done(i int) []int {
+	var ready []int
+	for _, j := range g.dependents[i] {
+		g.pending[j]--
+		if g.pending[j] == 0 {
+			ready = append(ready, j)
+		}
+	}
+	return ready
+}
+
+// cycle returns a dependency cycle through the packages that still
+// have pending dependencies, starting at package i
+func (g *packageGraph) cycle(pds []*data.ProcessData, i int) []string {
+	indexes := map[string][]int{}
+	for j, name := range g.names {
+		if g.pending[j] > 0 {
+			indexes[name] = append(indexes[name], j)
+		}
+	}
+
+	// every package with pending dependencies depends on another one,
+	// so following them has to come back to a package already visited
+	visited := map[int]int{}
+	var path []string
+	for {
+		if start, ok := visited[i]; ok {
+			return append(path[start:], g.names[i])
+		}
+		visited[i] = len(path)
+		path = append(path, g.names[i])
+
+		next := -1
+		for _, dependency := range pds[i].DependsOn {
+			if found := indexes[dependency]; len(found) > 0 {
+				next = found[0]
+				break
+			}
+		}
+		if next == -1 {
+			return path
+		}
+		i = next
+	}
+}
+
+// ImportOrder returns the indexes of pds sorted so that every package
+// comes after the packages it depends on through DependsOn.
+// A *data.DependencyCycleError is returned if packages depend
+// on each other.
+func ImportOrder(pds []*data.ProcessData) ([]int, error) {
+	g := newPackageGraph(pds)
+
+	var order []int
+	queue := g.ready()
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		order = append(order, i)
+		queue = append(queue, g.done(i)...)
+	}
+
+	if len(order) < len(pds) {
+		for i, pending := range g.pending {
+			if pending > 0 {
+				return nil, &data.DependencyCycleError{Cycle: g.cycle(pds, i)}
+			}
+		}
+	}
+
+	return order, nil
+}
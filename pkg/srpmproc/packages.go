@@ -0,0 +1,163 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// ProcessPackages runs ProcessRPM for every package in pds using up to
+// concurrency workers. All packages share one blob cache, so blobs that
+// several packages reference are only retrieved once, and packages
+// without a blob storage use the first one configured. The results are
+// returned in the order of pds, each with its own error. Every package
+// is imported with a copy of its ProcessData, so the same ProcessData
+// may be passed several times and pds are left untouched.
+//
+// Packages are only started once the packages they depend on through
+// DependsOn are imported, independent packages are imported in
+// parallel. If an import fails, the packages depending on it fail with
+// a *data.DependencyFailedError, and packages depending on each other
+// fail with a *data.DependencyCycleError.
+func ProcessPackages(pds []*data.ProcessData, concurrency int) []data.ProcessResult {
+	return ProcessPackagesContext(context.Background(), pds, concurrency)
+}
+
+// ProcessPackagesContext is like ProcessPackages, but imports in flight
+// are aborted once ctx is done and packages not started yet fail with
+// the error of ctx
+func ProcessPackagesContext(ctx context.Context, pds []*data.ProcessData, concurrency int) []data.ProcessResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var blobCacheMaxBytes int64
+	var compressBlobCache bool
+	var sharedStorage *data.ProcessData
+	for _, pd := range pds {
+		if pd.BlobStorage != nil && sharedStorage == nil {
+			sharedStorage = pd
+		}
+		if pd.BlobCacheMaxBytes > blobCacheMaxBytes {
+			blobCacheMaxBytes = pd.BlobCacheMaxBytes
+		}
+		compressBlobCache = compressBlobCache || pd.CompressBlobCache
+	}
+	blobCache := data.NewBlobCache(blobCacheMaxBytes)
+	if compressBlobCache {
+		blobCache = data.NewCompressedBlobCache(blobCacheMaxBytes)
+	}
+
+	results := make([]data.ProcessResult, len(pds))
+
+	graph := newPackageGraph(pds)
+
+	indexes := make(chan int, len(pds))
+	done := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				pd := pds[i].Copy()
+				pd.FetchedRepo = pds[i].FetchedRepo
+				pd.BareTarget = pds[i].BareTarget
+				if pd.BlobStorage == nil && sharedStorage != nil {
+					pd.BlobStorage = sharedStorage.BlobStorage
+				}
+				if pd.SharedBlobCache == nil {
+					pd.SharedBlobCache = blobCache
+				}
+
+				var res *srpmprocpb.ProcessResponse
+				start := time.Now()
+				err := ctx.Err()
+				if err == nil {
+					res, err = ProcessRPMContext(ctx, pd)
+				}
+				results[i] = data.ProcessResult{
+					Package:  pd.RpmLocation,
+					Response: res,
+					Reports:  pd.BranchReports(),
+					Duration: time.Since(start),
+					Err:      err,
+				}
+				done <- i
+			}
+		}()
+	}
+
+	finished := make([]bool, len(pds))
+	var skip func(i int)
+	skip = func(i int) {
+		for _, j := range graph.dependents[i] {
+			if finished[j] {
+				continue
+			}
+			finished[j] = true
+			results[j] = data.ProcessResult{
+				Package: pds[j].RpmLocation,
+				Err:     &data.DependencyFailedError{Package: graph.names[j], Dependency: graph.names[i]},
+			}
+			skip(j)
+		}
+	}
+
+	inFlight := 0
+	for _, i := range graph.ready() {
+		indexes <- i
+		inFlight++
+	}
+	for inFlight > 0 {
+		i := <-done
+		inFlight--
+		finished[i] = true
+		if results[i].Err != nil {
+			skip(i)
+			continue
+		}
+		for _, j := range graph.done(i) {
+			if !finished[j] {
+				indexes <- j
+				inFlight++
+			}
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i := range pds {
+		if !finished[i] {
+			results[i] = data.ProcessResult{
+				Package: pds[i].RpmLocation,
+				Err:     &data.DependencyCycleError{Cycle: graph.cycle(pds, i)},
+			}
+		}
+	}
+
+	return results
+}
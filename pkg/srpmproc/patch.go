@@ -21,12 +21,12 @@
 package srpmproc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/rocky-linux/srpmproc/pkg/misc"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -45,7 +45,7 @@ import (
 	"google.golang.org/protobuf/encoding/prototext"
 )
 
-func cfgPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func cfgPatches(ctx context.Context, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	// check CFG patches
 	_, err := patchTree.Filesystem.Stat("ROCKY/CFG")
 	if err == nil {
@@ -61,7 +61,8 @@ func cfgPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree
 				continue
 			}
 
-			pd.Log.Printf("applying directive %s", info.Name())
+			pd.Log.Info("applying directive", "directive", info.Name())
+			md.AppliedDirectives = append(md.AppliedDirectives, info.Name())
 			filePath := filepath.Join("ROCKY/CFG", info.Name())
 			directive, err := patchTree.Filesystem.Open(filePath)
 			if err != nil {
@@ -78,7 +79,7 @@ func cfgPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree
 				return fmt.Errorf("could not unmarshal cfg file: %v", err)
 			}
 
-			errs := directives.Apply(&cfg, pd, md, patchTree, pushTree)
+			errs := directives.Apply(ctx, &cfg, pd, md, patchTree, pushTree)
 			if errs != nil {
 				err := json.NewEncoder(os.Stdout).Encode(errs)
 				if err != nil {
@@ -93,11 +94,11 @@ func cfgPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree
 	return nil
 }
 
-func applyPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
+func applyPatches(ctx context.Context, pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktree, pushTree *git.Worktree) error {
 	// check if patches exist
 	_, err := patchTree.Filesystem.Stat("ROCKY")
 	if err == nil {
-		err := cfgPatches(pd, md, patchTree, pushTree)
+		err := cfgPatches(ctx, pd, md, patchTree, pushTree)
 		if err != nil {
 			return err
 		}
@@ -106,7 +107,7 @@ func applyPatches(pd *data.ProcessData, md *data.ModeData, patchTree *git.Worktr
 	return nil
 }
 
-func executePatchesRpm(pd *data.ProcessData, md *data.ModeData) error {
+func executePatchesRpm(ctx context.Context, pd *data.ProcessData, md *data.ModeData) error {
 	// fetch patch repository
 	repo, err := git.Init(memory.NewStorage(), memfs.New())
 	if err != nil {
@@ -137,23 +138,23 @@ func executePatchesRpm(pd *data.ProcessData, md *data.ModeData) error {
 	if !strings.HasPrefix(pd.UpstreamPrefix, "http") {
 		fetchOptions.Auth = pd.Authenticator
 	}
-	err = repo.Fetch(fetchOptions)
+	err = repo.FetchContext(ctx, fetchOptions)
 
 	refName := plumbing.NewBranchReferenceName(md.PushBranch)
-	pd.Log.Printf("set reference to ref: %s", refName)
+	pd.Log.Debug("set reference", "ref", refName)
 
 	if err != nil {
 		if err == transport.ErrInvalidAuthMethod || err == transport.ErrAuthenticationRequired {
 			fetchOptions.Auth = nil
-			err = repo.Fetch(fetchOptions)
+			err = repo.FetchContext(ctx, fetchOptions)
 			if err != nil {
 				// no patches active
-				log.Println("info: patch repo not found")
+				pd.Log.Info("patch repo not found", "branch", md.PushBranch)
 				return nil
 			}
 		} else {
 			// no patches active
-			log.Println("info: patch repo not found")
+			pd.Log.Info("patch repo not found", "branch", md.PushBranch)
 			return nil
 		}
 	}
@@ -164,12 +165,12 @@ func executePatchesRpm(pd *data.ProcessData, md *data.ModeData) error {
 	})
 	// common patches found, apply them
 	if err == nil {
-		err := applyPatches(pd, md, w, md.Worktree)
+		err := applyPatches(ctx, pd, md, w, md.Worktree)
 		if err != nil {
 			return err
 		}
 	} else {
-		log.Println("info: no common patches found")
+		pd.Log.Info("no common patches found")
 	}
 
 	err = w.Checkout(&git.CheckoutOptions{
@@ -178,12 +179,12 @@ func executePatchesRpm(pd *data.ProcessData, md *data.ModeData) error {
 	})
 	// branch specific patches found, apply them
 	if err == nil {
-		err := applyPatches(pd, md, w, md.Worktree)
+		err := applyPatches(ctx, pd, md, w, md.Worktree)
 		if err != nil {
 			return err
 		}
 	} else {
-		log.Println("info: no branch specific patches found")
+		pd.Log.Info("no branch specific patches found", "branch", md.PushBranch)
 	}
 
 	return nil
@@ -210,9 +211,9 @@ func getTipStream(pd *data.ProcessData, module string, pushBranch string, origPu
 		Auth: pd.Authenticator,
 	})
 	if err != nil {
-		pd.Log.Printf("could not import module: %s", module)
+		pd.Log.Warn("could not import module", "module", module)
 		if tries < 3 {
-			pd.Log.Printf("could not get rpm refs. will retry in 3s. %v", err)
+			pd.Log.Warn("could not get rpm refs, will retry in 3s", "error", err)
 			time.Sleep(3 * time.Second)
 			return getTipStream(pd, module, pushBranch, origPushBranch, tries+1)
 		}
@@ -272,7 +273,7 @@ func getTipStream(pd *data.ProcessData, module string, pushBranch string, origPu
 
 	if tipHash == "" {
 		for _, ref := range list {
-			log.Println(pushBranch, ref.Name())
+			pd.Log.Debug("ref does not match", "branch", pushBranch, "ref", ref.Name())
 		}
 		return "", fmt.Errorf("could not find tip hash")
 	}
@@ -327,14 +328,13 @@ func patchModuleYaml(pd *data.ProcessData, md *data.ModeData) error {
 	// Get stream branch from tag
 	// (in tagless mode we are trusting the "Stream: <VERSION>" text in the source YAML to be accurate)
 	if !pd.TaglessMode {
-		match := misc.GetTagImportRegex(pd).FindStringSubmatch(md.TagBranch)
+		match := misc.MatchImportTag(pd, md.TagBranch)
 		streamBranch := strings.Split(match[2], "-")
 		// Force stream to be the same as stream name in branch
 		module.Data.Stream = streamBranch[len(streamBranch)-1]
 	}
-	log.Println("This module contains the following rpms:")
 	for name := range module.Data.Components.Rpms {
-		pd.Log.Printf("\t- %s", name)
+		pd.Log.Info("module contains rpm", "rpm", name)
 	}
 
 	defaultBranch := md.PushBranch
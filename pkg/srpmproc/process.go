@@ -22,7 +22,12 @@ package srpmproc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
@@ -40,11 +45,11 @@ import (
 	"github.com/rocky-linux/srpmproc/pkg/rpmutils"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -55,6 +60,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/rocky-linux/srpmproc/pkg/data"
+	"golang.org/x/crypto/openpgp"
 )
 
 const (
@@ -97,11 +103,219 @@ type ProcessDataRequest struct {
 	CdnUrl               string
 	LogWriter            io.Writer
 
+	// Minimum level of logged records (debug, info, warn or error)
+	// and their format (text or json), info and text by default
+	LogLevel  string
+	LogFormat string
+
 	PackageVersion string
 	PackageRelease string
 
 	TaglessMode  bool
 	AltLookAside bool
+
+	// Rewrite sources already in the worktree. If false, sources whose
+	// checksum matches the metadata are kept and not downloaded again.
+	// The command line defaults it to true.
+	OverwriteExisting   bool
+	BlobCacheMaxBytes   int64
+	SourcesManifestPath string
+
+	// TLS settings for lookaside downloads, system defaults are used when unset
+	TlsCaFile   string
+	TlsCertFile string
+	TlsKeyFile  string
+
+	// Offline imports: SnapshotPath is read instead of the upstream repository
+	// and lookaside, SnapshotExportPath receives a snapshot for later offline runs
+	SnapshotPath       string
+	SnapshotExportPath string
+
+	LookasideETags bool
+
+	// Additional remote each import is pushed to, Force allows
+	// non fast-forward updates
+	PushTargetUrl   string
+	PushTargetForce bool
+
+	// Import this upstream commit SHA instead of the latest tags
+	ImportCommit string
+
+	// Limits requests to each upstream host, unlimited if zero
+	RequestsPerSecond float64
+
+	// What to do with uncommitted changes in the worktree when checking
+	// out upstream: "force" (default), "keep" or "error-on-dirty"
+	CheckoutStrategy string
+
+	// Keep upstream file modes on written sources and set their
+	// modification time to this unix timestamp if non-zero
+	PreserveFileModes bool
+	SourceMtime       int64
+
+	// Metadata file of the previous import, sources with unchanged
+	// hashes are not downloaded again
+	PreviousMetadataPath string
+
+	// Credentials for the lookaside cache, a token takes
+	// precedence over basic auth
+	LookasideUsername string
+	LookasidePassword string
+	LookasideToken    string
+
+	// Bearer tokens for other lookaside hosts, like the CDN the
+	// lookaside cache redirects to, keyed by host
+	LookasideHostTokens map[string]string
+
+	// Redirects followed by lookaside downloads: at most MaxRedirects
+	// (default 10, negative disables them), optionally only within a host
+	MaxRedirects      int
+	SameHostRedirects bool
+
+	// Lookaside mirrors tried in order when CdnUrl does not serve a blob
+	SourceCacheURLs []string
+
+	// Write sources below this directory (e.g. SOURCES) instead
+	// of the paths from the metadata file
+	SourceSubdir string
+
+	// Import the newest tags of any version, Version is ignored
+	LatestVersion bool
+
+	// Only import sources matching IncludeSources (if set) and none
+	// of ExcludeSources, globs match the path or the file name
+	IncludeSources []string
+	ExcludeSources []string
+
+	// Check blobs exist with a HEAD request before downloading them
+	PreflightHead bool
+
+	// Refspecs fetched from upstream instead of every branch
+	// (e.g. +refs/heads/c9s:refs/remotes/c9s)
+	FetchRefSpecs []string
+
+	// Refuse to download blobs larger than this many bytes, or decompress
+	// zstd blobs to more than it, 0 means unlimited
+	MaxBlobSize int64
+
+	// Only warn instead of failing when a metadata file lists
+	// the same path twice with different hashes
+	AllowDuplicates bool
+
+	// Expected hashes replacing the ones from the metadata file,
+	// keyed by source path or file name
+	HashOverrides map[string]string
+
+	// text/template for import commit messages, see data.CommitMessageData
+	// for the available fields ({{.Message}} is the default message)
+	CommitMessageTemplate string
+
+	// Write an rpkg/centpkg compatible sources file for the externalized
+	// sources, in the bsd (default) or legacy format
+	GenerateSourcesFile bool
+	SourcesFileFormat   string
+
+	// Fail instead of skipping upstream refs whose commit can't be resolved
+	StrictTagResolution bool
+
+	// Decompress zstd blobs whose hash is of the decompressed content
+	DecompressZstdBlobs bool
+
+	// Store blobs under their algorithm, like sha256/<hash>
+	NamespacedBlobs bool
+
+	// Directory of pre-fetched blobs named by hash,
+	// tried before blob storage and the lookaside cache
+	LocalSourceDir string
+
+	// Keyring (armored or binary) used to verify sources with a detached
+	// signature among the sources, optionally only warning on bad ones
+	SourceKeyringPath string
+	SignatureWarnOnly bool
+
+	// Warn about blob downloads slower than SlowDownloadThreshold
+	// bytes per second or taking longer than SlowDownloadSeconds
+	SlowDownloadThreshold int64
+	SlowDownloadSeconds   int
+
+	// Abort the import, cancelling fetches and downloads in
+	// flight, once it runs longer than this, 0 means no limit
+	MaxRunSeconds int
+
+	// Name of the upstream metadata file, %s is replaced with
+	// the package name (default .%s.metadata)
+	MetadataFilePattern string
+
+	// Extra upstream repositories as name=url, whose import tags are
+	// merged with the upstream ones. If a branch exists in several
+	// remotes, the first in RemotePrecedence wins ("upstream" is the
+	// default remote), otherwise the newest tag does.
+	ExtraRemotes     map[string]string
+	RemotePrecedence []string
+
+	// Refuse to strip externalized sources that no longer match
+	// the content they were externalized with
+	VerifyBeforeStrip bool
+
+	// Commit imports straight into the bare repository at this path
+	// (created if missing) instead of pushing them to UpstreamPrefix
+	BareTargetPath string
+
+	// User-Agent of lookaside requests and git binary fetches,
+	// defaults to srpmproc/<version>
+	UserAgent string
+
+	// Keep fetched upstream repositories in this directory and reuse
+	// them on the next run, so only new objects are fetched
+	UpstreamCacheDir string
+
+	// Keep cached blobs gzip compressed in memory, trading CPU
+	// for memory when many compressible sources are cached
+	CompressBlobCache bool
+
+	// Names of packages that ProcessPackages has to import
+	// before this one if they are part of the same batch
+	DependsOn []string
+
+	// Commit a JSON record of where the upstream commit and every
+	// source of an import came from to this path
+	ProvenancePath string
+
+	// Lookaside branch names keyed by upstream git branch, for
+	// lookaside caches keyed by a different name (c9s=c9-stream)
+	LookasideBranches map[string]string
+
+	// Fetch upstream with the git binary over protocol v2, falling
+	// back to go-git if git is missing or the fetch fails. The v2
+	// fetch is anonymous, so a private upstream fails once and is
+	// then fetched by go-git with the credentials.
+	GitProtocolV2 bool
+
+	// Keep the externalized sources in the worktree after
+	// uploading them instead of stripping them
+	KeepLookasideSources bool
+
+	// Name of the registered import mode retrieving upstream
+	// sources, see data.RegisterMode (default git)
+	ImportMode string
+
+	// Import in memory and only log the commits, tags and blobs
+	// instead of pushing them or writing them to blob storage
+	DryRun bool
+
+	// Executables run at import phases, keyed by phase (pre-fetch,
+	// post-directives, pre-push or post-push), see data.ProcessData.RunHooks
+	Hooks map[string]string
+
+	// Only push the imported branches once every branch was imported,
+	// rolling back those pushed already if a push fails
+	AtomicImport bool
+
+	// Keep the completed branches and downloaded blobs of every
+	// package in this directory, so an interrupted import resumes
+	// where it stopped. Fetched upstream repositories are kept there
+	// as well unless UpstreamCacheDir is set.
+	CheckpointDir string
 }
 
 func gitlabify(str string) string {
@@ -138,6 +352,9 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 	if req.BranchPrefix == "" {
 		req.BranchPrefix = "r"
 	}
+	if req.ImportMode == "" {
+		req.ImportMode = modes.GitModeName
+	}
 	if req.CdnUrl == "" && !req.AltLookAside {
 		req.CdnUrl = "file:///srv/cache/lookaside2"
 	}
@@ -152,7 +369,11 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 		return nil, fmt.Errorf("package cannot be empty")
 	}
 
-	var importer data.ImportMode
+	importer, err := data.NewImportMode(req.ImportMode)
+	if err != nil {
+		return nil, err
+	}
+
 	var blobStorage blob.Storage
 
 	if strings.HasPrefix(req.StorageAddr, "gs://") {
@@ -175,7 +396,6 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 	} else {
 		sourceRpmLocation = fmt.Sprintf("%s/%s", req.RpmPrefix, req.Package)
 	}
-	importer = &modes.GitMode{}
 
 	lastKeyLocation := req.SshKeyLocation
 	if lastKeyLocation == "" {
@@ -188,7 +408,6 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 
 	var authenticator transport.AuthMethod
 
-	var err error
 	if req.HttpUsername != "" {
 		authenticator = &http.BasicAuth{
 			Username: req.HttpUsername,
@@ -217,10 +436,18 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 	if req.LogWriter != nil {
 		writer = req.LogWriter
 	}
-	logger := log.New(writer, "", log.LstdFlags)
+	logLevel, err := data.ParseLogLevel(req.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	logger, err := data.NewLoggerFormat(writer, logLevel, req.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With("package", req.Package)
 
 	if req.TmpFsMode != "" {
-		logger.Printf("using tmpfs dir: %s", req.TmpFsMode)
+		logger.Info("using tmpfs dir", "dir", req.TmpFsMode)
 		fsCreator = func(branch string) (billy.Filesystem, error) {
 			fs, err := reqFsCreator(branch)
 			if err != nil {
@@ -242,12 +469,138 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 		fsCreator = reqFsCreator
 	}
 
+	var tlsConfig *tls.Config
+	if req.TlsCaFile != "" || req.TlsCertFile != "" {
+		tlsConfig, err = newTlsConfig(req.TlsCaFile, req.TlsCertFile, req.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pushTarget *data.PushTarget
+	if req.PushTargetUrl != "" {
+		pushTarget = &data.PushTarget{
+			Url:   req.PushTargetUrl,
+			Force: req.PushTargetForce,
+		}
+	}
+
+	checkoutStrategy, err := data.ParseCheckoutStrategy(req.CheckoutStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var sourceMtime time.Time
+	if req.SourceMtime != 0 {
+		sourceMtime = time.Unix(req.SourceMtime, 0)
+	}
+
+	var previousSources map[string]string
+	if req.PreviousMetadataPath != "" {
+		previousMetadata, err := ioutil.ReadFile(req.PreviousMetadataPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read previous metadata: %v", err)
+		}
+		previousMetadata, err = data.Decompress(previousMetadata)
+		if err != nil {
+			return nil, err
+		}
+		previousSources = data.ParseMetadataHashes(previousMetadata)
+	}
+
+	var lookasideAuth *data.LookasideAuth
+	if req.LookasideToken != "" || req.LookasideUsername != "" {
+		lookasideAuth = &data.LookasideAuth{
+			Username: req.LookasideUsername,
+			Password: req.LookasidePassword,
+			Token:    req.LookasideToken,
+		}
+	}
+	var lookasideHostAuth map[string]*data.LookasideAuth
+	for host, token := range req.LookasideHostTokens {
+		if lookasideHostAuth == nil {
+			lookasideHostAuth = map[string]*data.LookasideAuth{}
+		}
+		lookasideHostAuth[host] = &data.LookasideAuth{Token: token}
+	}
+
+	var hostLimiter *data.HostLimiter
+	if req.RequestsPerSecond > 0 {
+		hostLimiter = data.NewHostLimiter(req.RequestsPerSecond)
+	}
+
+	var bareTarget *git.Repository
+	if req.BareTargetPath != "" && !req.DryRun {
+		bareTarget, err = openBareTarget(req.BareTargetPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	userAgent := req.UserAgent
+	if userAgent == "" {
+		userAgent = data.DefaultUserAgent()
+	}
+
+	var sourceKeyring openpgp.EntityList
+	if req.SourceKeyringPath != "" {
+		sourceKeyring, err = readKeyring(req.SourceKeyringPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var fetchRefSpecs []config.RefSpec
+	for _, refspec := range req.FetchRefSpecs {
+		fetchRefSpecs = append(fetchRefSpecs, config.RefSpec(refspec))
+	}
+
+	var lookasideBranchFunc data.LookasideBranchFunc
+	if len(req.LookasideBranches) > 0 {
+		lookasideBranchFunc = func(gitBranch string) string {
+			if branch, ok := req.LookasideBranches[gitBranch]; ok {
+				return branch
+			}
+			return gitBranch
+		}
+	}
+
+	// sorted, so the remotes are set up the same way on every run
+	var extraRemotes []data.UpstreamRemote
+	for name, url := range req.ExtraRemotes {
+		extraRemotes = append(extraRemotes, data.UpstreamRemote{Name: name, Url: url})
+	}
+	sort.Slice(extraRemotes, func(i, j int) bool {
+		return extraRemotes[i].Name < extraRemotes[j].Name
+	})
+
+	var hooks map[string][]string
+	for phase, hook := range req.Hooks {
+		if hooks == nil {
+			hooks = map[string][]string{}
+		}
+		hooks[phase] = append(hooks[phase], hook)
+	}
+
+	var checkpoint data.Checkpoint
+	upstreamCacheDir := req.UpstreamCacheDir
+	if req.CheckpointDir != "" {
+		fileCheckpoint, err := data.NewFileCheckpoint(req.CheckpointDir)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint = fileCheckpoint
+		if upstreamCacheDir == "" {
+			upstreamCacheDir = filepath.Join(req.CheckpointDir, "upstream")
+		}
+	}
+
 	var manualCs []string
 	if strings.TrimSpace(req.ManualCommits) != "" {
 		manualCs = strings.Split(req.ManualCommits, ",")
 	}
 
-	return &data.ProcessData{
+	pd := &data.ProcessData{
 		Importer:             importer,
 		RpmLocation:          sourceRpmLocation,
 		UpstreamPrefix:       req.UpstreamPrefix,
@@ -276,7 +629,107 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 		PackageRelease:       req.PackageRelease,
 		TaglessMode:          req.TaglessMode,
 		AltLookAside:         req.AltLookAside,
-	}, nil
+		OverwriteExisting:    req.OverwriteExisting,
+		BlobCacheMaxBytes:    req.BlobCacheMaxBytes,
+		SourcesManifestPath:  req.SourcesManifestPath,
+		TlsConfig:            tlsConfig,
+		SnapshotPath:         req.SnapshotPath,
+		SnapshotExportPath:   req.SnapshotExportPath,
+		LookasideETags:       req.LookasideETags,
+		PushTarget:           pushTarget,
+		ImportCommit:         req.ImportCommit,
+		HostLimiter:          hostLimiter,
+		CheckoutStrategy:     checkoutStrategy,
+		PreserveFileModes:    req.PreserveFileModes,
+		SourceMtime:          sourceMtime,
+		PreviousSources:      previousSources,
+		LookasideAuth:        lookasideAuth,
+		LookasideHostAuth:    lookasideHostAuth,
+		MaxRedirects:         req.MaxRedirects,
+		SameHostRedirects:    req.SameHostRedirects,
+		SourceCacheURLs:      req.SourceCacheURLs,
+		SourceSubdir:         req.SourceSubdir,
+		LatestVersion:        req.LatestVersion,
+		IncludeSources:       req.IncludeSources,
+		ExcludeSources:       req.ExcludeSources,
+		PreflightHead:        req.PreflightHead,
+		FetchRefSpecs:        fetchRefSpecs,
+		MaxBlobSize:          req.MaxBlobSize,
+		AllowDuplicates:      req.AllowDuplicates,
+		HashOverrides:        req.HashOverrides,
+		GenerateSourcesFile:  req.GenerateSourcesFile,
+		SourcesFileFormat:    req.SourcesFileFormat,
+		StrictTagResolution:  req.StrictTagResolution,
+		DecompressZstdBlobs:  req.DecompressZstdBlobs,
+		NamespacedBlobs:      req.NamespacedBlobs,
+		LocalSourceDir:       req.LocalSourceDir,
+		SourceKeyring:        sourceKeyring,
+		SignatureWarnOnly:    req.SignatureWarnOnly,
+		GitProtocolV2:        req.GitProtocolV2,
+		MaxRunDuration:       time.Duration(req.MaxRunSeconds) * time.Second,
+		MetadataFilePattern:  req.MetadataFilePattern,
+		ExtraRemotes:         extraRemotes,
+		RemotePrecedence:     req.RemotePrecedence,
+		VerifyBeforeStrip:    req.VerifyBeforeStrip,
+		BareTarget:           bareTarget,
+		UserAgent:            userAgent,
+		UpstreamCacheDir:     upstreamCacheDir,
+		CompressBlobCache:    req.CompressBlobCache,
+		DependsOn:            req.DependsOn,
+		ProvenancePath:       req.ProvenancePath,
+		LookasideBranchFunc:  lookasideBranchFunc,
+		DryRun:               req.DryRun,
+		AtomicImport:         req.AtomicImport,
+		Hooks:                hooks,
+		Checkpoint:           checkpoint,
+
+		CommitMessageTemplate: req.CommitMessageTemplate,
+		SlowDownloadThreshold: req.SlowDownloadThreshold,
+		SlowDownloadDuration:  time.Duration(req.SlowDownloadSeconds) * time.Second,
+		KeepLookasideSources:  req.KeepLookasideSources,
+	}
+
+	err = pd.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return pd, nil
+}
+
+// newTlsConfig trusts the CA bundle at caFile in addition to the system
+// roots and presents the client certificate from certFile/keyFile, if set
+func newTlsConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		caBytes, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" {
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
 }
 
 // ProcessRPM checks the RPM specs and discards any remote files
@@ -287,19 +740,60 @@ func NewProcessData(req *ProcessDataRequest) (*data.ProcessData, error) {
 // all files that are remote goes into .gitignore
 // all ignored files' hash goes into .{Name}.metadata
 func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
+	return ProcessRPMContext(context.Background(), pd)
+}
+
+// ProcessRPMContext is like ProcessRPM, but the import is aborted once
+// ctx is done. The context is propagated to upstream fetches, lookaside
+// downloads and blob storage operations, so those in flight are
+// cancelled as well. Imports running in parallel need their own pd,
+// see data.ProcessData.Copy, and data.ErrProcessDataInUse is returned
+// if pd is used by another import.
+func ProcessRPMContext(ctx context.Context, pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
+	if !pd.Acquire() {
+		return nil, data.ErrProcessDataInUse
+	}
+	defer pd.Release()
+
+	ctx, cancel := pd.StartRun(ctx)
+	defer cancel()
+
+	res, err := processRPM(ctx, pd)
+	if err != nil {
+		return nil, pd.TimeoutError(ctx, err)
+	}
+
+	return res, nil
+}
+
+func processRPM(ctx context.Context, pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
+	err := pd.Validate()
+	if err != nil {
+		return nil, err
+	}
 
 	// if we are using "tagless mode", then we need to jump to a completely different import process:
 	// Version info needs to be derived from rpmbuild + spec file, not tags
 	if pd.TaglessMode {
-		result, err := processRPMTagless(pd)
+		result, err := processRPMTagless(ctx, pd)
 		return result, err
 	}
 
-	md, err := pd.Importer.RetrieveSource(pd)
+	md, err := retrieveSource(ctx, pd)
 	if err != nil {
 		return nil, err
 	}
-	md.BlobCache = map[string][]byte{}
+	defer md.Close()
+	if pd.SharedBlobCache != nil {
+		md.UseSharedBlobCache(pd.SharedBlobCache)
+	} else {
+		md.BlobCache = pd.NewBlobCache()
+	}
+
+	if pd.LatestVersion && md.Version != 0 {
+		pd.Log.Info("resolved latest version", "version", md.Version)
+		pd.Version = md.Version
+	}
 
 	remotePrefix := "rpms"
 	if pd.ModuleMode {
@@ -314,7 +808,12 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 
 	// if no-dup-mode is enabled then skip already imported versions
 	var tagIgnoreList []string
-	if pd.NoDupMode {
+	if pd.NoDupMode && pd.BareTarget != nil {
+		tagIgnoreList, err = data.ImportTags(pd.BareTarget)
+		if err != nil {
+			return nil, err
+		}
+	} else if pd.NoDupMode {
 		repo, err := git.Init(memory.NewStorage(), memfs.New())
 		if err != nil {
 			return nil, fmt.Errorf("could not init git repo: %v", err)
@@ -335,7 +834,7 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			Auth: pd.Authenticator,
 		})
 		if err != nil {
-			log.Println("ignoring no-dup-mode")
+			pd.Log.Warn("could not list upstream refs, ignoring no-dup-mode", "error", err)
 		} else {
 			for _, ref := range list {
 				if !strings.HasPrefix(string(ref.Name()), "refs/tags/imports") {
@@ -352,7 +851,12 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 	commitPin := map[string]string{}
 
 	if pd.SingleTag != "" {
-		md.Branches = []string{fmt.Sprintf("refs/tags/%s", pd.SingleTag)}
+		md.Branches = []string{misc.NormalizeRef(fmt.Sprintf("refs/tags/%s", pd.SingleTag))}
+	} else if pd.ImportCommit != "" {
+		if !misc.IsCommitHash(pd.ImportCommit) {
+			return nil, fmt.Errorf("invalid import commit %s", pd.ImportCommit)
+		}
+		md.Branches = []string{pd.ImportCommit}
 	} else if len(pd.ManualCommits) > 0 {
 		md.Branches = []string{}
 		for _, commit := range pd.ManualCommits {
@@ -367,38 +871,84 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 		}
 	}
 
+	completed, err := pd.CompletedBranches(md.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	// branches of an atomic import, pushed once all are imported
+	var pending []*pendingBranch
+	var pendingBlobs []*pendingBlob
+
 	for _, branch := range md.Branches {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		md.Repo = &sourceRepo
 		md.Worktree = &sourceWorktree
 		md.TagBranch = branch
+		md.UploadedBlobs = nil
+		md.AppliedDirectives = nil
 		for _, source := range md.SourcesToIgnore {
 			source.Expired = true
 		}
 
 		var matchString string
-		if !misc.GetTagImportRegex(pd).MatchString(md.TagBranch) {
+		if misc.MatchImportTag(pd, md.TagBranch) == nil && !misc.IsCommitHash(md.TagBranch) {
 			if pd.ModuleMode {
-				prefix := fmt.Sprintf("refs/heads/%s%d", pd.ImportBranchPrefix, pd.Version)
-				if strings.HasPrefix(md.TagBranch, prefix) {
-					replace := strings.Replace(md.TagBranch, "refs/heads/", "", 1)
-					matchString = fmt.Sprintf("refs/tags/imports/%s/%s", replace, filepath.Base(pd.RpmLocation))
-					pd.Log.Printf("using match string: %s", matchString)
+				prefix := fmt.Sprintf("%s%d", pd.ImportBranchPrefix, pd.Version)
+				if heads, ok := misc.HeadsBranch(md.TagBranch); ok && strings.HasPrefix(heads, prefix) {
+					replace := heads
+					matchString = fmt.Sprintf("refs/tags/imports/%s/%s", replace, data.PackageName(pd.RpmLocation))
+					pd.Log.Debug("using match string", "match", matchString)
 				}
 			}
-			if !misc.GetTagImportRegex(pd).MatchString(matchString) {
+			if misc.MatchImportTag(pd, matchString) == nil {
 				continue
 			}
 		} else {
 			matchString = md.TagBranch
 		}
 
-		match := misc.GetTagImportRegex(pd).FindStringSubmatch(matchString)
+		var match []string
+		if misc.IsCommitHash(md.TagBranch) {
+			// commits are imported like a tag named after the commit
+			importBranch := fmt.Sprintf("%s%d%s", pd.ImportBranchPrefix, pd.Version, pd.BranchSuffix)
+			importName := pd.Importer.ImportName(pd, md)
+			match = []string{md.TagBranch, fmt.Sprintf("imports/%s/%s", importBranch, importName), importBranch, importName}
+		} else {
+			match = misc.MatchImportTag(pd, matchString)
+		}
 
 		md.PushBranch = pd.BranchPrefix + strings.TrimPrefix(match[2], pd.ImportBranchPrefix)
 
 		newTag := "imports/" + pd.BranchPrefix + strings.TrimPrefix(match[1], "imports/"+pd.ImportBranchPrefix)
 		newTag = strings.Replace(newTag, "%", "_", -1)
 
+		if commit, ok := completed[md.TagBranch]; ok {
+			pd.Log.Info("skipping branch, already imported according to checkpoint", "branch", md.TagBranch)
+			if commit != "" {
+				latestHashForBranch[md.PushBranch] = commit
+			}
+			continue
+		}
+
+		md.UpstreamTree = upstreamTree(&sourceRepo, md)
+
+		// unchanged branches are skipped before anything is checked out
+		if pd.NoDupMode {
+			skip, commit, err := alreadyImported(pd, md, newTag, tagIgnoreList)
+			if err != nil {
+				return nil, err
+			}
+			if skip {
+				if commit != "" {
+					latestHashForBranch[md.PushBranch] = commit
+				}
+				continue
+			}
+		}
+
 		createdFs, err := pd.FsCreator(md.PushBranch)
 		if err != nil {
 			return nil, err
@@ -414,63 +964,56 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			return nil, fmt.Errorf("could not get dist Worktree: %v", err)
 		}
 
-		shouldContinue := true
-		for _, ignoredTag := range tagIgnoreList {
-			if ignoredTag == "refs/tags/"+newTag {
-				pd.Log.Printf("skipping %s", ignoredTag)
-				shouldContinue = false
-			}
-		}
-		if !shouldContinue {
-			continue
-		}
-
-		// create a new remote
-		remoteUrl := fmt.Sprintf("%s/%s/%s.git", pd.UpstreamPrefix, remotePrefix, gitlabify(md.Name))
-		pd.Log.Printf("using remote: %s", remoteUrl)
-		refspec := config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", md.PushBranch, md.PushBranch))
-		pd.Log.Printf("using refspec: %s", refspec)
+		// a bare target is committed to directly, without checking
+		// out the previous import
+		if pd.BareTarget == nil {
+			// create a new remote
+			remoteUrl := fmt.Sprintf("%s/%s/%s.git", pd.UpstreamPrefix, remotePrefix, gitlabify(md.Name))
+			pd.Log.Debug("using remote", "url", remoteUrl)
+			refspec := config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", md.PushBranch, md.PushBranch))
+			pd.Log.Debug("using refspec", "refspec", refspec)
 
-		_, err = repo.CreateRemote(&config.RemoteConfig{
-			Name:  "origin",
-			URLs:  []string{remoteUrl},
-			Fetch: []config.RefSpec{refspec},
-		})
-		if err != nil {
-			return nil, fmt.Errorf("could not create remote: %v", err)
-		}
-
-		err = repo.Fetch(&git.FetchOptions{
-			RemoteName: "origin",
-			RefSpecs:   []config.RefSpec{refspec},
-			Auth:       pd.Authenticator,
-		})
+			_, err = repo.CreateRemote(&config.RemoteConfig{
+				Name:  "origin",
+				URLs:  []string{remoteUrl},
+				Fetch: []config.RefSpec{refspec},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create remote: %v", err)
+			}
 
-		refName := plumbing.NewBranchReferenceName(md.PushBranch)
-		pd.Log.Printf("set reference to ref: %s", refName)
+			err = repo.FetchContext(ctx, &git.FetchOptions{
+				RemoteName: "origin",
+				RefSpecs:   []config.RefSpec{refspec},
+				Auth:       pd.Authenticator,
+			})
 
-		var hash plumbing.Hash
-		if commitPin[md.PushBranch] != "" {
-			hash = plumbing.NewHash(commitPin[md.PushBranch])
-		}
+			refName := plumbing.NewBranchReferenceName(md.PushBranch)
+			pd.Log.Debug("set reference", "ref", refName)
 
-		if err != nil {
-			h := plumbing.NewSymbolicReference(plumbing.HEAD, refName)
-			if err := repo.Storer.CheckAndSetReference(h, nil); err != nil {
-				return nil, fmt.Errorf("could not set reference: %v", err)
+			var hash plumbing.Hash
+			if commitPin[md.PushBranch] != "" {
+				hash = plumbing.NewHash(commitPin[md.PushBranch])
 			}
-		} else {
-			err = w.Checkout(&git.CheckoutOptions{
-				Branch: plumbing.NewRemoteReferenceName("origin", md.PushBranch),
-				Hash:   hash,
-				Force:  true,
-			})
+
 			if err != nil {
-				return nil, fmt.Errorf("could not checkout: %v", err)
+				h := plumbing.NewSymbolicReference(plumbing.HEAD, refName)
+				if err := repo.Storer.CheckAndSetReference(h, nil); err != nil {
+					return nil, fmt.Errorf("could not set reference: %v", err)
+				}
+			} else {
+				err = w.Checkout(&git.CheckoutOptions{
+					Branch: plumbing.NewRemoteReferenceName("origin", md.PushBranch),
+					Hash:   hash,
+					Force:  true,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("could not checkout: %v", err)
+				}
 			}
 		}
 
-		err = pd.Importer.WriteSource(pd, md)
+		err = pd.Importer.WriteSource(ctx, pd, md)
 		if err != nil {
 			return nil, err
 		}
@@ -488,33 +1031,40 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 				return nil, err
 			}
 		} else {
-			err := executePatchesRpm(pd, md)
+			err := executePatchesRpm(ctx, pd, md)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		// get ignored files hash and add to .{Name}.metadata
-		metadataFile := ""
-		ls, err := md.Worktree.Filesystem.ReadDir(".")
+		err = md.ApplyFileWrites()
 		if err != nil {
-			return nil, fmt.Errorf("could not read directory: %v", err)
+			return nil, err
 		}
-		for _, f := range ls {
-			if strings.HasSuffix(f.Name(), ".metadata") {
-				if metadataFile != "" {
-					return nil, fmt.Errorf("multiple metadata files found")
-				}
-				metadataFile = f.Name()
-			}
+		err = md.VerifyFileWrites()
+		if err != nil {
+			return nil, err
 		}
-		if metadataFile == "" {
-			metadataFile = fmt.Sprintf(".%s.metadata", md.Name)
+		err = pd.RunHooks(ctx, pd.NewHookEvent(data.HookPostDirectives, md))
+		if err != nil {
+			return nil, err
+		}
+
+		err = data.ApplyExternalizePolicy(pd, md)
+		if err != nil {
+			return nil, err
+		}
+
+		// get ignored files hash and add to .{Name}.metadata
+		metadataFile, err := pd.FindMetadataFile(md.Worktree.Filesystem, md.Name)
+		if err != nil {
+			return nil, err
 		}
 		metadata, err := w.Filesystem.Create(metadataFile)
 		if err != nil {
 			return nil, fmt.Errorf("could not create metadata file: %v", err)
 		}
+		var sourcesLines []string
 		for _, source := range md.SourcesToIgnore {
 			sourcePath := source.Name
 
@@ -543,20 +1093,31 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			if err != nil {
 				return nil, fmt.Errorf("could not write to metadata file: %v", err)
 			}
+			sourcesLines = append(sourcesLines, pd.SourcesFileLine(source.HashName(), checksum, sourcePath))
 
 			if data.StrContains(alreadyUploadedBlobs, checksum) {
 				continue
 			}
-			exists, err := pd.BlobStorage.Exists(checksum)
+			blobKey := pd.BlobKey(source.HashName(), checksum)
+			exists, err := blob.ExistsContext(ctx, pd.BlobStorage, blobKey)
 			if err != nil {
 				return nil, err
 			}
 			if !exists && !pd.NoStorageUpload {
-				err := pd.BlobStorage.Write(checksum, sourceFileBts)
-				if err != nil {
-					return nil, err
+				if pd.AtomicImport && !pd.DryRun {
+					// uploaded by finalizeBranches after the push
+					pendingBlobs = append(pendingBlobs, &pendingBlob{key: blobKey, content: sourceFileBts})
+					md.UploadedBlobs = append(md.UploadedBlobs, blobKey)
+				} else {
+					err := pd.WriteBlob(ctx, blobKey, sourceFileBts)
+					if err != nil {
+						return nil, err
+					}
+					if !pd.DryRun {
+						md.UploadedBlobs = append(md.UploadedBlobs, blobKey)
+						pd.Log.Info("wrote blob to blob storage", "hash", blobKey)
+					}
 				}
-				pd.Log.Printf("wrote %s to blob storage", checksum)
 			}
 			alreadyUploadedBlobs = append(alreadyUploadedBlobs, checksum)
 		}
@@ -566,6 +1127,13 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			return nil, fmt.Errorf("could not add metadata file: %v", err)
 		}
 
+		if pd.GenerateSourcesFile {
+			err = writeSourcesFile(w, sourcesLines)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		lastFilesToAdd := []string{".gitignore", "SPECS"}
 		for _, f := range lastFilesToAdd {
 			_, err := w.Filesystem.Stat(f)
@@ -589,14 +1157,14 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			continue
 		}
 
-		err = pd.Importer.PostProcess(md)
+		err = pd.Importer.PostProcess(ctx, pd, md)
 		if err != nil {
 			return nil, err
 		}
 
 		// show status
 		status, _ := w.Status()
-		pd.Log.Printf("successfully processed:\n%s", status)
+		pd.Log.Info("successfully processed", "branch", md.PushBranch, "status", status)
 
 		statusLines := strings.Split(status.String(), "\n")
 		for _, line := range statusLines {
@@ -610,15 +1178,48 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			}
 		}
 
+		if pd.BareTarget != nil && !pd.DryRun {
+			if pd.AtomicImport {
+				pending = append(pending, newPendingBranch(md, repo, newTag, nil, "", "", plumbing.ZeroHash))
+				continue
+			}
+
+			err = pushHook(ctx, pd, md, data.HookPrePush, "", newTag)
+			if err != nil {
+				return nil, err
+			}
+			hashString, tagHash, err := commitToBare(pd, md, repo, newTag)
+			if err != nil {
+				return nil, err
+			}
+			err = pushHook(ctx, pd, md, data.HookPostPush, hashString, newTag)
+			if err != nil {
+				return nil, err
+			}
+			latestHashForBranch[md.PushBranch] = hashString
+			err = reportBranch(pd, md, hashString, newTag, tagHash)
+			if err != nil {
+				return nil, err
+			}
+
+			err = pd.SaveCheckpoint(md.Name, md.TagBranch, hashString)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		var hashes []plumbing.Hash
 		var pushRefspecs []config.RefSpec
 
 		head, err := repo.Head()
+		previous := plumbing.ZeroHash
 		if err != nil {
 			hashes = nil
 			pushRefspecs = append(pushRefspecs, "*:*")
 		} else {
-			pd.Log.Printf("tip %s", head.String())
+			previous = head.Hash()
+			pd.Log.Debug("found tip", "ref", head.String())
 			hashes = append(hashes, head.Hash())
 			refOrigin := "refs/heads/" + md.PushBranch
 			pushRefspecs = append(pushRefspecs, config.RefSpec(fmt.Sprintf("HEAD:%s", refOrigin)))
@@ -626,7 +1227,11 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 
 		// we are now finished with the tree and are going to push it to the src Repo
 		// create import commit
-		commit, err := w.Commit("import "+pd.Importer.ImportName(pd, md), &git.CommitOptions{
+		message, err := pd.CommitMessage(md, "import "+pd.Importer.ImportName(pd, md))
+		if err != nil {
+			return nil, err
+		}
+		commit, err := w.Commit(message, &git.CommitOptions{
 			Author: &object.Signature{
 				Name:  pd.GitCommitterName,
 				Email: pd.GitCommitterEmail,
@@ -643,9 +1248,9 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 			return nil, fmt.Errorf("could not get commit object: %v", err)
 		}
 
-		pd.Log.Printf("committed:\n%s", obj.String())
+		pd.Log.Info("committed", "branch", md.PushBranch, "commit", obj.Hash.String())
 
-		_, err = repo.CreateTag(newTag, commit, &git.CreateTagOptions{
+		tagRef, err := repo.CreateTag(newTag, commit, &git.CreateTagOptions{
 			Tagger: &object.Signature{
 				Name:  pd.GitCommitterName,
 				Email: pd.GitCommitterEmail,
@@ -660,18 +1265,73 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 
 		pushRefspecs = append(pushRefspecs, config.RefSpec("HEAD:"+plumbing.NewTagReferenceName(newTag)))
 
-		err = repo.Push(&git.PushOptions{
-			RemoteName: "origin",
-			Auth:       pd.Authenticator,
-			RefSpecs:   pushRefspecs,
-			Force:      true,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("could not push to remote: %v", err)
+		if pd.DryRun {
+			err = reportDryRun(pd, md, obj, newTag)
+			if err != nil {
+				return nil, err
+			}
+		} else if pd.AtomicImport {
+			pending = append(pending, newPendingBranch(md, repo, newTag, pushRefspecs, obj.Hash.String(), tagRef.Hash().String(), previous))
+			continue
+		} else {
+			err = pushHook(ctx, pd, md, data.HookPrePush, obj.Hash.String(), newTag)
+			if err != nil {
+				return nil, err
+			}
+
+			err = repo.PushContext(ctx, &git.PushOptions{
+				RemoteName: "origin",
+				Auth:       pd.Authenticator,
+				RefSpecs:   pushRefspecs,
+				Force:      true,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not push to remote: %v", err)
+			}
+
+			if pd.PushTarget != nil {
+				_, err := pd.Push(ctx, md)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			err = pushHook(ctx, pd, md, data.HookPostPush, obj.Hash.String(), newTag)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		hashString := obj.Hash.String()
 		latestHashForBranch[md.PushBranch] = hashString
+		err = reportBranch(pd, md, hashString, newTag, tagRef.Hash().String())
+		if err != nil {
+			return nil, err
+		}
+
+		err = pd.SaveCheckpoint(md.Name, md.TagBranch, hashString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = finalizeBranches(ctx, pd, pending, pendingBlobs, latestHashForBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	// every branch is imported, a re-run starts over
+	// and does not need the blobs anymore
+	err = pd.ClearCheckpoint(md.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if pd.SnapshotExportPath != "" {
+		err := exportSnapshot(ctx, pd, &sourceRepo, md)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &srpmprocpb.ProcessResponse{
@@ -680,10 +1340,223 @@ func ProcessRPM(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
 	}, nil
 }
 
+// reportDryRun logs the commit and tag a dry run would have pushed
+// for md.PushBranch, along with the files the commit changed
+func reportDryRun(pd *data.ProcessData, md *data.ModeData, commit *object.Commit, tag string) error {
+	stats, err := commit.Stats()
+	if err != nil {
+		return fmt.Errorf("could not get changed files of %s: %v", commit.Hash, err)
+	}
+	var files []string
+	for _, stat := range stats {
+		files = append(files, stat.Name)
+	}
+
+	pd.Log.Info("dry run: not pushing", "branch", md.PushBranch, "commit", commit.Hash.String(), "tag", tag, "files", strings.Join(files, ","))
+
+	return nil
+}
+
+// upstreamTree returns the tree hash of the upstream commit of
+// md.TagBranch in repo, or "" if the commit is not known
+func upstreamTree(repo *git.Repository, md *data.ModeData) string {
+	commit := md.BranchCommits[md.TagBranch]
+	if commit == "" && misc.IsCommitHash(md.TagBranch) {
+		commit = md.TagBranch
+	}
+	if commit == "" {
+		return ""
+	}
+
+	obj, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err != nil {
+		return ""
+	}
+
+	return obj.TreeHash.String()
+}
+
+// alreadyImported reports whether md.TagBranch does not have to be
+// imported as newTag, because the target remote has newTag already or
+// because the import state records the same upstream tree as the latest
+// import of md.PushBranch. The commit of the previous import is returned
+// if known.
+func alreadyImported(pd *data.ProcessData, md *data.ModeData, newTag string, tagIgnoreList []string) (bool, string, error) {
+	for _, ignoredTag := range tagIgnoreList {
+		if ignoredTag == "refs/tags/"+newTag {
+			pd.Log.Info("skipping already imported tag", "tag", ignoredTag)
+			return true, "", nil
+		}
+	}
+
+	if md.UpstreamTree == "" {
+		return false, "", nil
+	}
+	latest, err := pd.LatestImport(md.Name, md.PushBranch)
+	if err != nil {
+		return false, "", err
+	}
+	if latest != nil && latest.UpstreamTree == md.UpstreamTree {
+		pd.Log.Info("skipping unchanged upstream tree", "tag", md.TagBranch, "tree", md.UpstreamTree, "imported", latest.SourceTag)
+		return true, latest.Commit, nil
+	}
+
+	return false, "", nil
+}
+
+// pushHook runs the hooks of phase around pushing commit,
+// tagged as tag, to md.PushBranch
+func pushHook(ctx context.Context, pd *data.ProcessData, md *data.ModeData, phase string, commit string, tag string) error {
+	event := pd.NewHookEvent(phase, md)
+	event.Commit = commit
+	event.Tag = tag
+
+	return pd.RunHooks(ctx, event)
+}
+
+// reportBranch records the report of the imported md.TagBranch
+// and records the import in the import state
+func reportBranch(pd *data.ProcessData, md *data.ModeData, commit string, tag string, tagHash string) error {
+	sourceTag := strings.TrimPrefix(md.TagBranch, "refs/tags/")
+	pd.ReportBranch(data.BranchReport{
+		SourceTag:     sourceTag,
+		Branch:        md.PushBranch,
+		Commit:        commit,
+		Tag:           tag,
+		TagHash:       tagHash,
+		UploadedBlobs: md.UploadedBlobs,
+		Directives:    md.AppliedDirectives,
+	})
+
+	sources := map[string]string{}
+	for _, source := range md.SourcesToIgnore {
+		if !source.Expired && source.Checksum != "" {
+			sources[source.Name] = source.HashName() + ":" + source.Checksum
+		}
+	}
+
+	return pd.RecordImport(&data.ImportRecord{
+		Package:      md.Name,
+		SourceTag:    sourceTag,
+		Branch:       md.PushBranch,
+		Commit:       commit,
+		Tag:          tag,
+		UpstreamTree: md.UpstreamTree,
+		Sources:      sources,
+		ImportedAt:   time.Now(),
+	})
+}
+
+// commitToBare commits what is staged in repo to md.PushBranch of the
+// bare target and tags it as newTag, returning the new commit and tag
+func commitToBare(pd *data.ProcessData, md *data.ModeData, repo *git.Repository, newTag string) (string, string, error) {
+	message, err := pd.CommitMessage(md, "import "+pd.Importer.ImportName(pd, md))
+	if err != nil {
+		return "", "", err
+	}
+	signature := &object.Signature{
+		Name:  pd.GitCommitterName,
+		Email: pd.GitCommitterEmail,
+		When:  time.Now(),
+	}
+
+	commit, err := data.CommitIndex(repo, pd.BareTarget, md.PushBranch, message, signature)
+	if err != nil {
+		return "", "", err
+	}
+	pd.Log.Info("committed to the bare target", "branch", md.PushBranch, "commit", commit)
+
+	tagRef, err := pd.BareTarget.CreateTag(newTag, commit, &git.CreateTagOptions{
+		Tagger:  signature,
+		Message: "import " + md.TagBranch + " from " + pd.RpmLocation,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create tag: %v", err)
+	}
+
+	return commit.String(), tagRef.Hash().String(), nil
+}
+
+// openBareTarget opens the bare repository at path,
+// initializing it if there is none yet
+func openBareTarget(path string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(path, true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open bare target %s: %v", path, err)
+	}
+
+	return repo, nil
+}
+
+// readKeyring reads an armored or binary OpenPGP keyring
+func readKeyring(path string) (openpgp.EntityList, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %v", err)
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse keyring %s: %v", path, err)
+	}
+
+	return keyring, nil
+}
+
+// retrieveSource calls RetrieveSource of the importer. Failing to list
+// upstream refs is only fatal if no branch was found without the list.
+func retrieveSource(ctx context.Context, pd *data.ProcessData) (*data.ModeData, error) {
+	err := pd.RunHooks(ctx, pd.NewHookEvent(data.HookPreFetch, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := pd.Importer.RetrieveSource(ctx, pd)
+	if err != nil && md != nil && errors.Is(err, data.ErrListFailed) && len(md.Branches) > 0 {
+		pd.Log.Warn("continuing despite failed branches", "branches", len(md.Branches), "error", err)
+		return md, nil
+	}
+	if err != nil {
+		if md != nil {
+			_ = md.Close()
+		}
+		return nil, err
+	}
+
+	return md, nil
+}
+
+func exportSnapshot(ctx context.Context, pd *data.ProcessData, repo *git.Repository, md *data.ModeData) error {
+	f, err := os.Create(pd.SnapshotExportPath)
+	if err != nil {
+		return fmt.Errorf("could not create snapshot file: %v", err)
+	}
+	defer f.Close()
+
+	err = modes.ExportSnapshot(ctx, pd, &data.ModeData{
+		Repo:          repo,
+		BlobCache:     md.BlobCache,
+		ResolvedBlobs: md.ResolvedBlobs,
+	}, f)
+	if err != nil {
+		return fmt.Errorf("could not export snapshot: %v", err)
+	}
+	pd.Log.Info("exported snapshot", "path", pd.SnapshotExportPath)
+
+	return nil
+}
+
 // Process for when we want to import a tagless repo (like from CentOS Stream)
-//
-func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
-	pd.Log.Println("Tagless mode detected, attempting import of latest commit")
+func processRPMTagless(ctx context.Context, pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error) {
+	pd.Log.Info("tagless mode detected, attempting import of latest commit")
 
 	// In tagless mode, we *automatically* set StrictBranchMode to true
 	// Only the exact <PREFIX><VERSION><SUFFIX> branch should be pulled from the source repo
@@ -694,13 +1567,18 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 	latestHashForBranch := map[string]string{}
 	versionForBranch := map[string]*srpmprocpb.VersionRelease{}
 
-	md, err := pd.Importer.RetrieveSource(pd)
+	md, err := retrieveSource(ctx, pd)
 	if err != nil {
-		pd.Log.Println("Error detected in  RetrieveSource!")
+		pd.Log.Error("could not retrieve source", "error", err)
 		return nil, err
 	}
+	defer md.Close()
 
-	md.BlobCache = map[string][]byte{}
+	if pd.SharedBlobCache != nil {
+		md.UseSharedBlobCache(pd.SharedBlobCache)
+	} else {
+		md.BlobCache = pd.NewBlobCache()
+	}
 
 	// TODO: add tagless module support
 	remotePrefix := "rpms"
@@ -731,7 +1609,7 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 			Auth: pd.Authenticator,
 		})
 		if err != nil {
-			log.Println("ignoring no-dup-mode")
+			pd.Log.Warn("could not list upstream refs, ignoring no-dup-mode", "error", err)
 		} else {
 			for _, ref := range list {
 				if !strings.HasPrefix(string(ref.Name()), "refs/tags/imports") {
@@ -747,16 +1625,24 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 	localPath := ""
 
 	for _, branch := range md.Branches {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		md.Repo = &sourceRepo
 		md.Worktree = &sourceWorktree
 		md.TagBranch = branch
+		md.UploadedBlobs = nil
+		md.AppliedDirectives = nil
 
 		for _, source := range md.SourcesToIgnore {
 			source.Expired = true
 		}
 
 		// Create a temporary place to check out our tag/branch : /tmp/srpmproctmp_<PKG_NAME><RANDOMSTRING>/
-		localPath, _ = os.MkdirTemp("/tmp", fmt.Sprintf("srpmproctmp_%s", md.Name))
+		localPath, err = os.MkdirTemp("/tmp", fmt.Sprintf("srpmproctmp_%s", md.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not create temporary directory: %v", err)
+		}
 
 		if err := os.RemoveAll(localPath); err != nil {
 			return nil, fmt.Errorf("Could not remove previous temporary directory: %s", localPath)
@@ -764,20 +1650,25 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 		if err := os.Mkdir(localPath, 0755); err != nil {
 			return nil, fmt.Errorf("Could not create temporary directory: %s", localPath)
 		}
+		// removed on md.Close() in case the import fails halfway
+		md.TempDirs = append(md.TempDirs, localPath, fmt.Sprintf("%s_gitpush", localPath))
 
 		// Clone repo into the temporary path, but only the tag we're interested in:
 		// (TODO: will probably need to assign this a variable or use the md struct gitrepo object to perform a successful tag+push later)
-		_, _ = git.PlainClone(localPath, false, &git.CloneOptions{
+		_, err = git.PlainClone(localPath, false, &git.CloneOptions{
 			URL:           pd.RpmLocation,
 			SingleBranch:  true,
 			ReferenceName: plumbing.ReferenceName(branch),
 		})
+		if err != nil {
+			return nil, &data.FetchError{Url: pd.RpmLocation, Err: err}
+		}
 
 		// Now that we're cloned into localPath, we need to "covert" the import into the old format
 		// We want sources to become .PKGNAME.metadata, we want SOURCES and SPECS folders, etc.
-		repoFixed, _ := convertLocalRepo(md.Name, localPath)
-		if !repoFixed {
-			return nil, fmt.Errorf("Error converting repository into SOURCES + SPECS + .package.metadata format")
+		err = convertLocalRepo(md.Name, localPath)
+		if err != nil {
+			return nil, fmt.Errorf("could not convert repository into SOURCES + SPECS + .package.metadata format: %v", err)
 		}
 
 		// call extra function to determine the proper way to convert the tagless branch name.
@@ -788,9 +1679,9 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 
 		// get name-version-release of tagless repo, only if we're not a module repo:
 		if !pd.ModuleMode {
-			nvrString := getVersionFromSpec(md.Name, localPath, pd.Version)
-			if nvrString == "" {
-				return nil, fmt.Errorf("Error using rpm or rpmbuild to build SRPM and determine version info! (tagless mode)")
+			nvrString, err := getVersionFromSpec(ctx, pd, md.Name, localPath, pd.Version)
+			if err != nil {
+				return nil, fmt.Errorf("could not determine version info of tagless checkout: %v", err)
 			}
 
 			// Set version and release fields we extracted (name|version|release are separated by pipes)
@@ -800,7 +1691,7 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 			// Set full rpm version:  name-version-release (for tagging properly)
 			rpmVersion = fmt.Sprintf("%s-%s-%s", md.Name, pd.PackageVersion, pd.PackageRelease)
 
-			pd.Log.Println("Successfully determined version of tagless checkout: ", rpmVersion)
+			pd.Log.Info("determined version of tagless checkout", "version", rpmVersion)
 		} else {
 			// In case of module mode, we just set rpmVersion to the current date - that's what our tag will end up being
 			rpmVersion = time.Now().Format("2006-01-02")
@@ -832,7 +1723,7 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 		}
 
 		// fetch our branch data (md.PushBranch) into this new repo
-		err = pushRepo.Fetch(&git.FetchOptions{
+		err = pushRepo.FetchContext(ctx, &git.FetchOptions{
 			RemoteName: "origin",
 			RefSpecs:   []config.RefSpec{refspec},
 			Auth:       pd.Authenticator,
@@ -861,14 +1752,19 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 		md.Worktree = w
 
 		// Download lookaside sources (tarballs) into the push git repo:
-		err = pd.Importer.WriteSource(pd, md)
+		err = pd.Importer.WriteSource(ctx, pd, md)
+		if err != nil {
+			return nil, err
+		}
+
+		err = data.ApplyExternalizePolicy(pd, md)
 		if err != nil {
 			return nil, err
 		}
 
 		// Call function to upload source to target lookaside and
 		// ensure the sources are added to .gitignore
-		err = processLookasideSources(pd, md, localPath+"_gitpush")
+		err = processLookasideSources(ctx, pd, md, localPath+"_gitpush")
 		if err != nil {
 			return nil, err
 		}
@@ -880,19 +1776,32 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 				return nil, err
 			}
 		} else {
-			err := executePatchesRpm(pd, md)
+			err := executePatchesRpm(ctx, pd, md)
 			if err != nil {
 				return nil, err
 			}
 		}
 
+		err = md.ApplyFileWrites()
+		if err != nil {
+			return nil, err
+		}
+		err = md.VerifyFileWrites()
+		if err != nil {
+			return nil, err
+		}
+		err = pd.RunHooks(ctx, pd.NewHookEvent(data.HookPostDirectives, md))
+		if err != nil {
+			return nil, err
+		}
+
 		err = w.AddWithOptions(&git.AddOptions{All: true})
 		if err != nil {
 			return nil, fmt.Errorf("Error adding SOURCES/ , SPECS/ or .metadata file to commit list.")
 		}
 
 		status, err := w.Status()
-		pd.Log.Printf("successfully processed:\n%s", status)
+		pd.Log.Info("successfully processed", "branch", md.PushBranch, "status", status)
 
 		// assign tag for our new remote we're about to push (derived from the SRPM version)
 		newTag := "refs/tags/imports/" + md.PushBranch + "/" + rpmVersion
@@ -916,7 +1825,7 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 
 		if newRepo {
 			pushRefspecs = append(pushRefspecs, config.RefSpec("*:*"))
-			pd.Log.Printf("New remote repo detected, creating new remote branch")
+			pd.Log.Info("new remote repo detected, creating new remote branch", "branch", md.PushBranch)
 		}
 
 		// Identify specific references we want to push
@@ -925,7 +1834,11 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 		pushRefspecs = append(pushRefspecs, config.RefSpec(fmt.Sprintf("HEAD:%s", newTag)))
 
 		// Actually do the commit (locally)
-		commit, err := w.Commit("import from tagless source "+pd.Importer.ImportName(pd, md), &git.CommitOptions{
+		message, err := pd.CommitMessage(md, "import from tagless source "+pd.Importer.ImportName(pd, md))
+		if err != nil {
+			return nil, err
+		}
+		commit, err := w.Commit(message, &git.CommitOptions{
 			Author: &object.Signature{
 				Name:  pd.GitCommitterName,
 				Email: pd.GitCommitterEmail,
@@ -941,10 +1854,10 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 			return nil, fmt.Errorf("could not get commit object: %v", err)
 		}
 
-		pd.Log.Printf("Committed local repo tagless mode transform:\n%s", obj.String())
+		pd.Log.Info("committed tagless mode transform", "branch", md.PushBranch, "commit", obj.Hash.String())
 
 		// After commit, we will now tag our local repo on disk:
-		_, err = pushRepo.CreateTag(newTag, commit, &git.CreateTagOptions{
+		tagRef, err := pushRepo.CreateTag(newTag, commit, &git.CreateTagOptions{
 			Tagger: &object.Signature{
 				Name:  pd.GitCommitterName,
 				Email: pd.GitCommitterEmail,
@@ -957,25 +1870,48 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 			return nil, fmt.Errorf("could not create tag: %v", err)
 		}
 
-		pd.Log.Printf("Pushing these references to the remote:  %+v \n", pushRefspecs)
+		if pd.DryRun {
+			err = reportDryRun(pd, md, obj, newTag)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = pushHook(ctx, pd, md, data.HookPrePush, obj.Hash.String(), strings.TrimPrefix(newTag, "refs/tags/"))
+			if err != nil {
+				return nil, err
+			}
 
-		// Do the actual push to the remote target repository
-		err = pushRepo.Push(&git.PushOptions{
-			RemoteName: "origin",
-			Auth:       pd.Authenticator,
-			RefSpecs:   pushRefspecs,
-			Force:      true,
-		})
+			pd.Log.Info("pushing references to the remote", "refspecs", fmt.Sprint(pushRefspecs))
+
+			// Do the actual push to the remote target repository
+			err = pushRepo.PushContext(ctx, &git.PushOptions{
+				RemoteName: "origin",
+				Auth:       pd.Authenticator,
+				RefSpecs:   pushRefspecs,
+				Force:      true,
+			})
+
+			if err != nil {
+				return nil, fmt.Errorf("could not push to remote: %v", err)
+			}
+
+			err = pushHook(ctx, pd, md, data.HookPostPush, obj.Hash.String(), strings.TrimPrefix(newTag, "refs/tags/"))
+			if err != nil {
+				return nil, err
+			}
+		}
 
+		pd.BranchCompleted(md.TagBranch)
+		err = reportBranch(pd, md, obj.Hash.String(), strings.TrimPrefix(newTag, "refs/tags/"), tagRef.Hash().String())
 		if err != nil {
-			return nil, fmt.Errorf("could not push to remote: %v", err)
+			return nil, err
 		}
 
 		if err := os.RemoveAll(localPath); err != nil {
-			log.Printf("Error cleaning up temporary git checkout directory %s .  Non-fatal, continuing anyway...\n", localPath)
+			pd.Log.Warn("could not clean up temporary git checkout directory, continuing anyway", "dir", localPath, "error", err)
 		}
 		if err := os.RemoveAll(fmt.Sprintf("%s_gitpush", localPath)); err != nil {
-			log.Printf("Error cleaning up temporary git checkout directory %s .  Non-fatal, continuing anyway...\n", fmt.Sprintf("%s_gitpush", localPath))
+			pd.Log.Warn("could not clean up temporary git checkout directory, continuing anyway", "dir", fmt.Sprintf("%s_gitpush", localPath), "error", err)
 		}
 
 		// append our processed branch to the return structures:
@@ -1000,21 +1936,21 @@ func processRPMTagless(pd *data.ProcessData) (*srpmprocpb.ProcessResponse, error
 //   - metadata file is named .pkgname.metadata
 //   - metadata file has the old "<SHASUM>  SOURCES/<filename>"  format
 //   - SPECS/ and SOURCES/ exist and are populated correctly
-func convertLocalRepo(pkgName string, localRepo string) (bool, error) {
+func convertLocalRepo(pkgName string, localRepo string) error {
 
 	// Make sure we have a SPECS and SOURCES folder made:
 	if err := os.MkdirAll(fmt.Sprintf("%s/SOURCES", localRepo), 0755); err != nil {
-		return false, fmt.Errorf("Could not create SOURCES directory in: %s", localRepo)
+		return fmt.Errorf("could not create SOURCES directory in %s: %v", localRepo, err)
 	}
 
 	if err := os.MkdirAll(fmt.Sprintf("%s/SPECS", localRepo), 0755); err != nil {
-		return false, fmt.Errorf("Could not create SPECS directory in: %s", localRepo)
+		return fmt.Errorf("could not create SPECS directory in %s: %v", localRepo, err)
 	}
 
 	// Loop through each file/folder and operate accordingly:
 	files, err := ioutil.ReadDir(localRepo)
 	if err != nil {
-		return false, err
+		return fmt.Errorf("could not read %s: %v", localRepo, err)
 	}
 
 	for _, file := range files {
@@ -1026,10 +1962,9 @@ func convertLocalRepo(pkgName string, localRepo string) (bool, error) {
 
 		// If we have a metadata "sources" file, we need to read it and convert to the old .<pkgname>.metadata format
 		if file.Name() == "sources" {
-			convertStatus := convertMetaData(pkgName, localRepo)
-
-			if convertStatus != true {
-				return false, fmt.Errorf("Error converting sources metadata file to .metadata format")
+			err := convertMetaData(pkgName, localRepo)
+			if err != nil {
+				return fmt.Errorf("could not convert sources metadata file to .metadata format: %v", err)
 			}
 
 			continue
@@ -1039,24 +1974,28 @@ func convertLocalRepo(pkgName string, localRepo string) (bool, error) {
 		if strings.HasSuffix(file.Name(), ".spec") {
 			err := os.Rename(fmt.Sprintf("%s/%s", localRepo, file.Name()), fmt.Sprintf("%s/SPECS/%s", localRepo, file.Name()))
 			if err != nil {
-				return false, fmt.Errorf("Error moving .spec file to SPECS/")
+				return fmt.Errorf("could not move %s to SPECS/: %v", file.Name(), err)
 			}
+			continue
 		}
 
 		// if a file isn't skipped in one of the above checks, then it must be a file that belongs in SOURCES/
-		os.Rename(fmt.Sprintf("%s/%s", localRepo, file.Name()), fmt.Sprintf("%s/SOURCES/%s", localRepo, file.Name()))
+		err := os.Rename(fmt.Sprintf("%s/%s", localRepo, file.Name()), fmt.Sprintf("%s/SOURCES/%s", localRepo, file.Name()))
+		if err != nil {
+			return fmt.Errorf("could not move %s to SOURCES/: %v", file.Name(), err)
+		}
 	}
 
-	return true, nil
+	return nil
 }
 
 // Given a local "sources" metadata file (new CentOS Stream format), convert it into the older
 // classic CentOS style:  "<HASH>  SOURCES/<FILENAME>"
-func convertMetaData(pkgName string, localRepo string) bool {
+func convertMetaData(pkgName string, localRepo string) error {
 
 	lookAside, err := os.Open(fmt.Sprintf("%s/sources", localRepo))
 	if err != nil {
-		return false
+		return fmt.Errorf("could not open sources metadata file: %v", err)
 	}
 
 	// Split file into lines and start processing:
@@ -1075,7 +2014,7 @@ func convertMetaData(pkgName string, localRepo string) bool {
 
 		tmpLine := strings.Fields(scanner.Text())
 		// make sure line starts with a "SHA" or "MD" before processing - otherwise it might not be a valid format lookaside line!
-		if !(strings.HasPrefix(tmpLine[0], "SHA") || strings.HasPrefix(tmpLine[0], "MD")) {
+		if len(tmpLine) < 4 || !(strings.HasPrefix(tmpLine[0], "SHA") || strings.HasPrefix(tmpLine[0], "MD")) {
 			continue
 		}
 
@@ -1087,13 +2026,15 @@ func convertMetaData(pkgName string, localRepo string) bool {
 		convertedLA = append(convertedLA, fmt.Sprintf("%s %s", tmpLine[3], tmpLine[1]))
 
 	}
-	lookAside.Close()
+	_ = lookAside.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read sources metadata file: %v", err)
+	}
 
 	// open .<NAME>.metadata file for writing our old-format lines
 	lookAside, err = os.OpenFile(fmt.Sprintf("%s/.%s.metadata", localRepo, pkgName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		fmt.Errorf("Error opening new .metadata file for writing.")
-		return false
+		return fmt.Errorf("could not open new .metadata file for writing: %v", err)
 	}
 
 	writer := bufio.NewWriter(lookAside)
@@ -1102,55 +2043,64 @@ func convertMetaData(pkgName string, localRepo string) bool {
 		_, _ = writer.WriteString(convertedLine + "\n")
 	}
 
-	writer.Flush()
-	lookAside.Close()
+	err = writer.Flush()
+	if err != nil {
+		_ = lookAside.Close()
+		return fmt.Errorf("could not write .metadata file: %v", err)
+	}
+	err = lookAside.Close()
+	if err != nil {
+		return fmt.Errorf("could not close .metadata file: %v", err)
+	}
 
 	// Remove old "sources" metadata file - we don't need it now that conversion is complete
-	os.Remove(fmt.Sprintf("%s/sources", localRepo))
+	err = os.Remove(fmt.Sprintf("%s/sources", localRepo))
+	if err != nil {
+		return fmt.Errorf("could not remove sources metadata file: %v", err)
+	}
 
-	return true
+	return nil
 }
 
 // Given a local checked out folder and package name, including SPECS/ , SOURCES/ , and .package.metadata, this will:
 //   - create a "dummy" SRPM (using dummy sources files we use to populate tarballs from lookaside)
 //   - extract RPM version info from that SRPM, and return it
+//
 // If we are in tagless mode, we need to get a package version somehow!
-func getVersionFromSpec(pkgName string, localRepo string, majorVersion int) string {
+func getVersionFromSpec(ctx context.Context, pd *data.ProcessData, pkgName string, localRepo string, majorVersion int) (string, error) {
 
 	// Make sure we have "rpm" and "rpmbuild" and "cp" available in our PATH.  Otherwise, this won't work:
-	_, err := exec.LookPath("rpm")
-	if err != nil {
-		return ""
-	}
-
-	_, err = exec.LookPath("rpmbuild")
-	if err != nil {
-		return ""
-	}
-
-	_, err = exec.LookPath("cp")
-	if err != nil {
-		return ""
+	for _, bin := range []string{"rpm", "rpmbuild", "cp"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return "", fmt.Errorf("could not find %s: %v", bin, err)
+		}
 	}
 
 	// create separate temp folder space to do our RPM work - we don't want to accidentally contaminate the main Git area:
 	rpmBuildPath := fmt.Sprintf("%s_rpm", localRepo)
-	os.Mkdir(rpmBuildPath, 0755)
+	if err := os.Mkdir(rpmBuildPath, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("could not create %s: %v", rpmBuildPath, err)
+	}
+	// Clean up: delete the temporary directory
+	defer func() {
+		if err := os.RemoveAll(rpmBuildPath); err != nil {
+			pd.Log.Warn("could not clean up temporary RPM directory, continuing anyway", "dir", rpmBuildPath, "error", err)
+		}
+	}()
 
 	// Copy SOURCES/ and SPECS/ into the temp rpmbuild directory recursively
 	// Yes, we could create or import an elaborate Go-native way to do this, but damnit this is easier:
 	cmdArgs := strings.Fields(fmt.Sprintf("cp -rp %s/SOURCES %s/SPECS %s/", localRepo, localRepo, rpmBuildPath))
-	if err := exec.Command(cmdArgs[0], cmdArgs[1:]...).Run(); err != nil {
-		log.Println(err)
-		return ""
+	if err := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...).Run(); err != nil {
+		return "", fmt.Errorf("could not copy SOURCES and SPECS to %s: %v", rpmBuildPath, err)
 	}
 
 	// Loop through .<package>.metadata and get the file names we need to make our SRPM:
 	lookAside, err := os.Open(fmt.Sprintf("%s/.%s.metadata", localRepo, pkgName))
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", fmt.Errorf("could not open .metadata file: %v", err)
 	}
+	defer lookAside.Close()
 
 	// Split file into lines and start processing:
 	scanner := bufio.NewScanner(lookAside)
@@ -1162,66 +2112,57 @@ func getVersionFromSpec(pkgName string, localRepo string, majorVersion int) stri
 	for scanner.Scan() {
 
 		// lookaside source is always the 2nd part of the line (after the long SHA sum)
-		srcFile := strings.Fields(scanner.Text())[1]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		srcFile := fields[1]
 
 		// write a dummy file of the same name into the rpmbuild SOURCES/ directory:
-		dummyFile, err := os.OpenFile(fmt.Sprintf("%s/%s", rpmBuildPath, srcFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		err := ioutil.WriteFile(fmt.Sprintf("%s/%s", rpmBuildPath, srcFile), []byte("This is a dummy lookaside file generated by srpmproc.  It is only needed to get a working SRPM and extract version information.  Please disregard\n"), 0644)
 		if err != nil {
-			return ""
+			return "", fmt.Errorf("could not write dummy source %s: %v", srcFile, err)
 		}
-		writer := bufio.NewWriter(dummyFile)
-		_, _ = writer.WriteString("This is a dummy lookaside file generated by srpmproc.  It is only needed to get a working SRPM and extract version information.  Please disregard\n")
-		writer.Flush()
-		dummyFile.Close()
 	}
-
-	lookAside.Close()
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("could not read .metadata file: %v", err)
+	}
 
 	// Now, call rpmbuild to produce the dummy src file:
 	// Example:  rpmbuild  --define "_topdir  /tmp/srpmproctmp_httpd1988142783_rpm"  -bs /tmp/srpmproctmp_httpd1988142783_rpm/SPECS/*.spec
-	cmd := exec.Command("rpmbuild", fmt.Sprintf(`--define=_topdir  %s`, rpmBuildPath), fmt.Sprintf(`--define=dist  .el%d`, majorVersion), "-bs", fmt.Sprintf("%s/SPECS/%s.spec", rpmBuildPath, pkgName))
-	if err := cmd.Run(); err != nil {
-		log.Println(err)
-		return ""
+	cmd := exec.CommandContext(ctx, "rpmbuild", fmt.Sprintf(`--define=_topdir  %s`, rpmBuildPath), fmt.Sprintf(`--define=dist  .el%d`, majorVersion), "-bs", fmt.Sprintf("%s/SPECS/%s.spec", rpmBuildPath, pkgName))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("rpmbuild failed: %v: %s", err, string(output))
 	}
 
 	// Read the first file from the SRPMS/ folder in rpmBuildPath.  It should be the SRPM that rpmbuild produced above
 	// (there should only be one file - we check that it ends in ".rpm" just to be sure!)
 	lsTmp, err := ioutil.ReadDir(fmt.Sprintf("%s/SRPMS/", rpmBuildPath))
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", fmt.Errorf("could not read dummy SRPMS directory: %v", err)
 	}
-
-	srpmFile := lsTmp[0].Name()
-
-	if !strings.HasSuffix(srpmFile, ".rpm") {
-		log.Println("Error, file found in dummy SRPMS directory did not have an .rpm extension!  Perhaps rpmbuild didn't produce a proper source RPM?")
-		return ""
+	if len(lsTmp) == 0 || !strings.HasSuffix(lsTmp[0].Name(), ".rpm") {
+		return "", fmt.Errorf("no .rpm file in dummy SRPMS directory, perhaps rpmbuild didn't produce a proper source RPM?")
 	}
+	srpmFile := lsTmp[0].Name()
 
 	// Call the rpm binary to extract the version-release info out of it, and tack on ".el<VERSION>" at the end:
-	cmd = exec.Command("rpm", "-qp", "--qf", `%{NAME}|%{VERSION}|%{RELEASE}\n`, fmt.Sprintf("%s/SRPMS/%s", rpmBuildPath, srpmFile))
+	cmd = exec.CommandContext(ctx, "rpm", "-qp", "--qf", `%{NAME}|%{VERSION}|%{RELEASE}\n`, fmt.Sprintf("%s/SRPMS/%s", rpmBuildPath, srpmFile))
 	nvrTmp, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Println("Error running rpm command to extract temporary SRPM name-version-release identifiers.")
-		log.Println("rpmbuild output: ", string(nvrTmp))
-		log.Println("rpmbuild command: ", cmd.String())
-		return ""
+		return "", fmt.Errorf("could not extract name-version-release of temporary SRPM with %s: %v: %s", cmd.String(), err, string(nvrTmp))
 	}
 
 	// Pull first line of the rpm command's output to get the name-version-release number (there should only be 1 line)
-	nvr := string(nvrTmp)
-	nvr = strings.Fields(nvr)[0]
-
-	// Clean up: delete the temporary directory
-	if err := os.RemoveAll(rpmBuildPath); err != nil {
-		log.Printf("Error cleaning up temporary RPM directory %s .  Non-fatal, continuing anyway...\n", rpmBuildPath)
+	fields := strings.Fields(string(nvrTmp))
+	if len(fields) == 0 || len(strings.Split(fields[0], "|")) != 3 {
+		return "", fmt.Errorf("unexpected rpm output %q", string(nvrTmp))
 	}
+	nvr := fields[0]
 
 	// return name-version-release string we derived:
-	log.Printf("Derived NVR %s from tagless repo via temporary SRPM build\n", nvr)
-	return nvr
+	pd.Log.Info("derived NVR from tagless repo via temporary SRPM build", "nvr", nvr)
+	return nvr, nil
 
 }
 
@@ -1229,7 +2170,7 @@ func getVersionFromSpec(pkgName string, localRepo string, majorVersion int) stri
 // and upload them to our target storage (usually an S3 bucket, but could be a local folder)
 //
 // We also need to add the source paths to .gitignore in the git repo, so we don't accidentally commit + push them
-func processLookasideSources(pd *data.ProcessData, md *data.ModeData, localDir string) error {
+func processLookasideSources(ctx context.Context, pd *data.ProcessData, md *data.ModeData, localDir string) error {
 
 	w := md.Worktree
 	metadata, err := w.Filesystem.Create(fmt.Sprintf(".%s.metadata", md.Name))
@@ -1239,6 +2180,7 @@ func processLookasideSources(pd *data.ProcessData, md *data.ModeData, localDir s
 
 	// Keep track of files we've already uploaded - don't want duplicates!
 	var alreadyUploadedBlobs []string
+	var sourcesLines []string
 
 	gitIgnore, err := os.OpenFile(fmt.Sprintf("%s/.gitignore", localDir), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -1273,20 +2215,25 @@ func processLookasideSources(pd *data.ProcessData, md *data.ModeData, localDir s
 		if err != nil {
 			return fmt.Errorf("could not write to metadata file: %v", err)
 		}
+		sourcesLines = append(sourcesLines, pd.SourcesFileLine(source.HashName(), checksum, sourcePath))
 
 		if data.StrContains(alreadyUploadedBlobs, checksum) {
 			continue
 		}
-		exists, err := pd.BlobStorage.Exists(checksum)
+		blobKey := pd.BlobKey(source.HashName(), checksum)
+		exists, err := blob.ExistsContext(ctx, pd.BlobStorage, blobKey)
 		if err != nil {
 			return err
 		}
 		if !exists && !pd.NoStorageUpload {
-			err := pd.BlobStorage.Write(checksum, sourceFileBts)
+			err := pd.WriteBlob(ctx, blobKey, sourceFileBts)
 			if err != nil {
 				return err
 			}
-			pd.Log.Printf("wrote %s to blob storage", checksum)
+			if !pd.DryRun {
+				md.UploadedBlobs = append(md.UploadedBlobs, blobKey)
+				pd.Log.Info("wrote blob to blob storage", "hash", blobKey)
+			}
 		}
 		alreadyUploadedBlobs = append(alreadyUploadedBlobs, checksum)
 
@@ -1306,10 +2253,33 @@ func processLookasideSources(pd *data.ProcessData, md *data.ModeData, localDir s
 		return err
 	}
 
+	if pd.GenerateSourcesFile {
+		err = writeSourcesFile(w, sourcesLines)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 
 }
 
+// writeSourcesFile writes the rpkg/centpkg compatible sources file
+// listing the externalized sources and adds it to w
+func writeSourcesFile(w *git.Worktree, lines []string) error {
+	err := data.WriteFileAtomic(w.Filesystem, "sources", []byte(strings.Join(lines, "")), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write sources file: %v", err)
+	}
+
+	_, err = w.Add("sources")
+	if err != nil {
+		return fmt.Errorf("could not add sources file: %v", err)
+	}
+
+	return nil
+}
+
 // Given an input branch name to import from, like "refs/heads/c9s", produce the tagless branch name we want to commit to, like "r9s"
 // Modular translation of CentOS stream branches i is also done - branch stream-maven-3.8-rhel-9.1.0  ---->  r9s-stream-maven-3.8_9.1.0
 func taglessBranchName(fullBranch string, pd *data.ProcessData) string {
@@ -0,0 +1,297 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package srpmproc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// writeFiles creates files with their content below dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles returns the paths of all files below dir
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, rel)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+func TestConvertLocalRepo(t *testing.T) {
+	sha := strings.Repeat("a", 64)
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		missing  bool
+		want     []string
+		metadata string
+		err      string
+	}{
+		{
+			name: "stream layout",
+			files: map[string]string{
+				"pkg.spec":     "Name: pkg\n",
+				"fix.patch":    "--- a\n",
+				"sources":      "SHA512 (pkg-1.0.tar.gz) = " + sha + "\n",
+				".gitignore":   "*.tar.gz\n",
+				"SPECS/.keep":  "",
+				"SOURCES/.old": "",
+			},
+			want:     []string{".gitignore", ".pkg.metadata", "SOURCES/.old", "SOURCES/fix.patch", "SPECS/.keep", "SPECS/pkg.spec"},
+			metadata: sha + " SOURCES/pkg-1.0.tar.gz\n",
+		},
+		{
+			name: "already converted",
+			files: map[string]string{
+				".pkg.metadata":   sha + " SOURCES/pkg-1.0.tar.gz\n",
+				"SPECS/pkg.spec":  "Name: pkg\n",
+				"SOURCES/a.patch": "--- a\n",
+			},
+			want:     []string{".pkg.metadata", "SOURCES/a.patch", "SPECS/pkg.spec"},
+			metadata: sha + " SOURCES/pkg-1.0.tar.gz\n",
+		},
+		{
+			name:    "missing repo",
+			missing: true,
+			err:     "could not create SOURCES directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.missing {
+				// a file in the way of the repo directory
+				dir = filepath.Join(dir, "repo")
+				writeFiles(t, filepath.Dir(dir), map[string]string{"repo": ""})
+			}
+			writeFiles(t, dir, tt.files)
+
+			err := convertLocalRepo("pkg", dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := listFiles(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+			metadata, err := ioutil.ReadFile(filepath.Join(dir, ".pkg.metadata"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(metadata) != tt.metadata {
+				t.Errorf("expected metadata %q, got %q", tt.metadata, metadata)
+			}
+		})
+	}
+}
+
+func TestConvertMetaData(t *testing.T) {
+	sha := strings.Repeat("a", 64)
+	md5 := strings.Repeat("b", 32)
+
+	tests := []struct {
+		name     string
+		sources  *string
+		metadata string
+		err      string
+	}{
+		{
+			name:     "sha512 and md5",
+			sources:  stringPtr("SHA512 (pkg-1.0.tar.gz) = " + sha + "\nMD5 (extra.zip) = " + md5 + "\n"),
+			metadata: sha + " SOURCES/pkg-1.0.tar.gz\n" + md5 + " SOURCES/extra.zip\n",
+		},
+		{
+			name:     "invalid lines are skipped",
+			sources:  stringPtr("\n# comment\n" + sha + " pkg-1.0.tar.gz\nSHA512 (pkg-1.0.tar.gz) = " + sha + "\n"),
+			metadata: sha + " SOURCES/pkg-1.0.tar.gz\n",
+		},
+		{
+			name: "missing sources file",
+			err:  "could not open sources metadata file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.sources != nil {
+				writeFiles(t, dir, map[string]string{"sources": *tt.sources})
+			}
+
+			err := convertMetaData("pkg", dir)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			metadata, err := ioutil.ReadFile(filepath.Join(dir, ".pkg.metadata"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(metadata) != tt.metadata {
+				t.Errorf("expected metadata %q, got %q", tt.metadata, metadata)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "sources")); !os.IsNotExist(err) {
+				t.Errorf("expected the sources file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// usePath replaces PATH with a directory holding the given shell
+// scripts, and cp, for the rest of the test
+func usePath(t *testing.T, scripts map[string]string) {
+	dir := t.TempDir()
+	if cp, err := exec.LookPath("cp"); err == nil {
+		err = os.Symlink(cp, filepath.Join(dir, "cp"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, script := range scripts {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orig := os.Getenv("PATH")
+	err := os.Setenv("PATH", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Setenv("PATH", orig)
+	})
+}
+
+func TestGetVersionFromSpecErrors(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh is not available")
+	}
+	repo := map[string]string{
+		".pkg.metadata":  strings.Repeat("a", 64) + " SOURCES/pkg-1.0.tar.gz\n",
+		"SPECS/pkg.spec": "Name: pkg\n",
+		"SOURCES/.keep":  "",
+	}
+
+	tests := []struct {
+		name    string
+		scripts map[string]string
+		files   map[string]string
+		err     string
+	}{
+		{
+			name:  "rpm missing",
+			files: repo,
+			err:   "could not find rpm",
+		},
+		{
+			name:    "rpmbuild missing",
+			scripts: map[string]string{"rpm": "exit 0"},
+			files:   repo,
+			err:     "could not find rpmbuild",
+		},
+		{
+			name:    "metadata missing",
+			scripts: map[string]string{"rpm": "exit 0", "rpmbuild": "exit 0"},
+			files:   map[string]string{"SPECS/pkg.spec": "Name: pkg\n", "SOURCES/.keep": ""},
+			err:     "could not open .metadata file",
+		},
+		{
+			name:    "rpmbuild failing",
+			scripts: map[string]string{"rpm": "exit 0", "rpmbuild": "echo bad spec; exit 1"},
+			files:   repo,
+			err:     "rpmbuild failed: exit status 1: bad spec",
+		},
+		{
+			name:    "no source rpm built",
+			scripts: map[string]string{"rpm": "exit 0", "rpmbuild": "exit 0"},
+			files:   repo,
+			err:     "could not read dummy SRPMS directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "repo")
+			writeFiles(t, dir, tt.files)
+			usePath(t, tt.scripts)
+			pd := &data.ProcessData{Log: data.NewLogger(ioutil.Discard, data.LevelInfo)}
+
+			nvr, err := getVersionFromSpec(context.Background(), pd, "pkg", dir, 9)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %q, %v", tt.err, nvr, err)
+			}
+			if _, err := os.Stat(dir + "_rpm"); !os.IsNotExist(err) {
+				t.Errorf("expected the temporary RPM directory to be removed, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package srpmproc imports upstream packages and modules into a
+// target git forge, patching them on the way.
+//
+// Run is the entrypoint for embedding srpmproc: it takes the same
+// options as the command line through ProcessDataRequest and returns
+// a Result. Callers needing more control build a data.ProcessData
+// with NewProcessData, adjust it and pass it to ProcessRPM, or import
+// several packages at once with RunPackages or ProcessPackages.
+package srpmproc
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	srpmprocpb "github.com/rocky-linux/srpmproc/pb"
+	"github.com/rocky-linux/srpmproc/pkg/data"
+)
+
+// Result is the outcome of a Run, it encodes to the JSON
+// document written by --result-file
+type Result struct {
+	// Package is the upstream location that was imported
+	Package string `json:"package"`
+	// Branches lists the imported branches sorted by name
+	Branches []BranchResult `json:"branches"`
+	// Duration is how long the import took
+	Duration time.Duration `json:"duration_ns"`
+	// Response is the response of ProcessRPM the result was built from
+	Response *srpmprocpb.ProcessResponse `json:"-"`
+}
+
+// BranchResult describes an imported branch. The import details are
+// only known for branches imported in this run, not for those skipped
+// because a checkpoint marks them as imported already.
+type BranchResult struct {
+	Branch  string `json:"branch"`
+	Commit  string `json:"commit"`
+	Version string `json:"version,omitempty"`
+	Release string `json:"release,omitempty"`
+
+	SourceTag     string   `json:"source_tag,omitempty"`
+	Tag           string   `json:"tag,omitempty"`
+	TagHash       string   `json:"tag_hash,omitempty"`
+	UploadedBlobs []string `json:"uploaded_blobs,omitempty"`
+	Directives    []string `json:"directives,omitempty"`
+}
+
+// Run imports the package described by req. The import is aborted,
+// cancelling fetches and downloads in flight, once ctx is done.
+func Run(ctx context.Context, req *ProcessDataRequest) (*Result, error) {
+	pd, err := NewProcessData(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return RunProcessData(ctx, pd)
+}
+
+// RunProcessData is like Run for an already built pd
+func RunProcessData(ctx context.Context, pd *data.ProcessData) (*Result, error) {
+	start := time.Now()
+	res, err := ProcessRPMContext(ctx, pd)
+	if err != nil {
+		return nil, err
+	}
+
+	result := newResult(pd.RpmLocation, pd.BranchReports(), res)
+	result.Duration = time.Since(start)
+
+	return result, nil
+}
+
+// PackageResult is the outcome of importing one package
+// of RunPackages, either Result or Err is set
+type PackageResult struct {
+	Package string  `json:"package"`
+	Result  *Result `json:"result,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Err     error   `json:"-"`
+}
+
+// RunPackages imports pds with up to concurrency workers like
+// ProcessPackagesContext and returns their results in the order of pds
+func RunPackages(ctx context.Context, pds []*data.ProcessData, concurrency int) []PackageResult {
+	var results []PackageResult
+	for _, processResult := range ProcessPackagesContext(ctx, pds, concurrency) {
+		result := PackageResult{Package: processResult.Package}
+		if processResult.Err != nil {
+			result.Err = processResult.Err
+			result.Error = processResult.Err.Error()
+		} else {
+			result.Result = newResult(processResult.Package, processResult.Reports, processResult.Response)
+			result.Result.Duration = processResult.Duration
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func newResult(location string, branchReports []data.BranchReport, res *srpmprocpb.ProcessResponse) *Result {
+	// the last import of a branch is the one in the response
+	reports := map[string]data.BranchReport{}
+	for _, report := range branchReports {
+		reports[report.Branch] = report
+	}
+
+	result := &Result{Package: location, Response: res}
+	for branch, commit := range res.GetBranchCommits() {
+		branchResult := BranchResult{
+			Branch: branch,
+			Commit: commit,
+		}
+		if version := res.GetBranchVersions()[branch]; version != nil {
+			branchResult.Version = version.Version
+			branchResult.Release = version.Release
+		}
+		if report, ok := reports[branch]; ok {
+			branchResult.SourceTag = report.SourceTag
+			branchResult.Tag = report.Tag
+			branchResult.TagHash = report.TagHash
+			branchResult.UploadedBlobs = report.UploadedBlobs
+			branchResult.Directives = report.Directives
+		}
+		result.Branches = append(result.Branches, branchResult)
+	}
+	sort.Slice(result.Branches, func(i, j int) bool {
+		return result.Branches[i].Branch < result.Branches[j].Branch
+	})
+
+	return result
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2021 The Srpmproc Authors
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package statedb keeps the import state of srpmproc in an
+// embedded bbolt database file
+package statedb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rocky-linux/srpmproc/pkg/data"
+	bolt "go.etcd.io/bbolt"
+)
+
+// importsBucket holds a bucket per package, keyed
+// by source tag in which the imports are stored
+var importsBucket = []byte("imports")
+
+// DB is an import state database, it implements data.ImportState.
+// A database file can only be opened by one process at a time.
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if missing
+func Open(path string) (*DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open state database %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(importsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not initialize state database %s: %v", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+func (d *DB) RecordImport(record *data.ImportRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not encode import: %v", err)
+	}
+
+	return d.db.Update(func(tx *bolt.Tx) error {
+		pkg, err := tx.Bucket(importsBucket).CreateBucketIfNotExists([]byte(record.Package))
+		if err != nil {
+			return err
+		}
+
+		return pkg.Put([]byte(record.SourceTag), content)
+	})
+}
+
+func (d *DB) LookupImport(name string, sourceTag string) (*data.ImportRecord, error) {
+	var record *data.ImportRecord
+	err := d.db.View(func(tx *bolt.Tx) error {
+		pkg := tx.Bucket(importsBucket).Bucket([]byte(name))
+		if pkg == nil {
+			return nil
+		}
+		content := pkg.Get([]byte(sourceTag))
+		if content == nil {
+			return nil
+		}
+
+		record = &data.ImportRecord{}
+		return json.Unmarshal(content, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Imports returns the imports of the package name, or of every
+// package if name is empty, oldest first
+func (d *DB) Imports(name string) ([]*data.ImportRecord, error) {
+	var records []*data.ImportRecord
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(importsBucket).ForEach(func(pkgName []byte, _ []byte) error {
+			if name != "" && string(pkgName) != name {
+				return nil
+			}
+
+			return tx.Bucket(importsBucket).Bucket(pkgName).ForEach(func(_ []byte, content []byte) error {
+				record := &data.ImportRecord{}
+				err := json.Unmarshal(content, record)
+				if err != nil {
+					return err
+				}
+				records = append(records, record)
+
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ImportedAt.Before(records[j].ImportedAt)
+	})
+
+	return records, nil
+}